@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 )
 
 var (
@@ -73,6 +74,13 @@ func (name NoSuchOpError) Error() string {
 //   span open. A semantic span marks something like a function call.
 // - 0x0b span close: its required parameter marks an address as a semantic
 //   span close.
+// - 0x0c max depth: its optional parameter declares a limit on how many
+//   generations of machine copies may be made; a copy that would exceed the
+//   limit halts with a fork depth limit error instead of running. Default: 0.
+// - 0x0d source map: its required parameter is the count of how many
+//   addr/source pairs follow this option, encoded like addr labels. Each
+//   source is a position, like "file.svm:3:5", from which the code at (and
+//   after) its address was assembled.
 // - 0x7f version: reserved for future use, where its parameter will be the
 //   required machine/program version; passing a version value is currently
 //   unsupported.
@@ -119,7 +127,9 @@ func Handler(h MachHandler) MachBuildOpt {
 		const pagesPerMachineGuess = 4
 		n := int(mb.queueSize)
 		mb.Mach.ctx.MachHandler = h
-		mb.Mach.ctx.queue = newRunq(n)
+		if mb.Mach.ctx.queue == noQueue {
+			mb.Mach.ctx.queue = newRunq(n)
+		}
 		mb.Mach.ctx.machAllocator = makeMachFreeList(n)
 		mb.Mach.ctx.pageAllocator = makePageFreeList(n * pagesPerMachineGuess)
 		if mb.maxCopies > 0 {
@@ -129,6 +139,29 @@ func Handler(h MachHandler) MachBuildOpt {
 	}
 }
 
+// Queue passes a MachQueue to a New()ly built machine, which is used instead
+// of the default capped lifo queue setup by Handler. This gives the host
+// control over which pending copy runs next; a queue that is never dequeued
+// from suits a host that steps each machine itself, such as a debugger.
+func Queue(q MachQueue) MachBuildOpt {
+	return func(mb *machBuilder) error {
+		mb.Mach.ctx.queue = q
+		return nil
+	}
+}
+
+// MaxDepth overrides any fork depth limit declared by the program (with the
+// max depth option) for a New()ly built machine; 0 means no limit.
+func MaxDepth(n int) MachBuildOpt {
+	return func(mb *machBuilder) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max depth %d, must be non-negative", n)
+		}
+		mb.Mach.maxDepth = uint(n)
+		return nil
+	}
+}
+
 // Input passes a collection of input values to a New()ly built machine. For
 // each Input(...) the loaded program must have defined an input region.
 // Furthermore the number of values must fit with the corresponding input
@@ -197,6 +230,12 @@ type DebugInfo interface {
 
 	// SpanAddrs returns a slice of all addresses that have span mark(s).
 	SpanAddrs() []uint32
+
+	// Source returns the source position that the code at the given address
+	// was assembled from, or the empty string if none is known. Positions are
+	// only recorded where they change, so the position of any address is that
+	// of the nearest one at or before it.
+	Source(addr uint32) string
 }
 
 // WithDebugInfo calls the given function with any defined debug info; if no
@@ -213,7 +252,11 @@ func WithDebugInfo(cb func(DebugInfo)) MachBuildOpt {
 func (m *Mach) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("Mach")
-	if m.err != nil {
+	if m.err == errYielded {
+		buf.WriteString(" YIELD")
+	} else if m.err == errPaused {
+		buf.WriteString(" PAUSED")
+	} else if m.err != nil {
 		if code, halted := m.halted(); halted {
 			// TODO: symbolicate
 			fmt.Fprintf(&buf, " HALT:%v", code)
@@ -286,6 +329,14 @@ func (m *Mach) CBP() uint32 { return m.cbp }
 // CSP returns the current control stack pointer.
 func (m *Mach) CSP() uint32 { return m.csp }
 
+// Depth returns how many generations of copies separate the machine from the
+// one originally built by New(); it is 0 for the original machine.
+func (m *Mach) Depth() int { return int(m.depth) }
+
+// DepthLimited returns true if the machine was stopped because it exceeded the
+// fork depth limit.
+func (m *Mach) DepthLimited() bool { return m.err == errDepthLimit }
+
 // Values returns any output values from the machine. Output values may be
 // statically declared via the output option. Additionally, once the machine
 // has halted with 0 status code, 0 or more pairs of output ranges may be left
@@ -335,8 +386,20 @@ func (m *Mach) Outputs() ([]Region, error) {
 	if len(outputs) == 0 || err != nil {
 		return nil, err
 	}
-	rgs := make([]Region, len(outputs))
-	for i, rg := range outputs {
+	return m.regions(outputs)
+}
+
+// Inputs returns a slice of the machine's statically defined input regions.
+func (m *Mach) Inputs() ([]Region, error) {
+	if len(m.ctx.inputs) == 0 {
+		return nil, nil
+	}
+	return m.regions(m.ctx.inputs)
+}
+
+func (m *Mach) regions(rs []region) ([]Region, error) {
+	rgs := make([]Region, len(rs))
+	for i, rg := range rs {
 		rgs[i] = Region{From: rg.from, To: rg.to}
 		if rg.name != 0 {
 			name, err := m.fetchString(rg.name)
@@ -351,7 +414,7 @@ func (m *Mach) Outputs() ([]Region, error) {
 
 func (m *Mach) outputs() ([]region, error) {
 	done := false
-	if m.err != nil {
+	if m.err != nil && !m.suspended() {
 		if arg, ok := m.halted(); !ok || arg != 0 {
 			return nil, m.err
 		}
@@ -416,6 +479,20 @@ func (m *Mach) MemCopy(addr uint32, bs []byte) int {
 	return m.fetchBytes(addr, bs)
 }
 
+// MemStore copies bytes from the given buffer into memory, allocating (or
+// copying shared) pages as needed. Any code overwritten is decoded afresh
+// when next run, rather than compiled or cached code being run instead.
+func (m *Mach) MemStore(addr uint32, bs []byte) {
+	if len(bs) > 0 {
+		m.storeBytes(addr, bs)
+		end := addr + uint32(len(bs))
+		if end > m.cbp {
+			m.opc.invalidate(m.cbp, addr, end)
+			m.ctx.blocks = nil
+		}
+	}
+}
+
 // Tracer is the interface taken by (*Mach).Trace to observe machine
 // execution: Begin() and End() are called when a machine starts and finishes
 // respectively; Before() and After() are around each machine operation;
@@ -518,6 +595,17 @@ const (
 	// its required parameter marks an address as a semantic span close.
 	optCodeSpanClose = 0x0b
 
+	// its optional parameter declares a limit on how many generations of
+	// machine copies may be made; a copy that would exceed the limit halts
+	// with a fork depth limit error instead of running. Default: 0.
+	optCodeMaxDepth = 0x0c
+
+	// its required parameter is the count of how many addr/source pairs
+	// follow this option, encoded like addr labels. Each source is a
+	// position, like "file.svm:3:5", from which the code at (and after) its
+	// address was assembled.
+	optCodeSourceMap = 0x0d
+
 	// reserved for future use, where its parameter will be the required
 	// machine/program version; passing a version value is currently
 	// unsupported.
@@ -534,6 +622,12 @@ const (
 type debugInfo struct {
 	labels map[uint32][]string
 	annos  map[uint32]anno
+	srcs   []addrSource // sorted by addr
+}
+
+type addrSource struct {
+	addr uint32
+	src  string
 }
 
 func (dbg debugInfo) Labels(addr uint32) []string {
@@ -547,8 +641,18 @@ func (dbg debugInfo) Span(addr uint32) (open, close bool) {
 	return
 }
 
+func (dbg debugInfo) Source(addr uint32) string {
+	i := sort.Search(len(dbg.srcs), func(i int) bool {
+		return dbg.srcs[i].addr > addr
+	})
+	if i == 0 {
+		return ""
+	}
+	return dbg.srcs[i-1].src
+}
+
 func (dbg debugInfo) empty() bool {
-	return len(dbg.labels) == 0 && len(dbg.annos) == 0
+	return len(dbg.labels) == 0 && len(dbg.annos) == 0 && len(dbg.srcs) == 0
 }
 
 func (dbg debugInfo) LabeledAddrs() []uint32 {
@@ -609,6 +713,9 @@ func (mb *machBuilder) build(buf []byte, mbos ...MachBuildOpt) error {
 	if err := mb.handleOpts(); err != nil {
 		return err
 	}
+	mb.Mach.ctx.dbg = mb.dbg
+	mb.Mach.ctx.inputs = mb.inputs
+	mb.Mach.ctx.brks = &breakpoints{}
 
 	prog := mb.buf[mb.n:]
 	mb.Mach.opc = makeOpCache(len(prog))
@@ -675,6 +782,24 @@ func (mb *machBuilder) readAddrLabels(n int) error {
 	return nil
 }
 
+func (mb *machBuilder) readSourceMap(n int) error {
+	for i := 0; i < n; i++ {
+		addr, err := mb.readUvarint()
+		if err != nil {
+			return fmt.Errorf("bad address: %v", err)
+		}
+		src, err := mb.readString()
+		if err != nil {
+			return fmt.Errorf("bad source: %v", err)
+		}
+		mb.dbg.srcs = append(mb.dbg.srcs, addrSource{addr, src})
+	}
+	sort.SliceStable(mb.dbg.srcs, func(i, j int) bool {
+		return mb.dbg.srcs[i].addr < mb.dbg.srcs[j].addr
+	})
+	return nil
+}
+
 func (mb *machBuilder) readString() (string, error) {
 	v, err := mb.readUvarint()
 	if err != nil {
@@ -744,6 +869,12 @@ func (mb *machBuilder) handleOpt(code uint8, arg uint32) (bool, error) {
 	case 0x80 | optCodeMaxCopies:
 		mb.maxCopies = int(arg)
 
+	case optCodeMaxDepth:
+		mb.Mach.maxDepth = 0
+
+	case 0x80 | optCodeMaxDepth:
+		mb.Mach.maxDepth = uint(arg)
+
 	case 0x80 | optCodeEntry:
 		mb.Mach.ip = arg
 
@@ -786,6 +917,11 @@ func (mb *machBuilder) handleOpt(code uint8, arg uint32) (bool, error) {
 			return false, err
 		}
 
+	case 0x80 | optCodeSourceMap:
+		if err := mb.readSourceMap(int(arg)); err != nil {
+			return false, err
+		}
+
 	case 0x80 | optCodeSpanOpen:
 		mb.dbg.annotate(arg, annoSpanOpen)
 
@@ -831,7 +967,7 @@ func OptionNeededSize(op Op) int {
 	if optionAcceptsRef(op) {
 		return MaxVarCodeLen
 	}
-	if op.Code == optCodeAddrLabels {
+	if op.Code == optCodeAddrLabels || op.Code == optCodeSourceMap {
 		return MaxVarCodeLen
 	}
 	return op.NeededSize()
@@ -850,6 +986,8 @@ func NameOption(code uint8) string {
 		return "maxOps"
 	case optCodeMaxCopies:
 		return "maxCopies"
+	case optCodeMaxDepth:
+		return "maxDepth"
 	case optCodeEntry:
 		return "entry"
 	case optCodeInput:
@@ -860,6 +998,8 @@ func NameOption(code uint8) string {
 		return "name"
 	case optCodeAddrLabels:
 		return "addrLabels"
+	case optCodeSourceMap:
+		return "sourceMap"
 	case optCodeSpanOpen:
 		return "spanOpen"
 	case optCodeSpanClose:
@@ -884,6 +1024,8 @@ func ResolveOption(name string, arg uint32, have bool) (op Op) {
 		op.Code = optCodeMaxOps
 	case "maxCopies":
 		op.Code = optCodeMaxCopies
+	case "maxDepth":
+		op.Code = optCodeMaxDepth
 	case "entry":
 		op.Code = optCodeEntry
 	case "input":
@@ -894,6 +1036,8 @@ func ResolveOption(name string, arg uint32, have bool) (op Op) {
 		op.Code = optCodeName
 	case "addrLabels":
 		op.Code = optCodeAddrLabels
+	case "sourceMap":
+		op.Code = optCodeSourceMap
 	case "spanOpen":
 		op.Code = optCodeSpanOpen
 	case "spanClose":
@@ -940,6 +1084,19 @@ func (o Op) AcceptsRef() bool {
 	return false
 }
 
+// RelativeRef returns true only if the op's ref argument is an offset,
+// relative to the end of the op's encoding, rather than an absolute address
+// or value.
+func (o Op) RelativeRef() bool {
+	return ops[o.Code].imm.kind() == opImmOffset
+}
+
+// EndsBlock returns true if the op may transfer control, or stop the machine,
+// so that it ends a basic block of the program.
+func (o Op) EndsBlock() bool {
+	return opCode(o.Code).endsBlock()
+}
+
 // ResolveRefArg fills in the argument of a control op relative to another op's
 // encoded location, and the current op's.
 func (o Op) ResolveRefArg(myIP, targIP uint32) Op {
@@ -986,6 +1143,16 @@ func (o Op) String() string {
 	return fmt.Sprintf("INVALID(%#x %x %q)", o.Arg, o.Code, def.name)
 }
 
+// ReadOp decodes the operation at the given address, returning it along with
+// the address of the following operation.
+func (m *Mach) ReadOp(addr uint32) (Op, uint32, error) {
+	end, code, arg, err := m.read(addr)
+	if err != nil {
+		return Op{}, 0, err
+	}
+	return Op{code.code(), arg, code.hasImm()}, end, nil
+}
+
 // Tracer returns the current Tracer that the machine is running under, if any.
 func (m *Mach) Tracer() Tracer {
 	mt1, ok1 := m.ctx.MachHandler.(*machTracer)
@@ -1038,11 +1205,15 @@ func (m *Mach) Trace(t Tracer) error {
 	orig := m
 
 	fixTracer(t, m)
+	m.resume()
 
 repeat:
 	// live
 	t.Begin(m)
 	for m.err == nil {
+		if m.brkHit() {
+			break
+		}
 		var readOp Op
 		if _, code, arg, err := m.read(m.ip); err != nil {
 			m.err = err
@@ -1052,11 +1223,20 @@ repeat:
 		}
 		t.Before(m, m.ip, readOp)
 		m.step()
-		if m.err != nil {
+		if m.err != nil && !m.suspended() {
 			break
 		}
 		t.After(m, m.ip, readOp)
 	}
+
+	// suspend
+	if m.suspended() {
+		if m != orig {
+			*orig = *m
+		}
+		return m.Err()
+	}
+
 	t.End(m)
 
 	// win or die
@@ -1064,7 +1244,7 @@ repeat:
 	t.Handle(m, err)
 	if err == nil {
 		if n := m.ctx.Dequeue(); n != nil {
-			m.free()
+			m.freeUnless(orig)
 			m = n
 			// die
 			goto repeat
@@ -1078,7 +1258,10 @@ repeat:
 	return err
 }
 
-// Run runs the machine until termination, returning any error.
+// Run runs the machine until termination, returning any error. If the machine
+// executes a yield operation, Run returns nil early, and Yielded returns true;
+// if it hits a breakpoint, Run returns a Paused error. In either case calling
+// Run again resumes the machine (and any queued copies).
 func (m *Mach) Run() error {
 	n, err := m.run()
 	if n != m {
@@ -1090,12 +1273,39 @@ func (m *Mach) Run() error {
 // Step single steps the machine; it decodes and executes one
 // operation.
 func (m *Mach) Step() error {
+	m.resume()
+	m.skipBrk = false
 	if m.err == nil {
 		m.step()
 	}
 	return m.Err()
 }
 
+// Yielded returns the values passed by the last yield operation, and true if
+// the machine is suspended on it. Yield passes a single value from its
+// immediate or the parameter stack, while yieldr passes the words in a
+// [from, to) memory range popped from the parameter stack.
+func (m *Mach) Yielded() ([]uint32, bool) {
+	if m.err != errYielded {
+		return nil, false
+	}
+	return m.yielded, true
+}
+
+// Push pushes values onto the parameter stack of a machine that is not yet
+// terminated, e.g. to pass replies to a yield operation before resuming it.
+func (m *Mach) Push(vals ...uint32) error {
+	if m.err != nil && m.err != errYielded {
+		return MachError{m.ip, m.err}
+	}
+	for _, val := range vals {
+		if err := m.push(val); err != nil {
+			return MachError{m.ip, err}
+		}
+	}
+	return nil
+}
+
 // HaltCode returns the halt code and true if the machine has halted
 // normally; otherwise false is returned.
 func (m *Mach) HaltCode() (uint32, bool) { return m.halted() }
@@ -1115,6 +1325,12 @@ func init() {
 // execution context.
 func (m *Mach) Err() error {
 	err := m.err
+	switch err {
+	case errYielded:
+		return nil
+	case errPaused:
+		return Paused{m.ip}
+	}
 	if code, halted := m.halted(); halted {
 		if code == 0 {
 			return nil
@@ -0,0 +1,91 @@
+package stackvm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Paused is the error returned by Run, Trace, Step, or Continue when a
+// machine stops at a breakpoint, either a brk operation or one set by the
+// host. The machine, and any queued copies, are left intact; calling Continue
+// carries on from there.
+type Paused struct {
+	Addr uint32 // address of the next operation to execute
+}
+
+func (p Paused) Error() string { return fmt.Sprintf("paused @0x%04x", p.Addr) }
+
+type breakpoints struct {
+	addrs map[uint32]bool
+}
+
+// SetBreakpoint sets a host breakpoint at the given address: the machine,
+// and any copies of it, will pause before executing the operation there.
+func (m *Mach) SetBreakpoint(addr uint32) {
+	bp := m.ctx.brks
+	if bp.addrs == nil {
+		bp.addrs = make(map[uint32]bool)
+	}
+	bp.addrs[addr] = true
+}
+
+// ClearBreakpoint removes any host breakpoint at the given address.
+func (m *Mach) ClearBreakpoint(addr uint32) {
+	delete(m.ctx.brks.addrs, addr)
+}
+
+// SetLabelBreakpoint sets a host breakpoint at every address that the
+// program's debug info labels with the given name.
+func (m *Mach) SetLabelBreakpoint(label string) error {
+	addrs := m.labelAddrs(label)
+	if len(addrs) == 0 {
+		return fmt.Errorf("no such label %q", label)
+	}
+	for _, addr := range addrs {
+		m.SetBreakpoint(addr)
+	}
+	return nil
+}
+
+// ClearLabelBreakpoint removes any host breakpoint at addresses labeled with
+// the given name.
+func (m *Mach) ClearLabelBreakpoint(label string) {
+	for _, addr := range m.labelAddrs(label) {
+		m.ClearBreakpoint(addr)
+	}
+}
+
+// Breakpoints returns a sorted slice of all host breakpoint addresses.
+func (m *Mach) Breakpoints() []uint32 {
+	addrs := make([]uint32, 0, len(m.ctx.brks.addrs))
+	for addr := range m.ctx.brks.addrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func (m *Mach) labelAddrs(label string) (addrs []uint32) {
+	for addr, labels := range m.ctx.dbg.labels {
+		for _, l := range labels {
+			if l == label {
+				addrs = append(addrs, addr)
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// Paused returns true if the machine is stopped at a breakpoint.
+func (m *Mach) Paused() bool { return m.err == errPaused }
+
+// Continue resumes a paused (or yielded) machine, along with its pending run
+// queue; if the machine was being traced, tracing continues with the same
+// Tracer.
+func (m *Mach) Continue() error {
+	if t := m.Tracer(); t != nil {
+		return m.Trace(t)
+	}
+	return m.Run()
+}
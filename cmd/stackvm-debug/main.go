@@ -0,0 +1,481 @@
+// Command stackvm-debug is an interactive debugger for stackvm programs.
+//
+// Usage:
+//
+//	stackvm-debug [-record] [-input VALS] PROG
+//	stackvm-debug -dap [-dap-listen ADDR] [-record] [-input VALS] [PROG]
+//	stackvm-debug -gdb ADDR [-record] [-input VALS] PROG
+//
+// PROG is either an assembled program, assembly source text in a ".svm" file,
+// or a JSON array of assembler tokens.
+// Each -input flag passes comma separated values to an input region; it may
+// be prefixed with "name=" to target a named input. Type "help" at the prompt
+// for a list of commands. With -record, every operation is recorded so that
+// machines may be stepped back.
+//
+// With -dap, a Debug Adapter Protocol server is run over stdio, or on a local
+// TCP address given by -dap-listen. Launch requests may pass "program" and
+// "inputs" arguments, which default to those given on the command line.
+//
+// With -gdb, a GDB remote serial protocol stub listens on a local TCP address;
+// each client connection debugs a fresh run of PROG.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/dap"
+	"github.com/jcorbin/stackvm/x/debug"
+	"github.com/jcorbin/stackvm/x/dumper"
+	"github.com/jcorbin/stackvm/x/gdbrsp"
+)
+
+type inputsFlag []stackvm.MachBuildOpt
+
+func (inf *inputsFlag) String() string { return "" }
+
+func (inf *inputsFlag) Set(s string) error {
+	var name string
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		name, s = s[:i], s[i+1:]
+	}
+	var vals []uint32
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(f, 0, 32)
+		if err != nil {
+			return err
+		}
+		vals = append(vals, uint32(n))
+	}
+	if name != "" {
+		*inf = append(*inf, stackvm.NamedInput(name, vals))
+	} else {
+		*inf = append(*inf, stackvm.Input(vals))
+	}
+	return nil
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+func launcher(progName string, inputs inputsFlag, extra []stackvm.MachBuildOpt) dap.LaunchFunc {
+	return func(raw json.RawMessage) (*debug.Session, error) {
+		var args struct {
+			Program string   `json:"program"`
+			Inputs  []string `json:"inputs"`
+		}
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+		}
+		name, opts := progName, inputs
+		if args.Program != "" {
+			name = args.Program
+		}
+		if args.Inputs != nil {
+			opts = nil
+			for _, s := range args.Inputs {
+				if err := opts.Set(s); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if name == "" {
+			return nil, errors.New("no program given")
+		}
+		prog, err := xstackvm.LoadProgram(name)
+		if err != nil {
+			return nil, err
+		}
+		return debug.New(prog, append(opts[:len(opts):len(opts)], extra...)...)
+	}
+}
+
+func main() {
+	var (
+		inputs    inputsFlag
+		serveDAP  bool
+		dapListen string
+		gdbListen string
+		record    bool
+	)
+	flag.Var(&inputs, "input", "comma separated input values, optionally prefixed by \"name=\"")
+	flag.BoolVar(&serveDAP, "dap", false, "serve the Debug Adapter Protocol instead of running a REPL")
+	flag.StringVar(&dapListen, "dap-listen", "", "serve DAP on a local TCP address, rather than stdio")
+	flag.StringVar(&gdbListen, "gdb", "", "serve the GDB remote serial protocol on a local TCP address")
+	flag.BoolVar(&record, "record", false, "record history, so that machines may be stepped back")
+	flag.Parse()
+	var opts []stackvm.MachBuildOpt
+	if record {
+		opts = append(opts, stackvm.Record())
+	}
+
+	if serveDAP {
+		if flag.NArg() > 1 {
+			log.Fatalf("usage: stackvm-debug -dap [-dap-listen ADDR] [-input VALS] [PROG]")
+		}
+		srv := dap.Server{Launch: launcher(flag.Arg(0), inputs, opts)}
+		var err error
+		if dapListen != "" {
+			err = srv.ListenAndServe(dapListen)
+		} else {
+			err = srv.Serve(stdio{os.Stdin, os.Stdout})
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatalf("usage: stackvm-debug [-input VALS] PROG")
+	}
+
+	prog, err := xstackvm.LoadProgram(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if gdbListen != "" {
+		srv := gdbrsp.Server{Launch: func() (*debug.Session, error) {
+			return debug.New(prog, append(inputs, opts...)...)
+		}}
+		log.Fatal(srv.ListenAndServe(gdbListen))
+	}
+	sess, err := debug.New(prog, append(inputs, opts...)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := repl{sess: sess, out: os.Stdout}
+	if err := r.run(os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var errQuit = errors.New("quit")
+
+type repl struct {
+	sess    *debug.Session
+	out     io.Writer
+	last    string
+	stopped *debug.Thread // last thread reported, which back may revive
+}
+
+type command struct {
+	name, args, help string
+	run              func(r *repl, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"step", "[N]", "execute N (default 1) operations", (*repl).step},
+		{"next", "", "step, stepping over call operations", (*repl).next},
+		{"back", "[N]", "step back N (default 1) operations; requires -record", (*repl).back},
+		{"continue", "", "run until a breakpoint, watchpoint, brk, or yield", (*repl).cont},
+		{"break", "[LABEL|ADDR|PRED]", "add a breakpoint, or list them; PRED is like before:fork or queue", (*repl).brk},
+		{"watch", "ADDR|LABEL", "stop when the memory word at ADDR changes", (*repl).watch},
+		{"delete", "ID", "delete a breakpoint or watchpoint", (*repl).del},
+		{"print", "ps|cs|regs|mem|ADDR|LABEL", "print stacks, registers, memory, or a memory word", (*repl).print},
+		{"machines", "", "list machines", (*repl).machines},
+		{"switch", "ID", "switch to a queued machine", (*repl).switchTo},
+		{"tree", "", "show the fork tree", (*repl).tree},
+		{"help", "", "show this help", (*repl).help},
+		{"quit", "", "exit the debugger", func(*repl, []string) error { return errQuit }},
+	}
+}
+
+func (r *repl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, format+"\n", args...)
+}
+
+func (r *repl) run(in io.Reader) error {
+	sc := bufio.NewScanner(in)
+	r.where()
+	for {
+		fmt.Fprint(r.out, "(svm) ")
+		if !sc.Scan() {
+			fmt.Fprintln(r.out)
+			return sc.Err()
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			line = r.last
+		}
+		r.last = line
+		if err := r.exec(line); err == errQuit {
+			return nil
+		} else if err != nil {
+			r.printf("error: %v", err)
+		}
+	}
+}
+
+func (r *repl) exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	var match *command
+	for i := range commands {
+		if cmd := &commands[i]; strings.HasPrefix(cmd.name, fields[0]) {
+			if cmd.name == fields[0] || match == nil {
+				match = cmd
+			}
+		}
+	}
+	if match == nil {
+		return fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+	return match.run(r, fields[1:])
+}
+
+func (r *repl) help(args []string) error {
+	for _, cmd := range commands {
+		r.printf("  %-9s %-26s %s", cmd.name, cmd.args, cmd.help)
+	}
+	return nil
+}
+
+func (r *repl) step(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		st := r.sess.Step()
+		if st.Reason != debug.StopStep || i == n-1 {
+			r.report(st)
+			break
+		}
+	}
+	return nil
+}
+
+func (r *repl) next(args []string) error {
+	r.report(r.sess.Next())
+	return nil
+}
+
+func (r *repl) back(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	id := 0
+	if t := r.stopped; t != nil && t.Done {
+		id = t.ID
+	}
+	for i := 0; i < n; i++ {
+		if _, err := r.sess.StepBack(id); err != nil {
+			return err
+		}
+		id = 0
+	}
+	r.stopped = r.sess.Current()
+	r.where()
+	return nil
+}
+
+func (r *repl) cont(args []string) error {
+	r.report(r.sess.Continue())
+	return nil
+}
+
+func (r *repl) brk(args []string) error {
+	if len(args) == 0 {
+		for _, b := range r.sess.Breaks() {
+			r.printf("#%d break %s hits=%d", b.ID, b.Spec, b.Hits)
+		}
+		for _, w := range r.sess.Watches() {
+			r.printf("#%d watch @0x%04x", w.ID, w.Addr)
+		}
+		return nil
+	}
+	b, err := r.sess.Break(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	r.printf("#%d break %s", b.ID, b.Spec)
+	return nil
+}
+
+func (r *repl) watch(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: watch ADDR|LABEL")
+	}
+	addr, err := r.sess.ResolveAddr(args[0])
+	if err != nil {
+		return err
+	}
+	w := r.sess.Watch(addr)
+	r.printf("#%d watch @0x%04x", w.ID, w.Addr)
+	return nil
+}
+
+func (r *repl) del(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	return r.sess.Delete(id)
+}
+
+func (r *repl) print(args []string) error {
+	t := r.sess.Current()
+	if t == nil {
+		return errors.New("no current machine")
+	}
+	m := t.Mach
+	if len(args) != 1 {
+		return errors.New("usage: print ps|cs|regs|mem|ADDR|LABEL")
+	}
+	switch args[0] {
+	case "ps", "cs":
+		ps, cs, err := m.Stacks()
+		if err != nil {
+			return err
+		}
+		if args[0] == "ps" {
+			r.printf("ps=%v", ps)
+		} else {
+			r.printf("cs=%v", cs)
+		}
+	case "regs":
+		r.printf("ip=0x%04x pbp=0x%04x psp=0x%04x cbp=0x%04x csp=0x%04x",
+			m.IP(), m.PBP(), m.PSP(), m.CBP(), m.CSP())
+	case "mem":
+		return dumper.DumpDebug(m, r.sess.DebugInfo(), r.printf)
+	default:
+		addr, err := r.sess.ResolveAddr(args[0])
+		if err != nil {
+			return err
+		}
+		val, err := m.Fetch(addr)
+		if err != nil {
+			return err
+		}
+		r.printf("@0x%04x = %d (0x%x)", addr, val, val)
+	}
+	return nil
+}
+
+func (r *repl) machines(args []string) error {
+	cur := r.sess.Current()
+	for _, t := range r.sess.Threads() {
+		mark := " "
+		if t == cur {
+			mark = "*"
+		}
+		r.printf("%s %v", mark, t)
+	}
+	return nil
+}
+
+func (r *repl) switchTo(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: switch ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	if err := r.sess.Switch(id); err != nil {
+		return err
+	}
+	r.where()
+	return nil
+}
+
+func (r *repl) tree(args []string) error {
+	children := make(map[int][]*debug.Thread)
+	for _, t := range r.sess.Threads() {
+		children[t.Parent] = append(children[t.Parent], t)
+	}
+	cur := r.sess.Current()
+	var walk func(parent int, indent string)
+	walk = func(parent int, indent string) {
+		kids := children[parent]
+		sort.Slice(kids, func(i, j int) bool { return kids[i].ID < kids[j].ID })
+		for _, t := range kids {
+			mark := ""
+			if t == cur {
+				mark = " *"
+			}
+			r.printf("%s%v%s", indent, t, mark)
+			walk(t.ID, indent+"  ")
+		}
+	}
+	walk(0, "")
+	return nil
+}
+
+func (r *repl) report(st debug.Stop) {
+	if st.Thread != nil {
+		r.stopped = st.Thread
+	}
+	switch st.Reason {
+	case debug.StopBreak:
+		r.printf("breakpoint #%d %s", st.Break.ID, st.Break.Spec)
+	case debug.StopBrk:
+		r.printf("brk")
+	case debug.StopYield:
+		vals, _ := st.Thread.Mach.Yielded()
+		r.printf("yield %v", vals)
+	case debug.StopWatch:
+		r.printf("watch #%d @0x%04x: %d -> %d", st.Watch.ID, st.Watch.Addr, st.Old, st.New)
+	case debug.StopExit:
+		r.printf("exit %v", st.Thread)
+	case debug.StopDone:
+		r.printf("no machines left to run")
+		return
+	}
+	r.where()
+}
+
+func (r *repl) where() {
+	t := r.sess.Current()
+	if t == nil {
+		return
+	}
+	ip := t.Mach.IP()
+	op, _, err := t.Mach.ReadOp(ip)
+	if err != nil {
+		r.printf("thread %d @0x%04x %v", t.ID, ip, err)
+		return
+	}
+	if labels := r.sess.Labels(ip); len(labels) > 0 {
+		r.printf("thread %d @0x%04x %v labels=%q", t.ID, ip, op, labels)
+	} else {
+		r.printf("thread %d @0x%04x %v", t.ID, ip, op)
+	}
+}
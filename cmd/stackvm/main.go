@@ -0,0 +1,565 @@
+// Command stackvm assembles, runs, traces, and dumps stackvm programs.
+//
+// Usage:
+//
+//	stackvm asm [-o OUT] [-list] [-srcmap] [-O] [-stack] [-c] SRC
+//	stackvm link [-o OUT] OBJ...
+//	stackvm aot [-pkg NAME] [-o OUT] PROG
+//	stackvm cfg [-json] [-o OUT] PROG
+//	stackvm run [-input VALS] [-inputs FILE] [-json] PROG
+//	stackvm trace [-input VALS] [-inputs FILE] [-o OUT] PROG
+//	stackvm dump [-input VALS] [-inputs FILE] [-after] PROG
+//
+// The asm command assembles SRC, which may be assembly source text or a JSON
+// array of assembler tokens, into a binary program written to OUT (default
+// stdout). With -list, a listing of the program is written to stderr; with
+// -srcmap, the source position of its code is embedded as debug info; with
+// -O, it is optimized, and a count of its ops before and after is written to
+// stderr; with -stack, its stack use is analyzed, and the depths reached, the
+// .stackSize needed, and any problems, like underflows, are written to
+// stderr. With -c, a relocatable object is written instead, which the link
+// command can combine with others into a program.
+//
+// The other commands load PROG, which may be a binary program, assembly
+// source text in a ".svm" file, a JSON array of assembler tokens, or a fully
+// linked object. Each -input flag passes comma separated values to an input
+// region; it may be prefixed with "name=" to target a named input. The
+// -inputs flag reads inputs from a JSON file: either an array of value
+// arrays, or an object mapping input names to value arrays.
+//
+// The run command prints each result's halt code and named output values,
+// one per line (or as JSON objects with -json). The trace command writes a
+// log of every operation, which tools/tracelog can render, and prints each
+// result to stderr. The dump command prints the machine's registers, regions,
+// and memory before running (or of each result with -after).
+//
+// The aot command compiles PROG ahead of time into the source of a Go package
+// named NAME (default "prog"); see package x/aot. The cfg command writes the
+// control flow graph of PROG in the Graphviz DOT language, or as JSON with
+// -json; see package x/cfg.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/aot"
+	"github.com/jcorbin/stackvm/x/cfg"
+	"github.com/jcorbin/stackvm/x/dumper"
+	"github.com/jcorbin/stackvm/x/link"
+	"github.com/jcorbin/stackvm/x/tracer"
+)
+
+type command struct {
+	name, args, help string
+	run              func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"asm", "[-o OUT] [-list] [-srcmap] [-O] [-stack] [-c] SRC", "assemble source text or JSON tokens into a program", asm},
+	{"link", "[-o OUT] OBJ...", "link objects into a program", linkObjects},
+	{"aot", "[-pkg NAME] [-o OUT] PROG", "compile a program into a Go package", compile},
+	{"cfg", "[-json] [-o OUT] PROG", "write a program's control flow graph", graph},
+	{"run", "[-input VALS] [-inputs FILE] [-json] PROG", "run a program, printing every result", run},
+	{"trace", "[-input VALS] [-inputs FILE] [-o OUT] PROG", "run a program, logging every operation", trace},
+	{"dump", "[-input VALS] [-inputs FILE] [-after] PROG", "dump a program's machine memory", dump},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: stackvm COMMAND [FLAGS] ARGS\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-6s %s\n         %s\n", cmd.name, cmd.args, cmd.help)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("stackvm: ")
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: stackvm %s %s\n", cmd.name, cmd.args)
+			fs.PrintDefaults()
+		}
+		if err := cmd.run(fs, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	usage()
+}
+
+type inputsFlag []stackvm.MachBuildOpt
+
+func (inf *inputsFlag) String() string { return "" }
+
+func (inf *inputsFlag) Set(s string) error {
+	var name string
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		name, s = s[:i], s[i+1:]
+	}
+	var vals []uint32
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(f, 0, 32)
+		if err != nil {
+			return err
+		}
+		vals = append(vals, uint32(n))
+	}
+	inf.add(name, vals)
+	return nil
+}
+
+func (inf *inputsFlag) add(name string, vals []uint32) {
+	if name != "" {
+		*inf = append(*inf, stackvm.NamedInput(name, vals))
+	} else {
+		*inf = append(*inf, stackvm.Input(vals))
+	}
+}
+
+// load reads inputs from a JSON file, either an array of value arrays, or an
+// object mapping input names to value arrays.
+func (inf *inputsFlag) load(name string) error {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '{' {
+		var named map[string][]uint32
+		if err := json.Unmarshal(buf, &named); err != nil {
+			return fmt.Errorf("invalid inputs file %q: %v", name, err)
+		}
+		names := make([]string, 0, len(named))
+		for name := range named {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			inf.add(name, named[name])
+		}
+		return nil
+	}
+	var vals [][]uint32
+	if err := json.Unmarshal(buf, &vals); err != nil {
+		return fmt.Errorf("invalid inputs file %q: %v", name, err)
+	}
+	for _, vs := range vals {
+		inf.add("", vs)
+	}
+	return nil
+}
+
+// machFlags are the flags shared by commands that build a machine.
+type machFlags struct {
+	inputs     inputsFlag
+	inputsFile string
+}
+
+func (mf *machFlags) register(fs *flag.FlagSet) {
+	fs.Var(&mf.inputs, "input", "comma separated input values, optionally prefixed by \"name=\"")
+	fs.StringVar(&mf.inputsFile, "inputs", "", "read inputs from a JSON file")
+}
+
+// load parses flags, and loads the program named by the only argument.
+func (mf *machFlags) load(fs *flag.FlagSet, args []string) ([]byte, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if mf.inputsFile != "" {
+		if err := mf.inputs.load(mf.inputsFile); err != nil {
+			return nil, err
+		}
+	}
+	return xstackvm.LoadProgram(fs.Arg(0))
+}
+
+func (mf *machFlags) build(prog []byte, opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {
+	return stackvm.New(prog, append(mf.inputs[:len(mf.inputs):len(mf.inputs)], opts...)...)
+}
+
+// create creates the named file, or returns stdout for "" or "-", which isn't
+// closed by closing the returned writer.
+func create(name string) (io.WriteCloser, error) {
+	if name == "" || name == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func asm(fs *flag.FlagSet, args []string) error {
+	out := fs.String("o", "", "write the program to a file, rather than stdout")
+	list := fs.Bool("list", false, "write a listing of the program to stderr")
+	srcMap := fs.Bool("srcmap", false, "embed the source position of code as debug info")
+	optimize := fs.Bool("O", false, "optimize the program, writing a report of its op counts to stderr")
+	asObj := fs.Bool("c", false, "write a relocatable object, to be linked, rather than a program")
+	stack := fs.Bool("stack", false, "analyze the program's stack use, writing a report to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	name := fs.Arg(0)
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var (
+		toks []interface{}
+		src  *xstackvm.Source
+		opts []xstackvm.Option
+	)
+	if *list {
+		opts = append(opts, xstackvm.WithListing(func(lst xstackvm.Listing) {
+			fmt.Fprint(os.Stderr, lst)
+		}))
+	}
+	if *optimize {
+		opts = append(opts, xstackvm.Optimize(func(rep xstackvm.OptimizeReport) {
+			fmt.Fprint(os.Stderr, rep)
+		}))
+	}
+	if *stack {
+		opts = append(opts, xstackvm.CheckStack(func(sa *cfg.StackAnalysis) {
+			fmt.Fprint(os.Stderr, sa)
+		}))
+	}
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '[' {
+		toks, err = xstackvm.ParseJSON(bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		if *srcMap {
+			opts = append(opts, xstackvm.SourceMap(func(at []int) string {
+				return fmt.Sprintf("%s:%v", name, at)
+			}))
+		}
+	} else {
+		src, err = xstackvm.ParseSource(name, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		if *srcMap {
+			opts = append(opts, src.SourceMap())
+		}
+	}
+
+	var res io.WriterTo
+	if *asObj {
+		var obj *xstackvm.Object
+		if src != nil {
+			obj, err = src.AssembleObject(opts...)
+		} else {
+			obj, err = xstackvm.NewAssembler(opts...).AssembleObject(toks...)
+		}
+		if err != nil {
+			return err
+		}
+		obj.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		res = obj
+	} else {
+		var prog []byte
+		if src != nil {
+			prog, err = src.Assemble(opts...)
+		} else {
+			prog, err = xstackvm.NewAssembler(opts...).Assemble(toks...)
+		}
+		if err != nil {
+			return err
+		}
+		res = bytes.NewReader(prog)
+	}
+
+	w, err := create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := res.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func linkObjects(fs *flag.FlagSet, args []string) error {
+	out := fs.String("o", "", "write the program to a file, rather than stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	objs := make([]*xstackvm.Object, fs.NArg())
+	for i, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		objs[i], err = xstackvm.ReadObject(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if objs[i].Name == "" {
+			objs[i].Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		}
+	}
+	prog, err := link.Program(objs)
+	if err != nil {
+		return err
+	}
+
+	w, err := create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(prog); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func compile(fs *flag.FlagSet, args []string) error {
+	pkg := fs.String("pkg", "prog", "name of the Go package to write")
+	out := fs.String("o", "", "write the Go source to a file, rather than stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	prog, err := xstackvm.LoadProgram(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	w, err := create(*out)
+	if err != nil {
+		return err
+	}
+	if err := aot.Generate(w, *pkg, prog); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func graph(fs *flag.FlagSet, args []string) error {
+	asJSON := fs.Bool("json", false, "write the graph as JSON, rather than DOT")
+	out := fs.String("o", "", "write the graph to a file, rather than stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	prog, err := xstackvm.LoadProgram(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	g, err := cfg.Build(prog)
+	if err != nil {
+		return err
+	}
+
+	w, err := create(*out)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = g.WriteJSON(w)
+	} else {
+		err = g.WriteDOT(w)
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+type result struct {
+	Halt   *uint32             `json:"halt,omitempty"`
+	Err    string              `json:"err,omitempty"`
+	Values map[string][]uint32 `json:"values,omitempty"`
+}
+
+func makeResult(m *stackvm.Mach) (res result) {
+	if code, halted := m.HaltCode(); halted {
+		res.Halt = &code
+	}
+	if err := m.Err(); err != nil {
+		res.Err = err.Error()
+	}
+	if vals, err := m.NamedValues(); err == nil {
+		res.Values = vals
+	}
+	return res
+}
+
+func (res result) String() string {
+	var parts []string
+	if res.Halt != nil {
+		parts = append(parts, fmt.Sprintf("halt=%d", *res.Halt))
+	}
+	if res.Err != "" && res.Halt == nil {
+		parts = append(parts, fmt.Sprintf("err=%q", res.Err))
+	}
+	names := make([]string, 0, len(res.Values))
+	for name := range res.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, res.Values[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// resultHandler returns a Handler that writes each result to w, as a line of
+// text, or a JSON object.
+func resultHandler(w io.Writer, asJSON bool) stackvm.MachBuildOpt {
+	enc := json.NewEncoder(w)
+	return stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		res := makeResult(m)
+		if asJSON {
+			return enc.Encode(res)
+		}
+		_, err := fmt.Fprintln(w, res)
+		return err
+	}))
+}
+
+func run(fs *flag.FlagSet, args []string) error {
+	var mf machFlags
+	mf.register(fs)
+	asJSON := fs.Bool("json", false, "print results as JSON objects, one per line")
+
+	prog, err := mf.load(fs, args)
+	if err != nil {
+		return err
+	}
+	m, err := mf.build(prog, resultHandler(os.Stdout, *asJSON))
+	if err != nil {
+		return err
+	}
+	return m.Run()
+}
+
+func trace(fs *flag.FlagSet, args []string) error {
+	var mf machFlags
+	mf.register(fs)
+	out := fs.String("o", "", "write the trace to a file, rather than stdout")
+
+	prog, err := mf.load(fs, args)
+	if err != nil {
+		return err
+	}
+	var dbg stackvm.DebugInfo
+	m, err := mf.build(prog,
+		stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }),
+		resultHandler(os.Stderr, false))
+	if err != nil {
+		return err
+	}
+	w, err := create(*out)
+	if err != nil {
+		return err
+	}
+	logf := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	err = m.Trace(tracer.Multi(
+		tracer.NewIDTracer(),
+		tracer.NewCountTracer(),
+		tracer.NewLogTracer(logf, dbg),
+	))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func dump(fs *flag.FlagSet, args []string) error {
+	var mf machFlags
+	mf.register(fs)
+	after := fs.Bool("after", false, "run the program, dumping each result instead")
+
+	logf := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}
+	var dbg stackvm.DebugInfo
+	dumpMach := func(m *stackvm.Mach) error {
+		logf("ip=0x%04x pbp=0x%04x psp=0x%04x csp=0x%04x cbp=0x%04x",
+			m.IP(), m.PBP(), m.PSP(), m.CSP(), m.CBP())
+		if inputs, err := m.Inputs(); err == nil {
+			for _, rg := range inputs {
+				logf("input %q [0x%04x, 0x%04x)", rg.Name, rg.From, rg.To)
+			}
+		}
+		if outputs, err := m.Outputs(); err == nil {
+			for _, rg := range outputs {
+				logf("output %q [0x%04x, 0x%04x)", rg.Name, rg.From, rg.To)
+			}
+		}
+		if *after {
+			logf("%v", makeResult(m))
+		}
+		return dumper.DumpDebug(m, dbg, logf)
+	}
+
+	prog, err := mf.load(fs, args)
+	if err != nil {
+		return err
+	}
+	opts := []stackvm.MachBuildOpt{stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di })}
+	if *after {
+		opts = append(opts, stackvm.Handler(stackvm.MachHandlerFunc(dumpMach)))
+	}
+	m, err := mf.build(prog, opts...)
+	if err != nil {
+		return err
+	}
+	if *after {
+		return m.Run()
+	}
+	return dumpMach(m)
+}
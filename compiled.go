@@ -0,0 +1,153 @@
+package stackvm
+
+// Block is the code of a basic block within a program, compiled ahead of time
+// (see package x/aot) into a Go function that executes its N ops. The function
+// is built from the primitive methods of the BlockMach that it's passed,
+// rather than decoding and dispatching each op.
+type Block struct {
+	N   uint // number of ops within the block
+	Run func(m BlockMach)
+}
+
+// BlockMach is the machine running a compiled Block, as seen by the block:
+// its methods are the primitives that compiled code is built from, which
+// aren't otherwise available. Those that may fail set the machine's error,
+// and return false, if they do; the block must then return.
+type BlockMach struct{ m *Mach }
+
+// Compiled passes compiled code for the program, by the address of each
+// block, to a New()ly built machine. Run calls the block at the machine's ip,
+// if any, rather than decoding and executing its ops; code outside of any
+// block is still interpreted, as is all code under Trace or Step, or while
+// recording or any breakpoint is set, or when a block would exceed the op
+// limit. Copies of the machine, made by fork or branch ops, run the same
+// blocks.
+//
+// The blocks must have been compiled from the same program.
+func Compiled(blocks map[uint32]Block) MachBuildOpt {
+	var end uint32
+	for addr := range blocks {
+		if addr >= end {
+			end = addr + 1
+		}
+	}
+	byAddr := make([]Block, end)
+	for addr, blk := range blocks {
+		byAddr[addr] = blk
+	}
+	return func(mb *machBuilder) error {
+		mb.Mach.ctx.blocks = byAddr
+		return nil
+	}
+}
+
+// Tick starts executing an op, by counting it against the machine's op limit,
+// and advancing its ip to the address after the op. Run only calls a block
+// if all of its ops are within the limit.
+func (b BlockMach) Tick(next uint32) { b.m.tick(next) }
+
+// Head returns the value atop the parameter stack, to be operated on in place.
+func (b BlockMach) Head() *uint32 { return &b.m.pa }
+
+// PushVal pushes a value onto the parameter stack.
+func (b BlockMach) PushVal(val uint32) bool { return b.m.ok(b.m.push(val)) }
+
+// PopVal pops a value from the parameter stack.
+func (b BlockMach) PopVal() (uint32, bool) {
+	val, err := b.m.pop()
+	return val, b.m.ok(err)
+}
+
+// ParamRef returns a reference to the i-th value of the parameter stack,
+// counting from 1 for the value atop it.
+func (b BlockMach) ParamRef(i uint32) (*uint32, bool) {
+	p, err := b.m.pRef(i)
+	return p, b.m.ok(err)
+}
+
+// FetchVal fetches a word from memory.
+func (b BlockMach) FetchVal(addr uint32) (uint32, bool) {
+	val, err := b.m.fetch(addr)
+	return val, b.m.ok(err)
+}
+
+// StoreVal stores a word into memory.
+func (b BlockMach) StoreVal(addr, val uint32) bool { return b.m.ok(b.m.store(addr, val)) }
+
+// MemRef returns a reference to a word of memory, to be stored through.
+func (b BlockMach) MemRef(addr uint32) (*uint32, bool) {
+	p, err := b.m.ref(addr)
+	return p, b.m.ok(err)
+}
+
+// CPushVal pushes a value onto the control stack.
+func (b BlockMach) CPushVal(val uint32) bool { return b.m.ok(b.m.cpush(val)) }
+
+// CPopVal pops a value from the control stack.
+func (b BlockMach) CPopVal() (uint32, bool) {
+	val, err := b.m.cpop()
+	return val, b.m.ok(err)
+}
+
+// Jump jumps by an offset from the machine's ip.
+func (b BlockMach) Jump(off int32) bool { return b.m.ok(b.m.jump(off)) }
+
+// CJump jumps to an address popped from the control stack.
+func (b BlockMach) CJump() bool { return b.m.ok(b.m.cjump()) }
+
+// Call calls the routine at an address.
+func (b BlockMach) Call(ip uint32) bool { return b.m.ok(b.m.call(ip)) }
+
+// Ret returns from a routine.
+func (b BlockMach) Ret() bool { return b.m.ok(b.m.ret()) }
+
+// Fork enqueues a copy of the machine that continues at an offset from its
+// ip, while the machine continues at its ip; site is the address of the op.
+func (b BlockMach) Fork(site uint32, off int32) bool { return b.m.ok(b.m.fork(site, off)) }
+
+// CFork is like Fork, but the copy continues at an address popped from its
+// control stack.
+func (b BlockMach) CFork(site uint32) bool { return b.m.ok(b.m.cfork(site)) }
+
+// Branch enqueues a copy of the machine that continues at its ip, while the
+// machine continues at an offset from it; site is the address of the op.
+func (b BlockMach) Branch(site uint32, off int32) bool { return b.m.ok(b.m.branch(site, off)) }
+
+// CBranch is like Branch, but the machine continues at an address popped
+// from its control stack.
+func (b BlockMach) CBranch(site uint32) bool { return b.m.ok(b.m.cbranch(site)) }
+
+// Halt halts the machine with the given code.
+func (b BlockMach) Halt(code uint32) {
+	b.m.pa = code
+	b.m.err = errHalted
+}
+
+// Exec executes an op, as though it had been decoded at site, for ops that
+// have no more primitive form.
+func (b BlockMach) Exec(site uint32, op Op) bool {
+	code := opCode(op.Code)
+	if op.Have {
+		code |= opCodeWithImm
+	}
+	b.m.exec(site, cachedOp{ip: b.m.ip, code: code, arg: op.Arg})
+	return b.m.err == nil
+}
+
+// tick starts executing an op of a compiled block or a thread; see
+// BlockMach.Tick. Run only calls a block, or runs a thread of interpreted ops,
+// if all of its ops are within the limit.
+func (m *Mach) tick(next uint32) {
+	if m.limit != 0 {
+		m.count++
+	}
+	m.ip = next
+}
+
+func (m *Mach) ok(err error) bool {
+	if err != nil {
+		m.err = err
+		return false
+	}
+	return true
+}
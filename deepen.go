@@ -0,0 +1,60 @@
+package stackvm
+
+import "errors"
+
+var errNoHandler = errors.New("no handler, cannot deepen")
+
+// Deepen runs a program under iterative deepening: it builds and runs a new
+// machine with a fork depth limit of 1, then 2, and so on, until a round
+// produces at least one successful result (a machine that halted with code
+// 0), a round finishes without any machine being cut off by the depth limit,
+// or the given max depth has been searched; a max of 0 means no upper bound.
+//
+// Every machine, other than those stopped by the depth limit, is passed to the
+// given handler, which must not be nil; since each round re-searches all
+// shallower paths, the handler may see the same failed machines more than
+// once. Any error returned by the handler (or from building a machine) stops
+// the search.
+//
+// The depth of the last round run is returned; any MaxDepth or Handler options
+// passed will be overridden.
+func Deepen(prog []byte, h MachHandler, max int, mbos ...MachBuildOpt) (int, error) {
+	if h == nil {
+		return 0, errNoHandler
+	}
+	opts := make([]MachBuildOpt, len(mbos), len(mbos)+2)
+	copy(opts, mbos)
+	depth := 0
+	for max == 0 || depth < max {
+		depth++
+		dh := deepenHandler{MachHandler: h}
+		m, err := New(prog, append(opts, MaxDepth(depth), Handler(&dh))...)
+		if err != nil {
+			return depth, err
+		}
+		if err := m.Run(); err != nil {
+			return depth, err
+		}
+		if dh.results > 0 || dh.cutoffs == 0 {
+			break
+		}
+	}
+	return depth, nil
+}
+
+type deepenHandler struct {
+	MachHandler
+	results int
+	cutoffs int
+}
+
+func (dh *deepenHandler) Handle(m *Mach) error {
+	if m.DepthLimited() {
+		dh.cutoffs++
+		return nil
+	}
+	if code, halted := m.halted(); halted && code == 0 {
+		dh.results++
+	}
+	return dh.MachHandler.Handle(m)
+}
@@ -4,17 +4,11 @@ import subprocess
 import sys
 
 
-def proc(f):
-    if 'GOPACKAGE' in os.environ:
-        yield 'package %s' % os.environ['GOPACKAGE']
-        yield ''
-
+def defs(f):
     for line in f:
         if line.startswith('var ops = '):
             break
 
-    yield 'const ('
-
     code = 0
 
     for line in f:
@@ -36,23 +30,69 @@ def proc(f):
                     name = part[i:j]
 
             if name:
-                yield 'opCode%s = opCode(0x%02x)' % (name.title(), code)
+                yield code, name, part[:part.find('(')]
 
             code += 1
 
+
+def header():
+    if 'GOPACKAGE' in os.environ:
+        yield 'package %s' % os.environ['GOPACKAGE']
+        yield ''
+
+
+def proc(f):
+    for line in header():
+        yield line
+
+    yield 'const ('
+
+    for code, name, _ in defs(f):
+        yield 'opCode%s = opCode(0x%02x)' % (name.title(), code)
+
     yield ')'
 
 
+def proc_builder(f):
+    for line in header():
+        yield line
+
+    for _, name, kind in defs(f):
+        meth = name[0].upper() + name[1:]
+        yield ''
+        yield '// %s adds a "%s" operation.' % (meth, name)
+        yield 'func (b *Builder) %s() *Builder { return b.Op("%s") }' % (
+            meth, name)
+
+        if kind in ('valop', 'addrop', 'offop'):
+            yield ''
+            yield ('// %sImm adds a "%s" operation, '
+                   'with an immediate value.') % (meth, name)
+            yield ('func (b *Builder) %sImm(v int) *Builder '
+                   '{ return b.Imm(v).Op("%s") }') % (meth, name)
+
+        if kind in ('addrop', 'offop'):
+            suffix = 'At' if kind == 'addrop' else 'To'
+            yield ''
+            yield ('// %s%s adds a "%s" operation, '
+                   'referencing the given label.') % (meth, suffix, name)
+            yield ('func (b *Builder) %s%s(label string) *Builder '
+                   '{ return b.Ref(label).Op("%s") }') % (meth, suffix, name)
+
+
 parser = argparse.ArgumentParser()
 parser.add_argument(
     '-i', metavar='file', type=argparse.FileType('r'), default=sys.stdin)
 parser.add_argument(
     '-o', metavar='file', type=argparse.FileType('w'), default=sys.stdout)
+parser.add_argument(
+    '-builder', action='store_true',
+    help='generate xstackvm.Builder methods, rather than op code constants')
 args = parser.parse_args()
 
 gofmt = subprocess.Popen('gofmt', stdin=subprocess.PIPE, stdout=args.o)
 
-for line in proc(args.i):
+for line in (proc_builder if args.builder else proc)(args.i):
     print >>gofmt.stdin, line
 
 gofmt.stdin.close()
@@ -36,6 +36,9 @@ const (
 	opCodeJz      = opCode(0x32)
 	opCodeCall    = opCode(0x33)
 	opCodeRet     = opCode(0x34)
+	opCodeYield   = opCode(0x38)
+	opCodeYieldr  = opCode(0x39)
+	opCodeBrk     = opCode(0x3a)
 	opCodeFork    = opCode(0x40)
 	opCodeFnz     = opCode(0x41)
 	opCodeFz      = opCode(0x42)
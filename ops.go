@@ -25,6 +25,22 @@ func (c opCode) String() string {
 func (c opCode) hasImm() bool { return (c & opCodeWithImm) != 0 }
 func (c opCode) code() uint8  { return uint8(c & ^opCodeWithImm) }
 
+// endsBlock returns true if the op may transfer control, or stop the machine;
+// it's the last op of any basic block, or thread of pre-decoded ops.
+func (c opCode) endsBlock() bool {
+	switch opCode(c.code()) {
+	case opCodeCrash,
+		opCodeJump, opCodeJnz, opCodeJz,
+		opCodeCall, opCodeRet,
+		opCodeFork, opCodeFnz, opCodeFz,
+		opCodeBranch, opCodeBnz, opCodeBz,
+		opCodeBrk, opCodeYield, opCodeYieldr,
+		opCodeHalt, opCodeHz, opCodeHnz:
+		return true
+	}
+	return false
+}
+
 type opImmKind int
 
 const (
@@ -115,7 +131,8 @@ var ops = [128]opDef{
 	addrop("call"), justop("ret"),
 	noop, noop, noop,
 	// 0x38
-	noop, noop, noop, noop, noop, noop, noop, noop,
+	valop("yield"), justop("yieldr"), justop("brk"),
+	noop, noop, noop, noop, noop,
 	// 0x40
 	offop("fork"), offop("fnz"), offop("fz"),
 	noop, noop, noop, noop, noop,
@@ -0,0 +1,67 @@
+package stackvm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ForkStep records one decision made by a machine lineage at a fork site:
+// every fork, branch, or conditional variant thereof that copied the machine
+// results in one step on both the original machine and its copy.
+type ForkStep struct {
+	IP    uint32 // address of the forking operation
+	Taken bool   // whether this lineage followed the jump
+}
+
+func (fs ForkStep) String() string {
+	if fs.Taken {
+		return fmt.Sprintf("0x%04x+", fs.IP)
+	}
+	return fmt.Sprintf("0x%04x-", fs.IP)
+}
+
+// ForkPath is a sequence of fork decisions, ordered from the root machine.
+type ForkPath []ForkStep
+
+func (fp ForkPath) String() string {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, fs := range fp {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fs.String())
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// forkPath is a persistent list of fork steps; since a copy shares all of its
+// ancestor's steps, each fork costs only one node per lineage.
+type forkPath struct {
+	prior *forkPath
+	n     int
+	ForkStep
+}
+
+func (fp *forkPath) add(ip uint32, taken bool) *forkPath {
+	n := 1
+	if fp != nil {
+		n += fp.n
+	}
+	return &forkPath{fp, n, ForkStep{ip, taken}}
+}
+
+// Path returns the fork decisions made by the machine's lineage since the
+// machine originally built by New(); it is empty for the original machine
+// until it forks.
+func (m *Mach) Path() ForkPath {
+	if m.path == nil {
+		return nil
+	}
+	fp := make(ForkPath, m.path.n)
+	for i, node := m.path.n-1, m.path; node != nil; i, node = i-1, node.prior {
+		fp[i] = node.ForkStep
+	}
+	return fp
+}
@@ -9,6 +9,15 @@ type queue interface {
 	Dequeue() *Mach
 }
 
+// MachQueue holds machine copies that are pending to run: Enqueue is called
+// with each copy made by an operation like fork or branch, and Dequeue is
+// called once the current machine has been handled; returning nil from
+// Dequeue ends the run.
+type MachQueue interface {
+	Enqueue(*Mach) error
+	Dequeue() *Mach
+}
+
 // runq implements a capped lifo queue; it is not thread safe.
 type runq struct {
 	q []*Mach
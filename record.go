@@ -0,0 +1,135 @@
+package stackvm
+
+import "errors"
+
+var (
+	errNotRecording = errors.New("machine not recording history")
+	errNoHistory    = errors.New("no recorded history")
+)
+
+// Record enables recording an undo history for every operation executed by
+// the machine, and any copies of it, so that it may be rewound with StepBack,
+// RunBackTo, or RunBackToChange. Each undo record holds the machine's prior
+// registers, and the prior value of every memory word written by the
+// operation; the history is unbounded, so recording is best suited to
+// debugging rather than searching large spaces.
+func Record() MachBuildOpt {
+	return func(mb *machBuilder) error {
+		mb.Mach.ctx.record = true
+		return nil
+	}
+}
+
+// undoRec restores a machine to its state before an operation; records are
+// shared by copies, forming a persistent list like forkPath.
+type undoRec struct {
+	prior   *undoRec
+	ip      uint32
+	pa      uint32
+	psp     uint32
+	csp     uint32
+	count   uint
+	depth   uint
+	err     error
+	path    *forkPath
+	yielded []uint32
+	mem     []memUndo
+}
+
+type memUndo struct {
+	addr, val uint32
+}
+
+func (m *Mach) recordStep() {
+	m.undo = &undoRec{
+		prior:   m.undo,
+		ip:      m.ip,
+		pa:      m.pa,
+		psp:     m.psp,
+		csp:     m.csp,
+		count:   m.count,
+		depth:   m.depth,
+		err:     m.err,
+		path:    m.path,
+		yielded: m.yielded,
+	}
+}
+
+// recordCopy gives a new copy its own copy of the current undo record, so
+// that the original's subsequent writes aren't recorded in the copy's
+// history.
+func (m *Mach) recordCopy() {
+	if r := m.undo; r != nil {
+		nr := *r
+		nr.mem = append([]memUndo(nil), r.mem...)
+		m.undo = &nr
+	}
+}
+
+func (m *Mach) undoStep() bool {
+	r := m.undo
+	if r == nil {
+		return false
+	}
+	m.undo = nil // don't record the restoring writes
+	for i := len(r.mem) - 1; i >= 0; i-- {
+		if p, err := m.ref(r.mem[i].addr); err == nil {
+			*p = r.mem[i].val
+		}
+	}
+	m.ip, m.pa, m.psp, m.csp = r.ip, r.pa, r.psp, r.csp
+	m.count, m.depth, m.err = r.count, r.depth, r.err
+	m.path, m.yielded = r.path, r.yielded
+	m.undo = r.prior
+	// don't pause on a host breakpoint at the rewound ip when resuming
+	m.skipBrk = true
+	return true
+}
+
+func (m *Mach) rewind(until func() bool) error {
+	if !m.ctx.record {
+		return MachError{m.ip, errNotRecording}
+	}
+	for {
+		if !m.undoStep() {
+			return MachError{m.ip, errNoHistory}
+		}
+		if until() {
+			return nil
+		}
+	}
+}
+
+// StepBack undoes the last operation executed by a machine built with the
+// Record option, restoring its registers and memory; an error is returned if
+// there's no history left.
+func (m *Mach) StepBack() error {
+	return m.rewind(func() bool { return true })
+}
+
+// RunBackTo steps back until the machine is about to execute the operation at
+// the given address again. If no such point is recorded, an error is returned
+// and the machine is left at the start of its history.
+func (m *Mach) RunBackTo(ip uint32) error {
+	return m.rewind(func() bool { return m.ip == ip })
+}
+
+// RunBackToChange steps back until the machine is about to execute the last
+// operation that changed the memory word at the given address, e.g. to find
+// where a wrong value was stored into an output region. If no such operation
+// is recorded, an error is returned and the machine is left at the start of
+// its history.
+func (m *Mach) RunBackToChange(addr uint32) error {
+	val, err := m.fetch(addr)
+	if err != nil {
+		return MachError{m.ip, err}
+	}
+	return m.rewind(func() bool {
+		prior, _ := m.fetch(addr)
+		if prior != val {
+			return true
+		}
+		val = prior
+		return false
+	})
+}
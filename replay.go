@@ -0,0 +1,79 @@
+package stackvm
+
+import "errors"
+
+var (
+	errReplayDiverged   = errors.New("replay diverged from fork path")
+	errReplayIncomplete = errors.New("replay ended before fork path")
+)
+
+// Replay builds a machine that re-executes only the lineage described by a
+// fork path, as returned by Mach.Path() from a prior run of the same program
+// with the same inputs. Rather than copying the machine, each fork, branch,
+// or conditional variant thereof follows the next recorded decision; the
+// other side is discarded. Running the returned machine (with Run or Trace)
+// therefore reconstructs the final state of that one result machine.
+//
+// If the machine reaches a fork site not recorded next in the path, it
+// halts with a divergence error; if it halts before consuming the whole path,
+// running it returns an error.
+func Replay(prog []byte, path ForkPath, mbos ...MachBuildOpt) (*Mach, error) {
+	opts := make([]MachBuildOpt, len(mbos), len(mbos)+1)
+	copy(opts, mbos)
+	return New(prog, append(opts, withReplay(path))...)
+}
+
+func withReplay(path ForkPath) MachBuildOpt {
+	return func(mb *machBuilder) error {
+		rp := &replayer{path: path}
+		mb.Mach.ctx.replay = rp
+		mb.Mach.ctx.MachHandler = replayHandler{rp, mb.Mach.ctx.MachHandler}
+		return nil
+	}
+}
+
+type replayer struct {
+	path ForkPath
+	i    int
+}
+
+func (rp *replayer) next(site uint32) (bool, error) {
+	if rp.i >= len(rp.path) || rp.path[rp.i].IP != site {
+		return false, errReplayDiverged
+	}
+	fs := rp.path[rp.i]
+	rp.i++
+	return fs.Taken, nil
+}
+
+type replayHandler struct {
+	rp *replayer
+	MachHandler
+}
+
+func (rh replayHandler) Handle(m *Mach) error {
+	if rh.rp.i < len(rh.rp.path) && m.err != errReplayDiverged {
+		return MachError{m.ip, errReplayIncomplete}
+	}
+	return rh.MachHandler.Handle(m)
+}
+
+// replayFork follows the next recorded decision at a fork site, updating the
+// machine as if it were the lineage that made it; copyTakes says whether the
+// copy is the one that would follow the jump. A decision to follow the copy
+// counts against any depth limit, returning the limit error after updating
+// the machine.
+func (m *Mach) replayFork(site uint32, copyTakes bool) (bool, error) {
+	taken, err := m.ctx.replay.next(site)
+	if err != nil {
+		return false, err
+	}
+	m.path = m.path.add(site, taken)
+	if taken == copyTakes {
+		m.depth++
+		if m.maxDepth != 0 && m.depth > m.maxDepth {
+			return taken, errDepthLimit
+		}
+	}
+	return taken, nil
+}
@@ -0,0 +1,239 @@
+package stackvm
+
+// maxThreadLen limits how many ops are decoded into a thread.
+const maxThreadLen = 32
+
+// thread is a straight-line run of ops, decoded ahead of time so that run may
+// execute all of them in one step, rather than going through the op cache,
+// and checking for breakpoints and limits, for each one. A thread ends with
+// the first op that may transfer control, or stop the machine; so every op
+// within it, other than the last, is followed by the next unless it fails.
+//
+// Common sequences of ops within a thread are fused into superinstructions,
+// which execute them without dispatching each one.
+//
+// Threads are cached alongside the ops that start them, and so they're
+// shared by every copy of a machine; like the op cache, they assume that code
+// isn't modified once it has run, other than by MemStore, which drops them.
+type thread struct {
+	n   uint // number of ops within the thread
+	ops []threadOp
+}
+
+type threadOp struct {
+	site uint32 // where the op's encoding starts
+	cachedOp
+	fused fusedFunc // executes this op and the next fuse-1 ops, if not nil
+	fuse  int
+}
+
+type fusedFunc func(m *Mach, ops []threadOp)
+
+// stepThread is like step, but executes the whole thread of ops at m.ip, or
+// the compiled block there, if any. It falls back to step if each op must be
+// observed: when recording, when any breakpoint is set, or when the thread
+// would exceed the op limit. Like step, it counts each op against the limit
+// as it's executed, so an op that fails leaves the rest uncounted.
+func (m *Mach) stepThread() {
+	if m.ctx.record || (m.ctx.brks != nil && len(m.ctx.brks.addrs) > 0) {
+		m.step()
+		return
+	}
+	if m.ip < uint32(len(m.ctx.blocks)) {
+		if blk := &m.ctx.blocks[m.ip]; blk.Run != nil && (m.limit == 0 || m.count+blk.N <= m.limit) {
+			blk.Run(BlockMach{m})
+			return
+		}
+	}
+	th := m.thread()
+	if th == nil || (m.limit != 0 && m.count+th.n > m.limit) {
+		m.step()
+		return
+	}
+	for i := 0; i < len(th.ops) && m.err == nil; {
+		op := &th.ops[i]
+		if op.fused != nil {
+			op.fused(m, th.ops[i:i+op.fuse])
+			i += op.fuse
+			continue
+		}
+		m.tick(op.ip)
+		m.exec(op.site, op.cachedOp)
+		i++
+	}
+}
+
+// thread returns the thread starting at m.ip, decoding it if necessary; it
+// returns nil if the op there can't be decoded.
+func (m *Mach) thread() *thread {
+	ck := m.ip - m.cbp
+	if ck < uint32(len(m.opc.cos)) {
+		if th := m.opc.cos[ck].thread; th != nil {
+			return th
+		}
+	}
+
+	var th thread
+	for addr := m.ip; len(th.ops) < maxThreadLen; {
+		oc, err := m.decode(addr)
+		if err != nil {
+			break
+		}
+		oc.thread = nil
+		th.ops = append(th.ops, threadOp{site: addr, cachedOp: oc})
+		addr = oc.ip
+		if oc.code.endsBlock() {
+			break
+		}
+	}
+	if len(th.ops) == 0 {
+		return nil
+	}
+	th.n = uint(len(th.ops))
+	th.fuse()
+
+	// the first op was just decoded, so is cached
+	m.opc.cos[ck].thread = &th
+	return &th
+}
+
+// fusion is a superinstruction, replacing a sequence of ops matched by its
+// pattern; each pattern element matches an op code, or its immediate form.
+type fusion struct {
+	pattern []func(code opCode) bool
+	fused   fusedFunc
+}
+
+var fusions = []fusion{
+	{[]func(code opCode) bool{isOp(opCodeDup), isImmOp(compareOps...), isImmOp(condOps...)}, fusedDupCompareCond},
+	{[]func(code opCode) bool{isImmOp(opCodeFetch), isOp(arithOps...)}, fusedFetchArith},
+}
+
+var (
+	compareOps = []opCode{opCodeLt, opCodeLte, opCodeGt, opCodeEq, opCodeNeq}
+	condOps    = []opCode{opCodeJnz, opCodeFnz, opCodeFz}
+	arithOps   = []opCode{opCodeAdd, opCodeSub, opCodeMul}
+)
+
+// isOp matches any of the given op codes, without an immediate.
+func isOp(codes ...opCode) func(code opCode) bool { return matchOp(false, codes) }
+
+// isImmOp matches any of the given op codes, with an immediate.
+func isImmOp(codes ...opCode) func(code opCode) bool { return matchOp(true, codes) }
+
+func matchOp(imm bool, codes []opCode) func(code opCode) bool {
+	var want [128]bool
+	for _, c := range codes {
+		want[c] = true
+	}
+	return func(code opCode) bool {
+		return code.hasImm() == imm && want[code.code()]
+	}
+}
+
+// fuse marks any sequences of ops that match a fusion.
+func (th *thread) fuse() {
+	for i := 0; i < len(th.ops); {
+		n := 1
+		for _, fu := range fusions {
+			if fu.match(th.ops[i:]) {
+				th.ops[i].fused = fu.fused
+				th.ops[i].fuse = len(fu.pattern)
+				n = len(fu.pattern)
+				break
+			}
+		}
+		i += n
+	}
+}
+
+func (fu fusion) match(ops []threadOp) bool {
+	if len(ops) < len(fu.pattern) {
+		return false
+	}
+	for i, p := range fu.pattern {
+		if !p(ops[i].code) {
+			return false
+		}
+	}
+	return true
+}
+
+func compare(code opCode, a, b uint32) uint32 {
+	switch code.code() {
+	case uint8(opCodeLt):
+		return bool2uint32(a < b)
+	case uint8(opCodeLte):
+		return bool2uint32(a <= b)
+	case uint8(opCodeGt):
+		return bool2uint32(a > b)
+	case uint8(opCodeEq):
+		return bool2uint32(a == b)
+	default: // opCodeNeq
+		return bool2uint32(a != b)
+	}
+}
+
+// fusedDupCompareCond is "dup", "$b compare", "$off cond": it compares the
+// value atop the stack to b, then jumps or forks.
+func fusedDupCompareCond(m *Mach, ops []threadOp) {
+	dup, cmp, cond := &ops[0], &ops[1], &ops[2]
+
+	m.tick(dup.ip)
+	if m.err = m.push(m.pa); m.err != nil {
+		return
+	}
+
+	m.tick(cmp.ip)
+	m.pa = compare(cmp.code, m.pa, cmp.arg)
+
+	m.tick(cond.ip)
+	val, err := m.pop()
+	if err == nil {
+		switch cond.code.code() {
+		case uint8(opCodeJnz):
+			if val != 0 {
+				err = m.jump(int32(cond.arg))
+			}
+		case uint8(opCodeFnz):
+			if val != 0 {
+				err = m.fork(cond.site, int32(cond.arg))
+			}
+		case uint8(opCodeFz):
+			if val == 0 {
+				err = m.fork(cond.site, int32(cond.arg))
+			}
+		}
+	}
+	m.err = err
+}
+
+// fusedFetchArith is "@addr fetch", "arith": it applies the arithmetic op to
+// the value atop the stack and the one fetched.
+func fusedFetchArith(m *Mach, ops []threadOp) {
+	fetch, arith := &ops[0], &ops[1]
+
+	m.tick(fetch.ip)
+	b, err := m.fetch(fetch.arg)
+	if err == nil {
+		err = m.push(b)
+	}
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	m.tick(arith.ip)
+	if b, err = m.pop(); err != nil {
+		m.err = err
+		return
+	}
+	switch arith.code {
+	case opCodeAdd:
+		m.pa += b
+	case opCodeSub:
+		m.pa -= b
+	case opCodeMul:
+		m.pa *= b
+	}
+}
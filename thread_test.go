@@ -0,0 +1,37 @@
+package stackvm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMach_stepThread_count checks that a thread charges the op limit only for
+// the ops that it executes, like step, when one fails part way through.
+func TestMach_stepThread_count(t *testing.T) {
+	prog := []byte{
+		0x00,       // end of options
+		0x81, 0x02, // 1 push
+		0x03,       // pop
+		0x03,       // pop, which underflows
+		0x82, 0x02, // 2 push
+		0x80, 0x7f, // 0 halt
+	}
+	var counts []uint
+	for _, threaded := range []bool{false, true} {
+		m, err := New(prog)
+		require.NoError(t, err, "unexpected build error")
+		m.limit = 100
+		for m.err == nil {
+			if threaded {
+				m.stepThread()
+			} else {
+				m.step()
+			}
+		}
+		assert.Contains(t, m.err.Error(), "underflow", "expected a stack underflow")
+		counts = append(counts, m.count)
+	}
+	assert.Equal(t, []uint{3, 3}, counts, "expected the same op count when threaded")
+}
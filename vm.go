@@ -22,6 +22,9 @@ var (
 	errHalted       = errors.New("halted")
 	errCrashed      = errors.New("crashed")
 	errLimit        = errors.New("op count limit exceeded")
+	errDepthLimit   = errors.New("fork depth limit exceeded")
+	errYielded      = errors.New("yielded")
+	errPaused       = errors.New("paused")
 )
 
 type alignmentError struct {
@@ -65,7 +68,13 @@ type context struct {
 	machAllocator
 	pageAllocator
 	queue
+	inputs  []region
 	outputs []region
+	replay  *replayer
+	brks    *breakpoints
+	blocks  []Block // compiled code, by address
+	dbg     debugInfo
+	record  bool
 }
 
 // Mach is a stack machine.
@@ -79,6 +88,12 @@ type Mach struct {
 	cbp, csp uint32  // control stack
 	count    uint
 	limit    uint
+	depth    uint // number of ancestors created by copy
+	maxDepth uint
+	path     *forkPath // fork decisions since the root machine
+	yielded  []uint32  // values passed to the host by the last yield
+	skipBrk  bool      // resuming from a host breakpoint at ip
+	undo     *undoRec  // recorded history, when ctx.record
 	// TODO track code segment and data segment
 	pages []*page // memory
 }
@@ -110,10 +125,35 @@ func (opc *opCache) set(k uint32, co cachedOp) {
 	return
 }
 
+// invalidate drops any cached ops, and threads, whose encoding overlaps the
+// given [addr, end) range of code that's been modified; the cache is copied
+// first, since it's shared with copies of the machine, whose code isn't.
+func (opc *opCache) invalidate(cbp, addr, end uint32) {
+	if end <= cbp || len(opc.cos) == 0 {
+		return
+	}
+	cos := make([]cachedOp, len(opc.cos))
+	copy(cos, opc.cos)
+	for k := range cos {
+		co := &cos[k]
+		start := cbp + uint32(k)
+		if co.ip == 0 || start >= end {
+			continue
+		}
+		if co.ip > addr {
+			*co = cachedOp{}
+		} else if th := co.thread; th != nil && th.ops[len(th.ops)-1].ip > addr {
+			co.thread = nil
+		}
+	}
+	opc.cos = cos
+}
+
 type cachedOp struct {
-	ip   uint32
-	code opCode
-	arg  uint32
+	ip     uint32
+	code   opCode
+	arg    uint32
+	thread *thread // starting with this op, once decoded
 }
 
 type page struct {
@@ -129,17 +169,31 @@ func (m *Mach) halted() (uint32, bool) {
 }
 
 func (m *Mach) run() (*Mach, error) {
+	orig := m
+	m.resume()
+
 repeat:
 	// live
 	for m.err == nil {
-		m.step()
+		if m.brkHit() {
+			break
+		}
+		m.stepThread()
+	}
+
+	// suspend
+	switch m.err {
+	case errYielded:
+		return m, nil
+	case errPaused:
+		return m, m.Err()
 	}
 
 	// win or die
 	err := m.ctx.Handle(m)
 	if err == nil {
 		if n := m.ctx.Dequeue(); n != nil {
-			m.free()
+			m.freeUnless(orig)
 			m = n
 			// die
 			goto repeat
@@ -150,7 +204,39 @@ repeat:
 	return m, err
 }
 
+func (m *Mach) resume() {
+	switch m.err {
+	case errYielded:
+		m.err = nil
+		m.yielded = nil
+	case errPaused:
+		m.err = nil
+	}
+}
+
+func (m *Mach) suspended() bool {
+	return m.err == errYielded || m.err == errPaused
+}
+
+// brkHit pauses the machine if there's a host breakpoint at its ip, unless
+// it's resuming from that very breakpoint.
+func (m *Mach) brkHit() bool {
+	if m.skipBrk {
+		m.skipBrk = false
+		return false
+	}
+	if bp := m.ctx.brks; bp != nil && len(bp.addrs) > 0 && bp.addrs[m.ip] {
+		m.err = errPaused
+		m.skipBrk = true
+		return true
+	}
+	return false
+}
+
 func (m *Mach) step() {
+	if m.ctx.record {
+		m.recordStep()
+	}
 	if m.limit != 0 {
 		if m.count >= m.limit {
 			m.err = errLimit
@@ -159,19 +245,33 @@ func (m *Mach) step() {
 		m.count++
 	}
 
-	// decode
-	ck := m.ip - m.cbp
+	site := m.ip
+	oc, err := m.decode(site)
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.ip = oc.ip
+	m.exec(site, oc)
+}
+
+// decode returns the op at addr, from the op cache if it has been decoded
+// before.
+func (m *Mach) decode(addr uint32) (oc cachedOp, err error) {
+	ck := addr - m.cbp
 	oc, cached := m.opc.get(ck)
 	if !cached {
-		oc.ip, oc.code, oc.arg, m.err = m.read(m.ip)
-		if m.err != nil {
-			return
+		oc.ip, oc.code, oc.arg, err = m.read(addr)
+		if err == nil {
+			m.opc.set(ck, oc)
 		}
-		m.opc.set(ck, oc)
 	}
-	m.ip = oc.ip
+	return oc, err
+}
 
-	// execute
+// exec executes a decoded op, whose encoding starts at site; m.ip must
+// already point past it.
+func (m *Mach) exec(site uint32, oc cachedOp) {
 	switch oc.code {
 	// nop
 	case opCodeNop:
@@ -722,33 +822,33 @@ func (m *Mach) step() {
 	case opCodeFork:
 		val, err := m.pop()
 		if err == nil {
-			err = m.fork(int32(val))
+			err = m.fork(site, int32(val))
 		}
 		m.err = err
 	case opCodeFnz:
 		val, err := m.pop()
 		if err == nil && val != 0 {
-			err = m.cfork()
+			err = m.cfork(site)
 		}
 		m.err = err
 	case opCodeFz:
 		val, err := m.pop()
 		if err == nil && val == 0 {
-			err = m.cfork()
+			err = m.cfork(site)
 		}
 		m.err = err
 	case opCodeFork | opCodeWithImm:
-		m.err = m.fork(int32(oc.arg))
+		m.err = m.fork(site, int32(oc.arg))
 	case opCodeFnz | opCodeWithImm:
 		val, err := m.pop()
 		if err == nil && val != 0 {
-			err = m.fork(int32(oc.arg))
+			err = m.fork(site, int32(oc.arg))
 		}
 		m.err = err
 	case opCodeFz | opCodeWithImm:
 		val, err := m.pop()
 		if err == nil && val == 0 {
-			err = m.fork(int32(oc.arg))
+			err = m.fork(site, int32(oc.arg))
 		}
 		m.err = err
 
@@ -756,33 +856,62 @@ func (m *Mach) step() {
 	case opCodeBranch:
 		val, err := m.pop()
 		if err == nil {
-			err = m.branch(int32(val))
+			err = m.branch(site, int32(val))
 		}
 		m.err = err
 	case opCodeBnz:
 		val, err := m.pop()
 		if err != nil && val != 0 {
-			err = m.cbranch()
+			err = m.cbranch(site)
 		}
 		m.err = err
 	case opCodeBz:
 		val, err := m.pop()
 		if err == nil && val == 0 {
-			err = m.cbranch()
+			err = m.cbranch(site)
 		}
 		m.err = err
 	case opCodeBranch | opCodeWithImm:
-		m.err = m.branch(int32(oc.arg))
+		m.err = m.branch(site, int32(oc.arg))
 	case opCodeBnz | opCodeWithImm:
 		val, err := m.pop()
 		if err == nil && val != 0 {
-			err = m.branch(int32(oc.arg))
+			err = m.branch(site, int32(oc.arg))
 		}
 		m.err = err
 	case opCodeBz | opCodeWithImm:
 		val, err := m.pop()
 		if err == nil && val == 0 {
-			err = m.branch(int32(oc.arg))
+			err = m.branch(site, int32(oc.arg))
+		}
+		m.err = err
+
+	// control: debugging
+	case opCodeBrk:
+		m.err = errPaused
+
+	// control: yield
+	case opCodeYield:
+		val, err := m.pop()
+		if err == nil {
+			m.yielded = []uint32{val}
+			err = errYielded
+		}
+		m.err = err
+	case opCodeYield | opCodeWithImm:
+		m.yielded = []uint32{oc.arg}
+		m.err = errYielded
+	case opCodeYieldr:
+		to, err := m.pop()
+		if err == nil {
+			var from uint32
+			from, err = m.pop()
+			if err == nil {
+				m.yielded, err = m.fetchMany(from, to)
+				if err == nil {
+					err = errYielded
+				}
+			}
 		}
 		m.err = err
 
@@ -878,6 +1007,11 @@ func (m *Mach) copy() (*Mach, error) {
 	}
 	pgs := n.pages
 	*n = *m
+	n.depth++
+	n.recordCopy()
+	if n.maxDepth != 0 && n.depth > n.maxDepth {
+		n.err = errDepthLimit
+	}
 	if cap(pgs) < len(m.pages) {
 		pgs = make([]*page, 0, len(m.pages))
 	}
@@ -893,6 +1027,23 @@ func (m *Mach) copy() (*Mach, error) {
 }
 
 func (m *Mach) free() {
+	m.release()
+	m.ctx.FreeMach(m)
+}
+
+// freeUnless frees the machine, unless it's the one given; that's the one a
+// caller of Run holds, which takes on the state of whichever copy was last
+// running, so it mustn't be reused by a later copy.
+func (m *Mach) freeUnless(orig *Mach) {
+	if m == orig {
+		m.release()
+	} else {
+		m.free()
+	}
+}
+
+// release releases the machine's memory pages, and history.
+func (m *Mach) release() {
 	for i, pg := range m.pages {
 		if pg != nil {
 			if atomic.AddInt32(&pg.r, -1) <= 0 {
@@ -902,27 +1053,48 @@ func (m *Mach) free() {
 		m.pages[i] = nil
 	}
 	m.pages = m.pages[:0]
-	m.ctx.FreeMach(m)
+	m.path = nil
+	m.undo = nil
 }
 
-func (m *Mach) fork(off int32) error {
+func (m *Mach) fork(site uint32, off int32) error {
 	ip := uint32(int32(m.ip) + off)
 	if ip >= m.pbp && ip <= m.cbp {
 		return errSegfault
 	}
+	if m.ctx.replay != nil {
+		taken, err := m.replayFork(site, true)
+		if taken {
+			m.ip = ip
+		}
+		return err
+	}
 	n, err := m.copy()
 	if err != nil {
 		return err
 	}
+	m.path = m.path.add(site, false)
+	n.path = n.path.add(site, true)
 	n.ip = ip
 	return m.ctx.Enqueue(n)
 }
 
-func (m *Mach) cfork() error {
+func (m *Mach) cfork(site uint32) error {
+	if m.ctx.replay != nil {
+		taken, err := m.replayFork(site, true)
+		if taken {
+			if perr := m.cjump(); perr != nil {
+				return perr
+			}
+		}
+		return err
+	}
 	n, err := m.copy()
 	if err != nil {
 		return err
 	}
+	m.path = m.path.add(site, false)
+	n.path = n.path.add(site, true)
 	ip, err := n.cpop()
 	if err != nil {
 		return err
@@ -933,24 +1105,44 @@ func (m *Mach) cfork() error {
 	return m.ctx.Enqueue(n)
 }
 
-func (m *Mach) branch(off int32) error {
+func (m *Mach) branch(site uint32, off int32) error {
 	ip := uint32(int32(m.ip) + off)
 	if ip >= m.pbp && ip <= m.cbp {
 		return errSegfault
 	}
+	if m.ctx.replay != nil {
+		taken, err := m.replayFork(site, false)
+		if taken {
+			m.ip = ip
+		}
+		return err
+	}
 	n, err := m.copy()
 	if err != nil {
 		return err
 	}
+	m.path = m.path.add(site, true)
+	n.path = n.path.add(site, false)
 	m.ip = ip
 	return m.ctx.Enqueue(n)
 }
 
-func (m *Mach) cbranch() error {
+func (m *Mach) cbranch(site uint32) error {
+	if m.ctx.replay != nil {
+		taken, err := m.replayFork(site, false)
+		if taken {
+			if perr := m.cjump(); perr != nil {
+				return perr
+			}
+		}
+		return err
+	}
 	n, err := m.copy()
 	if err != nil {
 		return err
 	}
+	m.path = m.path.add(site, true)
+	n.path = n.path.add(site, false)
 	ip, err := m.cpop()
 	if err != nil {
 		return err
@@ -1179,6 +1371,9 @@ func (m *Mach) ref(addr uint32) (*uint32, error) {
 
 load:
 	p := (*uint32)(unsafe.Pointer(&(pg.d[off])))
+	if m.undo != nil {
+		m.undo.mem = append(m.undo.mem, memUndo{addr, *p})
+	}
 	return p, nil
 }
 
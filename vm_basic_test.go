@@ -173,6 +173,16 @@ func TestMach_operational_errors(t *testing.T) {
 				Err: "max copies(100) exceeded",
 			}.WithExpectedHaltCodes(1, 2),
 		},
+		{
+			Name: "maxdepth stops an infinite fork loop",
+			Prog: []interface{}{
+				".maxDepth", 3,
+				"loop:", "nop", ":loop", "fork", 1, "halt",
+			},
+			Result: Result{
+				Err: "fork depth limit exceeded",
+			}.WithExpectedHaltCodes(1),
+		},
 	}.Run(t)
 }
 
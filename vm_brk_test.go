@@ -0,0 +1,84 @@
+package stackvm_test
+
+import (
+	"testing"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMach_breakpoints(t *testing.T) {
+	var codes []uint32
+	m, err := stackvm.New(MustAssemble(
+		":other", "fork",
+		"brk",
+		"here:", 1, "halt",
+		"other:", 2, "halt",
+	), stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		code, _ := m.HaltCode()
+		codes = append(codes, code)
+		return nil
+	})))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.SetLabelBreakpoint("other"), "unexpected breakpoint error")
+	require.Error(t, m.SetLabelBreakpoint("nope"), "expected undefined label error")
+
+	// the brk op pauses the original machine
+	err = m.Run()
+	require.IsType(t, stackvm.Paused{}, err, "expected to pause")
+	assert.True(t, m.Paused(), "expected machine to be paused")
+	assert.Empty(t, codes, "expected no machines to have been handled yet")
+
+	// after which it halts, and its queued copy hits the host breakpoint
+	err = m.Continue()
+	require.IsType(t, stackvm.Paused{}, err, "expected to pause again")
+	assert.Equal(t, m.Breakpoints(), []uint32{err.(stackvm.Paused).Addr}, "expected to pause at the host breakpoint")
+	assert.Equal(t, []uint32{1}, codes)
+
+	// continuing runs the copy to completion
+	require.NoError(t, m.Continue(), "unexpected continue error")
+	assert.False(t, m.Paused(), "expected machine to not be paused")
+	assert.Equal(t, []uint32{1, 2}, codes)
+}
+
+func TestMach_breakpoints_fork(t *testing.T) {
+	// A queued copy pauses while another is still queued, and forks again once
+	// continued.
+	prog := MustAssemble(
+		":sib", "fork",
+		":child", "fork",
+		1, "halt",
+		"child:",
+		"brk",
+		":grand", "fork",
+		2, "halt",
+		"grand:", 3, "halt",
+		"sib:", 4, "halt",
+	)
+	for _, tc := range []struct {
+		name string
+		run  func(m *stackvm.Mach) error
+	}{
+		{"run", (*stackvm.Mach).Run},
+		{"trace", func(m *stackvm.Mach) error { return m.Trace(nopTracer{}) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var codes []uint32
+			m, err := stackvm.New(prog, stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+				code, _ := m.HaltCode()
+				codes = append(codes, code)
+				return nil
+			})))
+			require.NoError(t, err, "unexpected build error")
+
+			err = tc.run(m)
+			require.IsType(t, stackvm.Paused{}, err, "expected a copy to pause")
+			assert.Equal(t, []uint32{1}, codes)
+
+			require.NoError(t, m.Continue(), "unexpected continue error")
+			assert.Equal(t, []uint32{1, 2, 3, 4}, codes)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package stackvm_test
+
+import (
+	"testing"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeepen_shortest_path(t *testing.T) {
+	// Search for the shortest sequence of increments and doublings that takes
+	// 1 to 10; every step forks both choices, so the search tree is infinitely
+	// deep, and only an iterative deepening search can run it.
+	prog, err := Assemble(
+		".queueSize", 32,
+
+		".data",
+		".in", "goal:", 0,
+		".out", "steps:", 0,
+
+		".entry", "main:",
+		1, "push", // v :
+
+		"loop:",
+		"dup", ":goal", "fetch", "eq", // v v==goal :
+		":done", "jnz", // v :
+		":steps", "fetch", 1, "add", ":steps", "storeTo", // v : -- steps++
+		":double", "fork",
+		":inc", "fork",
+		1, "halt",
+
+		"inc:",
+		1, "add", ":loop", "jump",
+
+		"double:",
+		2, "mul", ":loop", "jump",
+
+		"done:",
+		"halt",
+	)
+	require.NoError(t, err, "unexpected assembler error")
+
+	var results []map[string][]uint32
+	depth, err := stackvm.Deepen(prog, stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		if m.Err() != nil {
+			return nil
+		}
+		vals, err := m.NamedValues()
+		if err == nil {
+			results = append(results, vals)
+		}
+		return err
+	}), 8, stackvm.Input([]uint32{10}))
+	require.NoError(t, err, "unexpected deepen error")
+
+	assert.Equal(t, 4, depth, "expected to stop at depth 4")
+	assert.Equal(t, []map[string][]uint32{
+		{"steps": {4}}, // 1 +1 2 *2 4 +1 5 *2 10
+		{"steps": {4}}, // 1 *2 2 *2 4 +1 5 *2 10
+	}, results)
+}
+
+func TestDeepen_no_handler(t *testing.T) {
+	prog := MustAssemble(".entry", "main:", 0, "halt")
+	depth, err := stackvm.Deepen(prog, nil, 0)
+	assert.EqualError(t, err, "no handler, cannot deepen", "expected a nil handler error")
+	assert.Equal(t, 0, depth, "expected no rounds")
+}
@@ -0,0 +1,107 @@
+package stackvm_test
+
+import (
+	"testing"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMach_Path(t *testing.T) {
+	prog := MustAssemble(
+		"f1:", ":a", "fork",
+		"f2:", ":b", "branch",
+		1, "halt",
+		"a:", 2, "halt",
+		"b:", 3, "halt",
+	)
+
+	sites := make(map[string]uint32)
+	paths := make(map[uint32]stackvm.ForkPath)
+	m, err := stackvm.New(prog,
+		stackvm.WithDebugInfo(func(dbg stackvm.DebugInfo) {
+			for _, addr := range dbg.LabeledAddrs() {
+				for _, label := range dbg.Labels(addr) {
+					sites[label] = addr
+				}
+			}
+		}),
+		stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+			code, halted := m.HaltCode()
+			require.True(t, halted, "expected machine to halt")
+			paths[code] = m.Path()
+			return nil
+		})))
+	require.NoError(t, err, "unexpected build error")
+	assert.Empty(t, m.Path(), "expected no path before running")
+	require.NoError(t, m.Run(), "unexpected run error")
+
+	f1, f2 := sites["f1"], sites["f2"]
+	assert.Equal(t, map[uint32]stackvm.ForkPath{
+		1: {{IP: f1, Taken: false}, {IP: f2, Taken: false}},
+		2: {{IP: f1, Taken: true}},
+		3: {{IP: f1, Taken: false}, {IP: f2, Taken: true}},
+	}, paths)
+}
+
+func TestReplay(t *testing.T) {
+	prog := MustAssemble(
+		".maxDepth", 3,
+
+		".data",
+		".out", "v:", 0,
+
+		".entry", "main:",
+		1, "push",
+		"loop:",
+		"dup", 6, "gt", ":done", "jnz",
+		":double", "fork",
+		3, "add", ":next", "jump",
+		"double:",
+		2, "mul",
+		"next:",
+		"dup", ":v", "storeTo",
+		":loop", "jump",
+		"done:",
+		"halt",
+	)
+
+	type result struct {
+		path stackvm.ForkPath
+		vals map[string][]uint32
+	}
+	var results []result
+	m, err := stackvm.New(prog, stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		if m.Err() != nil {
+			return nil
+		}
+		vals, err := m.NamedValues()
+		if err == nil {
+			results = append(results, result{m.Path(), vals})
+		}
+		return err
+	})))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	require.True(t, len(results) > 1, "expected several results")
+
+	for _, r := range results {
+		m, err := stackvm.Replay(prog, r.path)
+		require.NoError(t, err, "unexpected replay build error")
+		require.NoError(t, m.Run(), "unexpected replay error for %v", r.path)
+		assert.Equal(t, r.path, m.Path(), "expected same path after replay")
+		vals, err := m.NamedValues()
+		require.NoError(t, err, "unexpected values error")
+		assert.Equal(t, r.vals, vals, "expected same values after replay of %v", r.path)
+	}
+
+	path := results[0].path
+	path[0].IP++
+	m, err = stackvm.Replay(prog, path)
+	require.NoError(t, err, "unexpected replay build error")
+	err = m.Run()
+	require.Error(t, err, "expected replay to diverge")
+	assert.Contains(t, err.Error(), "replay diverged from fork path")
+}
@@ -0,0 +1,118 @@
+package stackvm_test
+
+import (
+	"testing"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func outputAddr(t *testing.T, m *stackvm.Mach) uint32 {
+	outputs, err := m.Outputs()
+	require.NoError(t, err, "unexpected outputs error")
+	require.Len(t, outputs, 1, "expected one output region")
+	return outputs[0].From
+}
+
+func TestMach_StepBack(t *testing.T) {
+	m, err := stackvm.New(MustAssemble(
+		".data",
+		".out", "v:", 0,
+		"w:", 0,
+		".entry", "main:",
+		3, "push", ":v", "storeTo",
+		7, "push", ":v", "storeTo",
+		2, "push", ":w", "storeTo",
+		42, "halt",
+	), stackvm.Record())
+	require.NoError(t, err, "unexpected build error")
+	v := outputAddr(t, m)
+	entry := m.IP()
+
+	for m.Err() == nil {
+		m.Step()
+	}
+	code, halted := m.HaltCode()
+	require.True(t, halted, "expected machine to halt")
+	require.Equal(t, uint32(42), code)
+	haltIP := m.IP()
+
+	require.NoError(t, m.StepBack(), "unexpected step back error")
+	assert.NoError(t, m.Err(), "expected machine to be live again")
+	_, halted = m.HaltCode()
+	assert.False(t, halted, "expected machine to not be halted")
+	assert.True(t, m.IP() < haltIP, "expected ip to rewind")
+
+	// find the store of the wrong value
+	require.NoError(t, m.RunBackToChange(v), "unexpected run back error")
+	val, _ := m.Fetch(v)
+	assert.Equal(t, uint32(3), val, "expected prior value")
+	storeIP := m.IP()
+	require.NoError(t, m.Step(), "unexpected step error")
+	val, _ = m.Fetch(v)
+	assert.Equal(t, uint32(7), val, "expected the store to be re-executed")
+
+	require.NoError(t, m.RunBackTo(storeIP), "unexpected run back error")
+	ps, _, err := m.Stacks()
+	require.NoError(t, err, "unexpected stacks error")
+	assert.Equal(t, []uint32{7}, ps, "expected the stored operand to be restored")
+
+	require.NoError(t, m.RunBackTo(entry), "unexpected run back error")
+	val, _ = m.Fetch(v)
+	assert.Equal(t, uint32(0), val, "expected initial value")
+	assert.Error(t, m.StepBack(), "expected no history before the entry")
+
+	// running forward again reproduces the same result
+	err = m.Run()
+	require.Error(t, err, "expected halt error")
+	assert.Contains(t, err.Error(), "HALT(42)")
+}
+
+func TestMach_StepBack_copies(t *testing.T) {
+	type result struct {
+		code, final, prior uint32
+		err                error
+		depth, startDepth  int
+	}
+	var (
+		v       uint32
+		results []result
+	)
+	prog := MustAssemble(
+		".data",
+		".out", "v:", 0,
+		".entry", "main:",
+		":other", "fork",
+		5, "push", ":v", "storeTo",
+		1, "halt",
+		"other:", 6, "push", ":v", "storeTo",
+		2, "halt",
+	)
+	m, err := stackvm.New(prog, stackvm.Record(),
+		stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+			var r result
+			r.code, _ = m.HaltCode()
+			r.final, _ = m.Fetch(v)
+			r.err = m.RunBackToChange(v)
+			r.prior, _ = m.Fetch(v)
+			r.depth = m.Depth()
+			for m.StepBack() == nil {
+			}
+			r.startDepth = m.Depth()
+			results = append(results, r)
+			return nil
+		})))
+	require.NoError(t, err, "unexpected build error")
+	v = outputAddr(t, m)
+	require.NoError(t, m.Run(), "unexpected run error")
+	assert.Equal(t, []result{
+		{1, 5, 0, nil, 0, 0},
+		{2, 6, 0, nil, 1, 0}, // stepping back over the fork undoes the copy's depth
+	}, results)
+
+	m, err = stackvm.New(prog)
+	require.NoError(t, err, "unexpected build error")
+	assert.Error(t, m.StepBack(), "expected error when not recording")
+}
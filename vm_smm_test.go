@@ -54,67 +54,81 @@ var smmTest = TestCase{
 
 		".data",
 		".out", "values:", ".alloc", 8,
-		// 0 1 2 3 4 5 6 7
-		// d e y n r o s m
+		".const", "D", 0,
+		".const", "E", 1,
+		".const", "Y", 2,
+		".const", "N", 3,
+		".const", "R", 4,
+		".const", "O", 5,
+		".const", "S", 6,
+		".const", "M", 7,
+
+		".macro", "choose", "$X", // : -- $X :
+		":values+4*$X", "push", ":choose", "call",
+		".endm",
+
+		".macro", "fetch", "$X", // : -- $X :
+		":values+4*$X", "fetch",
+		".endm",
+
+		".macro", "set", "$X", // $X : -- :
+		"dup", ":values+4*$X", "storeTo", ":markUsed", "call",
+		".endm",
 
 		".entry",
 
 		//// d + e = y  (mod 10)
 		".spanOpen", "col_dey:", // :
-		4 * 0, ":values", "push", ":choose", "call", // $d :
-		4 * 1, ":values", "push", ":choose", "call", // $d $e :
+		".choose", "D", // $d :
+		".choose", "E", // $d $e :
 		".spanOpen", "compute_y_de:", // $d $e :
 		"add", "dup", // $d+e $d+e :
 		10, "mod", // $d+e ($d+e)%10 :
-		"dup", 4 * 2, ":values", "storeTo", // $d+e $y :   -- $y=($d+e)%10
-		":markUsed", "call", // $d+e :
+		".set", "Y", // $d+e :   -- $y=($d+e)%10
 		".spanClose", ".spanClose", 10, "div", // carry :
 
 		//// carry + n + r = e  (mod 10)
 		".spanOpen", "col_nre:", // carry :
-		"dup",                     // carry carry :
-		4 * 1, ":values", "fetch", // carry carry $e :
-		"swap",                                      // carry $e carry :
-		4 * 3, ":values", "push", ":choose", "call", // carry $e carry $n :
+		"dup",         // carry carry :
+		".fetch", "E", // carry carry $e :
+		"swap",         // carry $e carry :
+		".choose", "N", // carry $e carry $n :
 		".spanOpen", "compute_r_en:", // carry $e carry $n :
 		"add", "sub", 10, "mod", // carry ($e-(carry+$n))%10 :
-		"dup", 4 * 4, ":values", "storeTo", // carry $r :   -- $r=($e-(carry+$n))%10
-		":markUsed", "call", // carry :
-		4 * 3, ":values", "fetch", // carry $n :
-		4 * 4, ":values", "fetch", // carry $n $r :
+		".set", "R", // carry :   -- $r=($e-(carry+$n))%10
+		".fetch", "N", // carry $n :
+		".fetch", "R", // carry $n $r :
 		"add", "add", // carry+$n+$r :
 		".spanClose", ".spanClose", 10, "div", // carry :
 
 		//// carry + e + o = n  (mod 10)
 		".spanOpen", "col_eon:", // carry :
-		"dup",                     // carry carry :
-		4 * 1, ":values", "fetch", // carry carry $e :
-		"add",                     // carry carry+$e :
-		4 * 3, ":values", "fetch", // carry carry+$e $n :
+		"dup",         // carry carry :
+		".fetch", "E", // carry carry $e :
+		"add",         // carry carry+$e :
+		".fetch", "N", // carry carry+$e $n :
 		".spanOpen", "compute_o_en:", // carry carry+$e $n :
 		"swap", "sub", // carry $n-(carry+$e) :
 		10, "mod", // carry ($n-(carry+$e))%10 :
-		"dup", 4 * 5, ":values", "storeTo", // carry $o :   -- $o=($n-(carry+$e))%10
-		":markUsed", "call", // carry :
-		4 * 1, ":values", "fetch", // carry $e :
-		4 * 5, ":values", "fetch", // carry $e $o :
+		".set", "O", // carry :   -- $o=($n-(carry+$e))%10
+		".fetch", "E", // carry $e :
+		".fetch", "O", // carry $e $o :
 		"add", "add", // carry+$e+$o :
 		".spanClose", ".spanClose", 10, "div", // carry :
 
 		//// carry + s + m = o  (mod 10)
 		".spanOpen", "col_smo:", // carry :
-		"dup",                                       // carry carry :
-		4 * 6, ":values", "push", ":choose", "call", // carry carry $s :
-		"add",                     // carry carry+$s :
-		4 * 5, ":values", "fetch", // carry carry+$s $o :
+		"dup",          // carry carry :
+		".choose", "S", // carry carry $s :
+		"add",         // carry carry+$s :
+		".fetch", "O", // carry carry+$s $o :
 		".spanOpen", "compute_m_so:", // carry carry+$s $o :
 		"swap", "sub", // carry $o-(carry+$s) :
 		10, "mod", // carry ($o-(carry+$s))%10 :
-		"dup", 4 * 7, ":values", "storeTo", // carry $m :   -- $m=($o-(carry+$s))%10
-		":markUsed", "call", // carry :
-		4 * 6, ":values", "fetch", // carry $s :
+		".set", "M", // carry :   -- $m=($o-(carry+$s))%10
+		".fetch", "S", // carry $s :
 		"dup", 1, "hz", // carry $s :   -- guard $s != 0
-		4 * 7, ":values", "fetch", // carry $s $m :
+		".fetch", "M", // carry $s $m :
 		"dup", 1, "hz", // carry $s $m :   -- guard $m != 0
 		"add", "add", // carry+$s+$m :
 		".spanClose", ".spanClose", 10, "div", // carry :
@@ -122,7 +136,7 @@ var smmTest = TestCase{
 		//// carry = m  (mod 10)
 		".spanOpen", "col___m:",
 		".spanOpen", "check_m:",
-		4 * 7, ":values", "fetch", // carry $m
+		".fetch", "M", // carry $m
 		"eq",
 		".spanClose", ".spanClose", 3, "hz",
 
@@ -0,0 +1,77 @@
+package stackvm_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+type nopTracer struct{}
+
+func (nopTracer) Context(m *stackvm.Mach, key string) (interface{}, bool) { return nil, false }
+func (nopTracer) Begin(m *stackvm.Mach)                                   {}
+func (nopTracer) Before(m *stackvm.Mach, ip uint32, op stackvm.Op)        {}
+func (nopTracer) After(m *stackvm.Mach, ip uint32, op stackvm.Op)         {}
+func (nopTracer) Queue(m, n *stackvm.Mach)                                {}
+func (nopTracer) End(m *stackvm.Mach)                                     {}
+func (nopTracer) Handle(m *stackvm.Mach, err error)                       {}
+
+// TestMach_threaded checks that running threaded, fused, ops has the same
+// outcome as tracing them one at a time, even when stopped part way through
+// by the op limit.
+func TestMach_threaded(t *testing.T) {
+	for maxOps := 1; maxOps <= 60; maxOps++ {
+		t.Run(fmt.Sprintf("maxOps=%d", maxOps), func(t *testing.T) {
+			prog := MustAssemble(
+				".maxOps", maxOps,
+				".data",
+				".out", "sum:", 0,
+				".entry", "main:",
+				0, "push", // i :
+				"loop:",                       // i :
+				"dup", ":sum", "fetch", "add", // i sum+i :
+				":sum", "storeTo", // i :
+				1, "add", // i+1 :
+				"dup", 5, "lt", ":loop", "jnz", // i :
+				0, "halt",
+			)
+
+			type outcome struct {
+				ip   uint32
+				psp  uint32
+				err  string
+				vals map[string][]uint32
+			}
+			run := func(trace bool) (out outcome) {
+				m, err := stackvm.New(prog)
+				require.NoError(t, err, "unexpected build error")
+				if trace {
+					err = m.Trace(nopTracer{})
+				} else {
+					err = m.Run()
+				}
+				out.ip, out.psp = m.IP(), m.PSP()
+				if err != nil {
+					out.err = err.Error()
+				} else {
+					out.vals, err = m.NamedValues()
+					require.NoError(t, err, "unexpected values error")
+				}
+				return out
+			}
+
+			traced := run(true)
+			assert.Equal(t, traced, run(false), "expected the same outcome as when traced")
+			assert.Equal(t, maxOps < 42, traced.err != "", "expected an error only under 42 ops")
+			if maxOps >= 42 {
+				assert.Equal(t, "", traced.err, "expected no error")
+				assert.Equal(t, map[string][]uint32{"sum": {0 + 1 + 2 + 3 + 4}}, traced.vals)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package stackvm_test
+
+import (
+	"testing"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMach_yield_generator(t *testing.T) {
+	// Generate the fibonacci sequence for as long as the host replies with a
+	// non-zero value.
+	m, err := stackvm.New(MustAssemble(
+		0, "push", 1, "push", // a b :
+		"loop:",
+		"dup", "yield", // a b : -- host pushes a continue flag
+		":done", "jz", // a b :
+		"swap", 2, "dup", "add", // b a+b :
+		":loop", "jump",
+		"done:",
+		"halt",
+	))
+	require.NoError(t, err, "unexpected build error")
+
+	var fib []uint32
+	for {
+		require.NoError(t, m.Run(), "unexpected run error")
+		vals, ok := m.Yielded()
+		if !ok {
+			break
+		}
+		fib = append(fib, vals...)
+		more := uint32(0)
+		if len(fib) < 8 {
+			more = 1
+		}
+		require.NoError(t, m.Push(more), "unexpected push error")
+	}
+	assert.Equal(t, []uint32{1, 1, 2, 3, 5, 8, 13, 21}, fib)
+	code, halted := m.HaltCode()
+	assert.True(t, halted, "expected machine to halt")
+	assert.Equal(t, uint32(0), code, "expected normal halt")
+}
+
+func TestMach_yieldr(t *testing.T) {
+	m, err := stackvm.New(MustAssemble(
+		".entry", "main:",
+		":buf", "push", ":end", "push", "yieldr",
+		7, "yield",
+		"halt",
+
+		".data",
+		"buf:", 3, 1, 4,
+		"end:", 0,
+	))
+	require.NoError(t, err, "unexpected build error")
+
+	var got [][]uint32
+	for {
+		require.NoError(t, m.Step(), "unexpected step error")
+		if vals, ok := m.Yielded(); ok {
+			got = append(got, vals)
+		} else if _, halted := m.HaltCode(); halted {
+			break
+		}
+	}
+	assert.Equal(t, [][]uint32{{3, 1, 4}, {7}}, got)
+}
+
+func TestMach_yield_fork(t *testing.T) {
+	// A queued copy yields, and forks again once resumed.
+	var codes []uint32
+	m, err := stackvm.New(MustAssemble(
+		":child", "fork",
+		1, "halt",
+		"child:",
+		7, "yield",
+		":grand", "fork",
+		2, "halt",
+		"grand:",
+		3, "halt",
+	), stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		code, _ := m.HaltCode()
+		codes = append(codes, code)
+		return nil
+	})))
+	require.NoError(t, err, "unexpected build error")
+
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, ok := m.Yielded()
+	require.True(t, ok, "expected the copy to yield")
+	assert.Equal(t, []uint32{7}, vals)
+	assert.Equal(t, []uint32{1}, codes)
+
+	require.NoError(t, m.Run(), "unexpected run error")
+	_, yielded := m.Yielded()
+	assert.False(t, yielded, "expected no further yield")
+	assert.Equal(t, []uint32{1, 2, 3}, codes)
+}
+
+func TestMach_MemStore_code(t *testing.T) {
+	m, err := stackvm.New(MustAssemble(
+		".entry", "main:",
+		"loop:", 1, "push", "yield", // : -- host pushes a continue flag
+		":loop", "jnz",
+		"halt",
+	))
+	require.NoError(t, err, "unexpected build error")
+	loop := m.IP()
+
+	var got []uint32
+	for i := 0; ; i++ {
+		require.NoError(t, m.Run(), "unexpected run error")
+		vals, ok := m.Yielded()
+		if !ok {
+			break
+		}
+		got = append(got, vals...)
+		more := uint32(0)
+		if i == 0 {
+			// "1 push" has been decoded, and threaded, by now
+			m.MemStore(loop, []byte{0x89, 0x02}) // 9 push
+			more = 1
+		}
+		require.NoError(t, m.Push(more), "unexpected push error")
+	}
+	assert.Equal(t, []uint32{1, 9}, got, "expected the rewritten op to run")
+}
@@ -25,6 +25,9 @@ const (
 	TraceAfter
 	// TraceHandle corresponds to Tracer.Handle.
 	TraceHandle
+	// TraceWatch corresponds to a watched memory word changing; see
+	// tracer.Watch.
+	TraceWatch
 )
 
 // Test returns true if the current trace action is the received one.
@@ -44,6 +47,8 @@ func (ta TraceAction) String() string {
 		return "after"
 	case TraceHandle:
 		return "handle"
+	case TraceWatch:
+		return "watch"
 	default:
 		return fmt.Sprintf("InvalidTraceAction(%d)", int(ta))
 	}
@@ -67,6 +72,8 @@ func (ta *TraceAction) Set(s string) error {
 		*ta = TraceAfter
 	case "handle":
 		*ta = TraceHandle
+	case "watch":
+		*ta = TraceWatch
 	default:
 		return errInvalidTraceAction
 	}
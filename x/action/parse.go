@@ -28,9 +28,10 @@ var predicateParsePattern = regexp.MustCompile(stripSpace(`
 
 // ParsePredicate parses a string like "action", "action@ip", or
 // "action:op[,op[,...]" into a predicate. The action string may be any of
-// "begin", "end", "queue", "before", or "after" (corresponding to Tracer
-// methods). The ip string may either be a decimal number, or a "0x" prefixed
-// hex number. The op strings may be any valid operation names.
+// "begin", "end", "queue", "before", "after", or "handle" (corresponding to
+// Tracer methods), or "watch" (corresponding to tracer.Watch callbacks). The
+// ip string may either be a decimal number, or a "0x" prefixed hex number.
+// The op strings may be any valid operation names.
 func ParsePredicate(s string) (Predicate, error) {
 	if s == "" {
 		return Never, nil
@@ -0,0 +1,437 @@
+// Package aot compiles stackvm programs ahead of time, into Go source for a
+// package that runs them with the same results as the interpreter.
+//
+// The program is split into basic blocks, found by following its static
+// control flow from its entry; each block becomes a Go function, with inline
+// code for each of its ops, built from the primitives that stackvm.BlockMach
+// provides for compiled blocks (see stackvm.Block): forks and branches
+// enqueue copies of the machine directly, as the interpreter does. Code
+// reached only dynamically, e.g. by a jump to a popped offset, is left to the
+// interpreter, as are a few rarely used ops within blocks.
+package aot
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// Block is a basic block within a program: a run of ops entered only at its
+// start, that ends with an op that may transfer control, or before the start
+// of another block.
+type Block struct {
+	Addr uint32
+	Ops  []BlockOp
+}
+
+// BlockOp is an op within a Block, decoded from Site up to Next.
+type BlockOp struct {
+	Site, Next uint32
+	stackvm.Op
+}
+
+// successors returns the addresses that control may statically pass to,
+// after executing the op that ends a block.
+func successors(op stackvm.Op, next uint32) []uint32 {
+	var succ []uint32
+	switch op.Name() {
+	case "crash", "ret", "halt":
+	case "jump":
+	default:
+		succ = append(succ, next)
+	}
+	if op.Have {
+		switch {
+		case op.RelativeRef():
+			succ = append(succ, uint32(int32(next)+int32(op.Arg)))
+		case op.Name() == "call":
+			succ = append(succ, op.Arg)
+		}
+	}
+	return succ
+}
+
+// FindBlocks decodes the basic blocks of a program that are reachable from its
+// entry, sorted by address.
+func FindBlocks(prog []byte) ([]Block, error) {
+	m, err := stackvm.New(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	// find the leading address of every block
+	var (
+		leaders = map[uint32]bool{m.IP(): true}
+		seen    = make(map[uint32]bool)
+		pending = []uint32{m.IP()}
+	)
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for !seen[addr] {
+			seen[addr] = true
+			op, next, err := m.ReadOp(addr)
+			if err != nil {
+				break
+			}
+			if !op.EndsBlock() {
+				addr = next
+				continue
+			}
+			for _, succ := range successors(op, next) {
+				leaders[succ] = true
+				pending = append(pending, succ)
+			}
+			break
+		}
+	}
+
+	addrs := make([]uint32, 0, len(leaders))
+	for addr := range leaders {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	blocks := make([]Block, 0, len(addrs))
+	for _, addr := range addrs {
+		blk := Block{Addr: addr}
+		for {
+			op, next, err := m.ReadOp(addr)
+			if err != nil {
+				break
+			}
+			blk.Ops = append(blk.Ops, BlockOp{addr, next, op})
+			if op.EndsBlock() || leaders[next] {
+				break
+			}
+			addr = next
+		}
+		if len(blk.Ops) > 0 {
+			blocks = append(blocks, blk)
+		}
+	}
+	return blocks, nil
+}
+
+// Generate writes the source of a Go package, named pkg, that compiles the
+// program. The package declares:
+//
+//	var Program []byte                       // the program
+//	var Blocks map[uint32]stackvm.Block      // its compiled blocks
+//	func New(...stackvm.MachBuildOpt) (*stackvm.Mach, error)
+//
+// where New builds a machine for the program that runs its compiled blocks.
+func Generate(w io.Writer, pkg string, prog []byte) error {
+	blocks, err := FindBlocks(prog)
+	if err != nil {
+		return err
+	}
+	m, err := stackvm.New(prog)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by stackvm aot; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "// Package %s is a stackvm program, compiled ahead of time.\n", pkg)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import \"github.com/jcorbin/stackvm\"\n\n")
+
+	fmt.Fprintf(&buf, "// Program is the machine program that Blocks were compiled from.\n")
+	fmt.Fprintf(&buf, "var Program = []byte{")
+	for i, b := range prog {
+		if i%12 == 0 {
+			fmt.Fprintf(&buf, "\n")
+		}
+		fmt.Fprintf(&buf, "0x%02x, ", b)
+	}
+	fmt.Fprintf(&buf, "\n}\n\n")
+
+	fmt.Fprintf(&buf, "// Blocks are the compiled basic blocks of Program, by address.\n")
+	fmt.Fprintf(&buf, "var Blocks = map[uint32]stackvm.Block{\n")
+	for _, blk := range blocks {
+		fmt.Fprintf(&buf, "0x%04x: {N: %d, Run: block%04x},\n", blk.Addr, len(blk.Ops), blk.Addr)
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// New builds a machine for Program, which runs its compiled Blocks.\n")
+	fmt.Fprintf(&buf, "func New(opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {\n")
+	fmt.Fprintf(&buf, "return stackvm.New(Program, append(opts, stackvm.Compiled(Blocks))...)\n")
+	fmt.Fprintf(&buf, "}\n")
+
+	for _, blk := range blocks {
+		var body bytes.Buffer
+		for _, bop := range blk.Ops {
+			fmt.Fprintf(&body, "// @0x%04x %v\n", bop.Site, bop.Op)
+			fmt.Fprintf(&body, "m.Tick(0x%04x)\n", bop.Next)
+			body.WriteString(compileOp(bop, m.PBP(), m.CBP()))
+		}
+		fmt.Fprintf(&buf, "\nfunc block%04x(m stackvm.BlockMach) {\n", blk.Addr)
+		if bytes.Contains(body.Bytes(), []byte("*h")) {
+			fmt.Fprintf(&buf, "h := m.Head()\n")
+		}
+		body.WriteTo(&buf)
+		fmt.Fprintf(&buf, "}\n")
+	}
+
+	fmt.Fprintf(&buf, "%s", helpers)
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// helpers are declared by every generated package, for use by compiled ops.
+const helpers = `
+func b2u(b bool) uint32 {
+if b {
+return 1
+}
+return 0
+}
+
+func rem(a, b int32) int32 {
+x := a % b
+if x < 0 {
+x += b
+}
+return x
+}
+`
+
+// compileOp returns the Go statements that execute an op, other than its
+// Tick, using the primitives provided by stackvm.BlockMach; they refer to the
+// value atop the parameter stack as *h. They return from the block if the op
+// fails. Ops that would jump into the stack, which lies between pbp and cbp,
+// are left to stackvm.BlockMach.Exec, as are those rarely used, or whose
+// interpreted semantics are irregular.
+func compileOp(bop BlockOp, pbp, cbp uint32) string {
+	var (
+		name   = bop.Name()
+		arg    = bop.Arg
+		have   = bop.Have
+		off    = int32(arg)
+		fail   = func(cond string) string { return fmt.Sprintf("if %s {\nreturn\n}\n", cond) }
+		popAnd = func(stmts string) string {
+			return fmt.Sprintf("if b, ok := m.PopVal(); !ok {\nreturn\n} else {\n%s}\n", stmts)
+		}
+		popFail = func(cond string) string { return fail("b, ok := m.PopVal(); !ok || " + cond) }
+		repeat  = func(stmt string) string {
+			return fmt.Sprintf("for i := 0; i < %d; i++ {\n%s}\n", arg, stmt)
+		}
+	)
+	if bop.RelativeRef() && have {
+		if targ := uint32(int32(bop.Next) + off); targ >= pbp && targ <= cbp {
+			name = ""
+		}
+	}
+
+	binops := map[string]string{
+		"add": "*h += %s", "sub": "*h -= %s", "mul": "*h *= %s", "div": "*h /= %s",
+		"mod":    "*h = uint32(rem(int32(*h), %s))",
+		"lt":     "*h = b2u(*h < %s)",
+		"lte":    "*h = b2u(*h <= %s)",
+		"gt":     "*h = b2u(*h > %s)",
+		"eq":     "*h = b2u(*h == %s)",
+		"neq":    "*h = b2u(*h != %s)",
+		"bitand": "*h &= %s", "bitor": "*h |= %s", "bitxor": "*h ^= %s",
+		"shiftl": "*h <<= %s", "shiftr": "*h >>= %s",
+	}
+	if op, isBinop := binops[name]; isBinop {
+		if have {
+			if arg == 0 && (name == "div" || name == "mod") {
+				return exec(bop)
+			}
+			b := fmt.Sprint(arg)
+			if name == "mod" {
+				b = fmt.Sprint(int32(arg))
+			}
+			return fmt.Sprintf(""+op+"\n", b)
+		}
+		b := "b"
+		if name == "mod" {
+			b = "int32(b)"
+		}
+		return popAnd(fmt.Sprintf(op+"\n", b))
+	}
+
+	switch name {
+	case "nop":
+		return ""
+
+	case "push":
+		return fail(fmt.Sprintf("!m.PushVal(%#x)", arg))
+	case "pop":
+		drop := fail("_, ok := m.PopVal(); !ok")
+		if have && arg != 1 {
+			return repeat(drop)
+		}
+		return drop
+	case "dup":
+		if have && arg != 1 {
+			return fail(fmt.Sprintf("p, ok := m.ParamRef(%d); !ok || !m.PushVal(*p)", arg))
+		}
+		return fail("!m.PushVal(*h)")
+	case "swap":
+		i := uint32(2)
+		if have {
+			i = 1 + arg
+		}
+		return fmt.Sprintf("if p, ok := m.ParamRef(%d); !ok {\nreturn\n} else {\n"+
+			"*h, *p = *p, *h\n}\n", i)
+
+	case "fetch":
+		if have {
+			return fail(fmt.Sprintf("v, ok := m.FetchVal(%#x); !ok || !m.PushVal(v)", arg))
+		}
+		return popAnd(fail("v, ok := m.FetchVal(b); !ok || !m.PushVal(v)"))
+	case "store":
+		if have {
+			return popFail(fmt.Sprintf("!m.StoreVal(b, %#x)", arg))
+		}
+		return popAnd(fail("a, ok := m.PopVal(); !ok || !m.StoreVal(a, b)"))
+	case "storeTo":
+		if have {
+			return popFail(fmt.Sprintf("!m.StoreVal(%#x, b)", arg))
+		}
+		return popAnd(fail("v, ok := m.PopVal(); !ok || !m.StoreVal(b, v)"))
+
+	case "neg":
+		return "*h = -*h\n"
+	case "not":
+		return "*h = b2u(*h == 0)\n"
+	case "bitnot":
+		return "*h = ^*h\n"
+	case "gte":
+		if have {
+			return fmt.Sprintf("*h = b2u(*h >= %d)\n", arg)
+		}
+
+	case "bitset", "bitost":
+		set := "*p |= 1 << (n % 32)"
+		if name == "bitost" {
+			set = "*p &^= 1 << (n % 32)"
+		}
+		ref := "if p, ok := m.MemRef(b + n/32); !ok {\nreturn\n} else {\n" + set + "\n}\n"
+		if have {
+			ref = fmt.Sprintf("if p, ok := m.MemRef(%#x + n/32); !ok {\nreturn\n} else {\n", arg) + set + "\n}\n"
+			return "if n, ok := m.PopVal(); !ok {\nreturn\n} else " + ref
+		}
+		return "if b, ok := m.PopVal(); !ok {\nreturn\n} else " +
+			"if n, ok := m.PopVal(); !ok {\nreturn\n} else " + ref
+	case "bitseta", "bitosta":
+		addr := "b"
+		if have {
+			addr = fmt.Sprintf("%#x", arg)
+		}
+		test := "if p, ok := m.MemRef(" + addr + " + *h/32); !ok {\nreturn\n} else " +
+			"if bit := uint32(1) << (*h % 32); *p&bit != 0 {\n"
+		if name == "bitseta" {
+			test += "*h = 0\n} else {\n*p |= bit\n*h = 1\n}\n"
+		} else {
+			test += "*p &^= bit\n*h = 1\n} else {\n*h = 0\n}\n"
+		}
+		if have {
+			return test
+		}
+		return "if b, ok := m.PopVal(); !ok {\nreturn\n} else " + test
+
+	case "mark":
+		return fail(fmt.Sprintf("!m.CPushVal(%#x)", bop.Next))
+	case "cpush":
+		if have {
+			return fail(fmt.Sprintf("!m.CPushVal(%#x)", arg))
+		}
+	case "cpop":
+		drop := fail("_, ok := m.CPopVal(); !ok")
+		if have {
+			return repeat(drop)
+		}
+		return drop
+	case "p2c":
+		move := fail("v, ok := m.PopVal(); !ok || !m.CPushVal(v)")
+		if have {
+			return repeat(move)
+		}
+		return move
+	case "c2p":
+		move := fail("v, ok := m.CPopVal(); !ok || !m.PushVal(v)")
+		if have {
+			return repeat(move)
+		}
+		return move
+
+	case "jump":
+		if have {
+			return fail(fmt.Sprintf("!m.Jump(%d)", off))
+		}
+		return popFail("!m.Jump(int32(b))")
+	case "jnz", "jz":
+		jump := "!m.CJump()"
+		if have {
+			jump = fmt.Sprintf("!m.Jump(%d)", off)
+		}
+		return popFail(condOf(name, "b") + " && " + jump)
+	case "call":
+		if have {
+			return fail(fmt.Sprintf("!m.Call(%#x)", arg))
+		}
+		return popFail("!m.Call(b)")
+	case "ret":
+		return fail("!m.Ret()")
+
+	case "fork", "branch":
+		enqueue := "Fork"
+		if name == "branch" {
+			enqueue = "Branch"
+		}
+		if have {
+			return fail(fmt.Sprintf("!m.%s(%#x, %d)", enqueue, bop.Site, off))
+		}
+		return popFail(fmt.Sprintf("!m.%s(%#x, int32(b))", enqueue, bop.Site))
+	case "fnz", "fz", "bnz", "bz":
+		if name == "bnz" && !have {
+			break // its popped value is ignored when interpreted
+		}
+		enqueue := fmt.Sprintf("m.CFork(%#x)", bop.Site)
+		switch {
+		case name[0] == 'f' && have:
+			enqueue = fmt.Sprintf("m.Fork(%#x, %d)", bop.Site, off)
+		case name[0] == 'b' && have:
+			enqueue = fmt.Sprintf("m.Branch(%#x, %d)", bop.Site, off)
+		case name[0] == 'b':
+			enqueue = fmt.Sprintf("m.CBranch(%#x)", bop.Site)
+		}
+		return popFail(condOf(name, "b") + " && !" + enqueue)
+
+	case "halt":
+		return fmt.Sprintf("m.Halt(%d)\n", arg)
+	case "hnz", "hz":
+		return popAnd(fmt.Sprintf("if %s {\nm.Halt(%d)\n}\n", condOf(name, "b"), arg))
+	}
+
+	return exec(bop)
+}
+
+// condOf returns the condition under which a conditional op, ending in "nz"
+// or "z", takes effect, given its popped value.
+func condOf(name, val string) string {
+	if strings.HasSuffix(name, "nz") {
+		return val + " != 0"
+	}
+	return val + " == 0"
+}
+
+// exec returns a statement that executes an op by stackvm.BlockMach.Exec.
+func exec(bop BlockOp) string {
+	return fmt.Sprintf("if !m.Exec(%#x, stackvm.Op{Code: %#02x, Arg: %#x, Have: %v}) {\nreturn\n}\n",
+		bop.Site, bop.Code, bop.Arg, bop.Have)
+}
@@ -0,0 +1,332 @@
+package aot_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/aot"
+	"github.com/jcorbin/stackvm/x/aot/internal/collatz"
+	"github.com/jcorbin/stackvm/x/aot/internal/explore"
+	"github.com/jcorbin/stackvm/x/aot/internal/ops"
+	"github.com/jcorbin/stackvm/x/aot/internal/smm"
+)
+
+var updateFlag = flag.Bool("aot.update", false, "rewrite the compiled test programs under internal/")
+
+// testProgs are compiled into the packages under internal/, whose Program
+// must stay the same as that assembled here.
+var testProgs = []struct {
+	pkg  string
+	prog []byte
+	new  func(...stackvm.MachBuildOpt) (*stackvm.Mach, error)
+	opts [][]stackvm.MachBuildOpt
+}{
+	{"collatz", MustAssemble(collatzSrc...), collatz.New, [][]stackvm.MachBuildOpt{
+		{stackvm.NamedInput("N", []uint32{1})},
+		{stackvm.NamedInput("N", []uint32{6})},
+		{stackvm.NamedInput("N", []uint32{7})}, // stopped by .maxOps
+		{stackvm.NamedInput("N", []uint32{27})},
+	}},
+	{"explore", MustAssemble(exploreSrc...), explore.New, [][]stackvm.MachBuildOpt{nil}},
+	{"smm", MustAssemble(smmSrc...), smm.New, [][]stackvm.MachBuildOpt{nil}},
+	{"ops", MustAssemble(opsSrc...), ops.New, [][]stackvm.MachBuildOpt{nil}},
+}
+
+func TestGenerate(t *testing.T) {
+	for _, tp := range testProgs {
+		t.Run(tp.pkg, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, aot.Generate(&buf, tp.pkg, tp.prog), "unexpected generate error")
+			path := filepath.Join("internal", tp.pkg, tp.pkg+".go")
+			if *updateFlag {
+				require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644), "unexpected write error")
+				return
+			}
+			src, err := ioutil.ReadFile(path)
+			require.NoError(t, err, "unexpected read error")
+			assert.Equal(t, buf.String(), string(src), "expected %s to be up to date; run go test -aot.update", path)
+		})
+	}
+}
+
+type outcome struct {
+	halt   uint32
+	err    string
+	values map[string][]uint32
+}
+
+// runAll runs a machine, collecting the outcome of it and all of its copies.
+func runAll(
+	t *testing.T,
+	new func(...stackvm.MachBuildOpt) (*stackvm.Mach, error),
+	opts ...stackvm.MachBuildOpt,
+) (outs []outcome) {
+	m, err := new(append(opts, stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+		var out outcome
+		var halted bool
+		out.halt, halted = m.HaltCode()
+		if err := m.Err(); err != nil {
+			out.err = err.Error()
+		}
+		if halted && out.halt == 0 {
+			vals, err := m.NamedValues()
+			if err != nil {
+				return err
+			}
+			out.values = vals
+		}
+		outs = append(outs, out)
+		return nil
+	})))...)
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	return outs
+}
+
+// TestCompiled checks that compiled programs have the same outcomes as when
+// interpreted, including when stopped part way through by the op limit.
+func TestCompiled(t *testing.T) {
+	for _, tp := range testProgs {
+		interp := func(opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {
+			return stackvm.New(tp.prog, opts...)
+		}
+		for i, opts := range tp.opts {
+			t.Run(fmt.Sprintf("%s[%d]", tp.pkg, i), func(t *testing.T) {
+				outs := runAll(t, interp, opts...)
+				require.NotEmpty(t, outs, "expected some outcomes")
+				assert.Equal(t, outs, runAll(t, tp.new, opts...), "expected the same outcomes when compiled")
+			})
+		}
+	}
+}
+
+func BenchmarkCompiled(b *testing.B) {
+	for _, tp := range testProgs {
+		for _, mode := range []struct {
+			name string
+			new  func(...stackvm.MachBuildOpt) (*stackvm.Mach, error)
+		}{
+			{"interpreted", func(opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {
+				return stackvm.New(tp.prog, opts...)
+			}},
+			{"compiled", tp.new},
+		} {
+			b.Run(tp.pkg+"/"+mode.name, func(b *testing.B) {
+				opts := append(tp.opts[0], stackvm.Handler(stackvm.MachHandlerFunc(func(*stackvm.Mach) error {
+					return nil
+				})))
+				for i := 0; i < b.N; i++ {
+					m, err := mode.new(opts...)
+					if err == nil {
+						err = m.Run()
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+var collatzSrc = []interface{}{
+	".maxOps", 200,
+	".data",
+	".in", "N:", 0,
+	".out", "seq:", ".alloc", 20,
+
+	".entry", "main:",
+	":N", "fetch", "dup", // v v :
+	":seq", "push", // v v i :
+	"dup", 4, "add", "p2c", // v v i : i=i+4
+	"storeTo", // v : i
+
+	"loop:",         // v : i
+	"dup", 2, "mod", // v v%2 : ...
+	":odd", "jnz",
+
+	"even:",
+	2, "div", // v/2 : ...
+	":next", "jump",
+
+	"odd:",
+	3, "mul", 1, "add", // 3*v+1 : ...
+
+	"next:",
+	"dup", "c2p", // v v i :
+	"dup", 4, "add", "p2c", // v v i : i=i+4
+	"storeTo",      // v : i
+	"dup", 1, "eq", // v v==1 : i
+	":loop", "jz", // v : i
+
+	"pop", "cpop",
+	0, "halt",
+}
+
+var exploreSrc = []interface{}{
+	".data",
+	".out", "seq:", ".alloc", 6,
+
+	".entry", "main:",
+	6, "push", // d :
+	":seq", "push", // d i :
+	":seq", "push", // d i b :
+	3, "p2c", // : b i d
+	1, "push", // v=1 : b i d
+
+	"round:", // v : b i d
+
+	"dup", 1, "sub", 3, "mod", // v (v-1)%3 : b i d
+	":third", "fz", // v : b i d
+	"double:", 2, "mul", // v=2*v : b i d
+	":next", "jump", // ...
+	"third:", 1, "sub", 3, "div", // v=(v-1)/3 : b i d
+
+	"next:",        // v : b i d
+	"dup", 1, "hz", // v : b i d
+
+	"dup",    // v v : b i d
+	2, "c2p", // v v d i : b
+	"dup", 4, "add", "p2c", // v v d i : b i+=4
+	"swap",    // v v i d : b i
+	"p2c",     // v v i : b i d
+	"storeTo", // v : b i d
+
+	"c2p", 1, "sub", // v d-- : b i
+	"dup", "p2c", 0, "gt", // v d>0 : b i d
+	":round", "jnz", // v : b i d
+
+	"pop", "cpop", "cpop", "cpop", 0, "halt",
+}
+
+var smmSrc = []interface{}{
+	//     s e n d
+	// +   m o r e
+	// -----------
+	//   m o n e y
+
+	".data",
+	".out", "used:", 0,
+	".out", "values:", ".alloc", 8,
+	".const", "D", 0,
+	".const", "E", 1,
+	".const", "Y", 2,
+	".const", "N", 3,
+	".const", "R", 4,
+	".const", "O", 5,
+	".const", "S", 6,
+	".const", "M", 7,
+
+	".macro", "choose", "$X", // : -- $X :
+	":values+4*$X", "push", ":choose", "call",
+	".endm",
+
+	".macro", "fetch", "$X", // : -- $X :
+	":values+4*$X", "fetch",
+	".endm",
+
+	".macro", "set", "$X", // $X : -- :
+	"dup", ":values+4*$X", "storeTo", ":markUsed", "call",
+	".endm",
+
+	".entry", "main:",
+
+	// d + e = y  (mod 10)
+	".choose", "D", ".choose", "E", // $d $e :
+	"add", "dup", 10, "mod", ".set", "Y", // $d+e :
+	10, "div", // carry :
+
+	// carry + n + r = e  (mod 10)
+	"dup", ".fetch", "E", "swap", ".choose", "N", // carry $e carry $n :
+	"add", "sub", 10, "mod", ".set", "R", // carry :
+	".fetch", "N", ".fetch", "R", "add", "add", // carry+$n+$r :
+	10, "div", // carry :
+
+	// carry + e + o = n  (mod 10)
+	"dup", ".fetch", "E", "add", ".fetch", "N", // carry carry+$e $n :
+	"swap", "sub", 10, "mod", ".set", "O", // carry :
+	".fetch", "E", ".fetch", "O", "add", "add", // carry+$e+$o :
+	10, "div", // carry :
+
+	// carry + s + m = o  (mod 10)
+	"dup", ".choose", "S", "add", ".fetch", "O", // carry carry+$s $o :
+	"swap", "sub", 10, "mod", ".set", "M", // carry :
+	".fetch", "S", "dup", 1, "hz", // carry $s :
+	".fetch", "M", "dup", 1, "hz", // carry $s $m :
+	"add", "add", 10, "div", // carry :
+
+	// carry = m  (mod 10)
+	".fetch", "M", "eq", 3, "hz",
+	0, "halt",
+
+	"choose:",                        // &$X : retIp
+	0, "push", ":chooseLoop", "jump", // &$X i=0 : retIp
+	"chooseNext:", 1, "add", // &$X i++ : retIp
+	"chooseLoop:",                        // &$X i : retIp
+	"dup", 9, "lt", ":chooseNext", "fnz", // &$X i : retIp
+	"dup", 2, "swap", "storeTo", // $X=i : retIp
+	"dup", // $X $X : retIP
+
+	"markUsed:",        // $X : retIp
+	":used", "bitseta", // !used[$x] : retIp
+	2, "hz", // : retIp
+	"ret", // :
+}
+
+// opsSrc exercises the compiled forms of ops not used by the other programs.
+var opsSrc = []interface{}{
+	".data",
+	".out", "res:", ".alloc", 6,
+	"bits:", 0, 0,
+
+	".entry", "main:",
+	1, "push", 2, "push", 3, "push", // 1 2 3 :
+	2, "swap", // 3 2 1 :
+	3, "dup", // 3 2 1 3 :
+	"swap",   // 3 2 3 1 :
+	"sub",    // 3 2 2 :
+	2, "pop", // 3 :
+	7, "push", "mod", // 3 :
+	"neg", 5, "shiftr", 3, "bitxor", // v=-3>>5^3 :
+	"dup", 100, "gte", "add", // v+(v>=100) :
+	":res", "storeTo", // : res[0]
+
+	40, "push", ":bits", "push", "bitset", // : bits[1] |= 1<<8
+	3, "push", ":bits", "bitset", // : bits[0] |= 1<<3
+	33, "push", ":bits", "bitost", // : bits[1] &^= 1<<1
+	3, "push", ":bits", "bitseta", // 0 :
+	3, "push", ":bits", "push", "bitosta", // 0 1 :
+	"add", ":res+4", "storeTo", // : res[1]
+
+	":res+8", "push", 6, "push", 7, "push", "mul", "store", // : res[2]
+	4, "cpush", 5, "cpush", "mark", 2, "cpop", // : 4
+	":res+12", "push", "c2p", "swap", "storeTo", // : res[3]
+	1, "push", 2, "push", 2, "p2c", 2, "c2p", "sub", // 1-2 :
+	":res+20", "storeTo", // : res[5]
+
+	":sq", "push", "call", // : res[4]
+	0, "push", ":skip", "jnz", // :
+	0, "push", ":done", "bz", // a copy continues here, to halt 4
+	4, "halt",
+
+	"skip:", 9, "halt",
+
+	"done:",
+	":res+16", "fetch", 1, "hz",
+	":last", "branch", // a copy halts 2, while this halts 0, with res
+	2, "halt",
+	"last:", 0, "halt",
+
+	"sq:",                                         // : ret
+	5, "push", "dup", "mul", ":res+16", "storeTo", // : ret
+	"ret",
+}
@@ -0,0 +1,203 @@
+// Code generated by stackvm aot; DO NOT EDIT.
+
+// Package collatz is a stackvm program, compiled ahead of time.
+package collatz
+
+import "github.com/jcorbin/stackvm"
+
+// Program is the machine program that Blocks were compiled from.
+var Program = []byte{
+	0x7f, 0x87, 0x09, 0x40, 0x01, 0x4e, 0x49, 0x03, 0x73, 0x65, 0x71, 0xa0,
+	0x01, 0x04, 0x6d, 0x61, 0x69, 0x6e, 0xaa, 0x01, 0x04, 0x6c, 0x6f, 0x6f,
+	0x70, 0xaf, 0x01, 0x04, 0x65, 0x76, 0x65, 0x6e, 0xb3, 0x01, 0x03, 0x6f,
+	0x64, 0x64, 0xb7, 0x01, 0x04, 0x6e, 0x65, 0x78, 0x74, 0xc0, 0x01, 0x81,
+	0xc8, 0x03, 0xc0, 0x06, 0xc4, 0x06, 0xc4, 0x08, 0xc9, 0x07, 0x81, 0x99,
+	0x07, 0x81, 0x99, 0x08, 0x81, 0xa0, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x00, 0x00, 0x4e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x03, 0x00, 0x00, 0x00, 0x73, 0x65, 0x71, 0xc0, 0x08, 0x04, 0xc9,
+	0x02, 0x04, 0x84, 0x10, 0x2a, 0x0a, 0x04, 0x82, 0x14, 0x84, 0x31, 0x82,
+	0x13, 0x84, 0x30, 0x83, 0x12, 0x81, 0x10, 0x04, 0x2b, 0x04, 0x84, 0x10,
+	0x2a, 0x0a, 0x04, 0x81, 0x1c, 0x8f, 0xff, 0xff, 0xff, 0xe3, 0x32, 0x03,
+	0x29, 0x80, 0x7f,
+}
+
+// Blocks are the compiled basic blocks of Program, by address.
+var Blocks = map[uint32]stackvm.Block{
+	0x00a0: {N: 7, Run: block00a0},
+	0x00aa: {N: 3, Run: block00aa},
+	0x00af: {N: 2, Run: block00af},
+	0x00b3: {N: 2, Run: block00b3},
+	0x00b7: {N: 9, Run: block00b7},
+	0x00c7: {N: 3, Run: block00c7},
+}
+
+// New builds a machine for Program, which runs its compiled Blocks.
+func New(opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {
+	return stackvm.New(Program, append(opts, stackvm.Compiled(Blocks))...)
+}
+
+func block00a0(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x00a0 @0x0040 fetch
+	m.Tick(0x00a2)
+	if v, ok := m.FetchVal(0x40); !ok || !m.PushVal(v) {
+		return
+	}
+	// @0x00a2 dup
+	m.Tick(0x00a3)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00a3 73 push
+	m.Tick(0x00a5)
+	if !m.PushVal(0x49) {
+		return
+	}
+	// @0x00a5 dup
+	m.Tick(0x00a6)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00a6 4 add
+	m.Tick(0x00a8)
+	*h += 4
+	// @0x00a8 p2c
+	m.Tick(0x00a9)
+	if v, ok := m.PopVal(); !ok || !m.CPushVal(v) {
+		return
+	}
+	// @0x00a9 storeTo
+	m.Tick(0x00aa)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		if v, ok := m.PopVal(); !ok || !m.StoreVal(b, v) {
+			return
+		}
+	}
+}
+
+func block00aa(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x00aa dup
+	m.Tick(0x00ab)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00ab 2 mod
+	m.Tick(0x00ad)
+	*h = uint32(rem(int32(*h), 2))
+	// @0x00ad +0x0004 jnz
+	m.Tick(0x00af)
+	if b, ok := m.PopVal(); !ok || b != 0 && !m.Jump(4) {
+		return
+	}
+}
+
+func block00af(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x00af 2 div
+	m.Tick(0x00b1)
+	*h /= 2
+	// @0x00b1 +0x0004 jump
+	m.Tick(0x00b3)
+	if !m.Jump(4) {
+		return
+	}
+}
+
+func block00b3(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x00b3 3 mul
+	m.Tick(0x00b5)
+	*h *= 3
+	// @0x00b5 1 add
+	m.Tick(0x00b7)
+	*h += 1
+}
+
+func block00b7(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x00b7 dup
+	m.Tick(0x00b8)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00b8 c2p
+	m.Tick(0x00b9)
+	if v, ok := m.CPopVal(); !ok || !m.PushVal(v) {
+		return
+	}
+	// @0x00b9 dup
+	m.Tick(0x00ba)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00ba 4 add
+	m.Tick(0x00bc)
+	*h += 4
+	// @0x00bc p2c
+	m.Tick(0x00bd)
+	if v, ok := m.PopVal(); !ok || !m.CPushVal(v) {
+		return
+	}
+	// @0x00bd storeTo
+	m.Tick(0x00be)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		if v, ok := m.PopVal(); !ok || !m.StoreVal(b, v) {
+			return
+		}
+	}
+	// @0x00be dup
+	m.Tick(0x00bf)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x00bf 1 eq
+	m.Tick(0x00c1)
+	*h = b2u(*h == 1)
+	// @0x00c1 -0x001d jz
+	m.Tick(0x00c7)
+	if b, ok := m.PopVal(); !ok || b == 0 && !m.Jump(-29) {
+		return
+	}
+}
+
+func block00c7(m stackvm.BlockMach) {
+	// @0x00c7 pop
+	m.Tick(0x00c8)
+	if _, ok := m.PopVal(); !ok {
+		return
+	}
+	// @0x00c8 cpop
+	m.Tick(0x00c9)
+	if _, ok := m.CPopVal(); !ok {
+		return
+	}
+	// @0x00c9 0 halt
+	m.Tick(0x00cb)
+	m.Halt(0)
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func rem(a, b int32) int32 {
+	x := a % b
+	if x < 0 {
+		x += b
+	}
+	return x
+}
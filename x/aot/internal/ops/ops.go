@@ -0,0 +1,454 @@
+// Code generated by stackvm aot; DO NOT EDIT.
+
+// Package ops is a stackvm program, compiled ahead of time.
+package ops
+
+import "github.com/jcorbin/stackvm"
+
+// Program is the machine program that Blocks were compiled from.
+var Program = []byte{
+	0x7f, 0x88, 0x09, 0x40, 0x03, 0x72, 0x65, 0x73, 0x5f, 0x04, 0x62, 0x69,
+	0x74, 0x73, 0x67, 0x04, 0x6d, 0x61, 0x69, 0x6e, 0xc9, 0x01, 0x04, 0x73,
+	0x6b, 0x69, 0x70, 0xcb, 0x01, 0x04, 0x64, 0x6f, 0x6e, 0x65, 0xd3, 0x01,
+	0x04, 0x6c, 0x61, 0x73, 0x74, 0xd5, 0x01, 0x02, 0x73, 0x71, 0xdb, 0x01,
+	0x0e, 0x2e, 0x72, 0x65, 0x74, 0x2e, 0x75, 0x6e, 0x6b, 0x6e, 0x6f, 0x77,
+	0x6e, 0x2e, 0x31, 0xc0, 0x01, 0xc0, 0x07, 0xd8, 0x07, 0xd8, 0x08, 0xe7,
+	0x05, 0x81, 0xdb, 0x0b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x72, 0x65, 0x73,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x81, 0x02, 0x82, 0x02,
+	0x83, 0x02, 0x82, 0x05, 0x83, 0x04, 0x05, 0x11, 0x82, 0x03, 0x87, 0x02,
+	0x14, 0x16, 0x85, 0x5d, 0x83, 0x5b, 0x04, 0xe4, 0x1b, 0x10, 0xc0, 0x0a,
+	0xa8, 0x02, 0xdf, 0x02, 0x61, 0x83, 0x02, 0xdf, 0x61, 0xa1, 0x02, 0xdf,
+	0x62, 0x83, 0x02, 0xdf, 0x63, 0x83, 0x02, 0xdf, 0x02, 0x64, 0x10, 0xc4,
+	0x0a, 0xc8, 0x02, 0x86, 0x02, 0x87, 0x02, 0x12, 0x09, 0x84, 0x28, 0x85,
+	0x28, 0x2c, 0x82, 0x29, 0xcc, 0x02, 0x2b, 0x05, 0x0a, 0x81, 0x02, 0x82,
+	0x02, 0x82, 0x2a, 0x82, 0x2b, 0x11, 0xd4, 0x0a, 0x81, 0xd5, 0x02, 0x33,
+	0x80, 0x02, 0x86, 0x31, 0x80, 0x02, 0x84, 0x52, 0x84, 0x7f, 0x89, 0x7f,
+	0xd0, 0x08, 0x81, 0x7e, 0x82, 0x50, 0x82, 0x7f, 0x80, 0x7f, 0x85, 0x02,
+	0x04, 0x12, 0xd0, 0x0a, 0x34,
+}
+
+// Blocks are the compiled basic blocks of Program, by address.
+var Blocks = map[uint32]stackvm.Block{
+	0x0067: {N: 52, Run: block0067},
+	0x00bf: {N: 2, Run: block00bf},
+	0x00c3: {N: 2, Run: block00c3},
+	0x00c7: {N: 1, Run: block00c7},
+	0x00c9: {N: 1, Run: block00c9},
+	0x00cb: {N: 2, Run: block00cb},
+	0x00cf: {N: 1, Run: block00cf},
+	0x00d1: {N: 1, Run: block00d1},
+	0x00d3: {N: 1, Run: block00d3},
+}
+
+// New builds a machine for Program, which runs its compiled Blocks.
+func New(opts ...stackvm.MachBuildOpt) (*stackvm.Mach, error) {
+	return stackvm.New(Program, append(opts, stackvm.Compiled(Blocks))...)
+}
+
+func block0067(m stackvm.BlockMach) {
+	h := m.Head()
+	// @0x0067 1 push
+	m.Tick(0x0069)
+	if !m.PushVal(0x1) {
+		return
+	}
+	// @0x0069 2 push
+	m.Tick(0x006b)
+	if !m.PushVal(0x2) {
+		return
+	}
+	// @0x006b 3 push
+	m.Tick(0x006d)
+	if !m.PushVal(0x3) {
+		return
+	}
+	// @0x006d 2 swap
+	m.Tick(0x006f)
+	if p, ok := m.ParamRef(3); !ok {
+		return
+	} else {
+		*h, *p = *p, *h
+	}
+	// @0x006f 3 dup
+	m.Tick(0x0071)
+	if p, ok := m.ParamRef(3); !ok || !m.PushVal(*p) {
+		return
+	}
+	// @0x0071 swap
+	m.Tick(0x0072)
+	if p, ok := m.ParamRef(2); !ok {
+		return
+	} else {
+		*h, *p = *p, *h
+	}
+	// @0x0072 sub
+	m.Tick(0x0073)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h -= b
+	}
+	// @0x0073 2 pop
+	m.Tick(0x0075)
+	for i := 0; i < 2; i++ {
+		if _, ok := m.PopVal(); !ok {
+			return
+		}
+	}
+	// @0x0075 7 push
+	m.Tick(0x0077)
+	if !m.PushVal(0x7) {
+		return
+	}
+	// @0x0077 mod
+	m.Tick(0x0078)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h = uint32(rem(int32(*h), int32(b)))
+	}
+	// @0x0078 neg
+	m.Tick(0x0079)
+	*h = -*h
+	// @0x0079 5 shiftr
+	m.Tick(0x007b)
+	*h >>= 5
+	// @0x007b 3 bitxor
+	m.Tick(0x007d)
+	*h ^= 3
+	// @0x007d dup
+	m.Tick(0x007e)
+	if !m.PushVal(*h) {
+		return
+	}
+	// @0x007e 100 gte
+	m.Tick(0x0080)
+	*h = b2u(*h >= 100)
+	// @0x0080 add
+	m.Tick(0x0081)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h += b
+	}
+	// @0x0081 @0x0040 storeTo
+	m.Tick(0x0083)
+	if b, ok := m.PopVal(); !ok || !m.StoreVal(0x40, b) {
+		return
+	}
+	// @0x0083 40 push
+	m.Tick(0x0085)
+	if !m.PushVal(0x28) {
+		return
+	}
+	// @0x0085 95 push
+	m.Tick(0x0087)
+	if !m.PushVal(0x5f) {
+		return
+	}
+	// @0x0087 bitset
+	m.Tick(0x0088)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else if n, ok := m.PopVal(); !ok {
+		return
+	} else if p, ok := m.MemRef(b + n/32); !ok {
+		return
+	} else {
+		*p |= 1 << (n % 32)
+	}
+	// @0x0088 3 push
+	m.Tick(0x008a)
+	if !m.PushVal(0x3) {
+		return
+	}
+	// @0x008a @0x005f bitset
+	m.Tick(0x008c)
+	if n, ok := m.PopVal(); !ok {
+		return
+	} else if p, ok := m.MemRef(0x5f + n/32); !ok {
+		return
+	} else {
+		*p |= 1 << (n % 32)
+	}
+	// @0x008c 33 push
+	m.Tick(0x008e)
+	if !m.PushVal(0x21) {
+		return
+	}
+	// @0x008e @0x005f bitost
+	m.Tick(0x0090)
+	if n, ok := m.PopVal(); !ok {
+		return
+	} else if p, ok := m.MemRef(0x5f + n/32); !ok {
+		return
+	} else {
+		*p &^= 1 << (n % 32)
+	}
+	// @0x0090 3 push
+	m.Tick(0x0092)
+	if !m.PushVal(0x3) {
+		return
+	}
+	// @0x0092 @0x005f bitseta
+	m.Tick(0x0094)
+	if p, ok := m.MemRef(0x5f + *h/32); !ok {
+		return
+	} else if bit := uint32(1) << (*h % 32); *p&bit != 0 {
+		*h = 0
+	} else {
+		*p |= bit
+		*h = 1
+	}
+	// @0x0094 3 push
+	m.Tick(0x0096)
+	if !m.PushVal(0x3) {
+		return
+	}
+	// @0x0096 95 push
+	m.Tick(0x0098)
+	if !m.PushVal(0x5f) {
+		return
+	}
+	// @0x0098 bitosta
+	m.Tick(0x0099)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else if p, ok := m.MemRef(b + *h/32); !ok {
+		return
+	} else if bit := uint32(1) << (*h % 32); *p&bit != 0 {
+		*p &^= bit
+		*h = 1
+	} else {
+		*h = 0
+	}
+	// @0x0099 add
+	m.Tick(0x009a)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h += b
+	}
+	// @0x009a @0x0044 storeTo
+	m.Tick(0x009c)
+	if b, ok := m.PopVal(); !ok || !m.StoreVal(0x44, b) {
+		return
+	}
+	// @0x009c 72 push
+	m.Tick(0x009e)
+	if !m.PushVal(0x48) {
+		return
+	}
+	// @0x009e 6 push
+	m.Tick(0x00a0)
+	if !m.PushVal(0x6) {
+		return
+	}
+	// @0x00a0 7 push
+	m.Tick(0x00a2)
+	if !m.PushVal(0x7) {
+		return
+	}
+	// @0x00a2 mul
+	m.Tick(0x00a3)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h *= b
+	}
+	// @0x00a3 store
+	m.Tick(0x00a4)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		if a, ok := m.PopVal(); !ok || !m.StoreVal(a, b) {
+			return
+		}
+	}
+	// @0x00a4 4 cpush
+	m.Tick(0x00a6)
+	if !m.CPushVal(0x4) {
+		return
+	}
+	// @0x00a6 5 cpush
+	m.Tick(0x00a8)
+	if !m.CPushVal(0x5) {
+		return
+	}
+	// @0x00a8 mark
+	m.Tick(0x00a9)
+	if !m.CPushVal(0xa9) {
+		return
+	}
+	// @0x00a9 2 cpop
+	m.Tick(0x00ab)
+	for i := 0; i < 2; i++ {
+		if _, ok := m.CPopVal(); !ok {
+			return
+		}
+	}
+	// @0x00ab 76 push
+	m.Tick(0x00ad)
+	if !m.PushVal(0x4c) {
+		return
+	}
+	// @0x00ad c2p
+	m.Tick(0x00ae)
+	if v, ok := m.CPopVal(); !ok || !m.PushVal(v) {
+		return
+	}
+	// @0x00ae swap
+	m.Tick(0x00af)
+	if p, ok := m.ParamRef(2); !ok {
+		return
+	} else {
+		*h, *p = *p, *h
+	}
+	// @0x00af storeTo
+	m.Tick(0x00b0)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		if v, ok := m.PopVal(); !ok || !m.StoreVal(b, v) {
+			return
+		}
+	}
+	// @0x00b0 1 push
+	m.Tick(0x00b2)
+	if !m.PushVal(0x1) {
+		return
+	}
+	// @0x00b2 2 push
+	m.Tick(0x00b4)
+	if !m.PushVal(0x2) {
+		return
+	}
+	// @0x00b4 2 p2c
+	m.Tick(0x00b6)
+	for i := 0; i < 2; i++ {
+		if v, ok := m.PopVal(); !ok || !m.CPushVal(v) {
+			return
+		}
+	}
+	// @0x00b6 2 c2p
+	m.Tick(0x00b8)
+	for i := 0; i < 2; i++ {
+		if v, ok := m.CPopVal(); !ok || !m.PushVal(v) {
+			return
+		}
+	}
+	// @0x00b8 sub
+	m.Tick(0x00b9)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		*h -= b
+	}
+	// @0x00b9 @0x0054 storeTo
+	m.Tick(0x00bb)
+	if b, ok := m.PopVal(); !ok || !m.StoreVal(0x54, b) {
+		return
+	}
+	// @0x00bb 213 push
+	m.Tick(0x00be)
+	if !m.PushVal(0xd5) {
+		return
+	}
+	// @0x00be call
+	m.Tick(0x00bf)
+	if b, ok := m.PopVal(); !ok || !m.Call(b) {
+		return
+	}
+}
+
+func block00bf(m stackvm.BlockMach) {
+	// @0x00bf 0 push
+	m.Tick(0x00c1)
+	if !m.PushVal(0x0) {
+		return
+	}
+	// @0x00c1 +0x0006 jnz
+	m.Tick(0x00c3)
+	if b, ok := m.PopVal(); !ok || b != 0 && !m.Jump(6) {
+		return
+	}
+}
+
+func block00c3(m stackvm.BlockMach) {
+	// @0x00c3 0 push
+	m.Tick(0x00c5)
+	if !m.PushVal(0x0) {
+		return
+	}
+	// @0x00c5 +0x0004 bz
+	m.Tick(0x00c7)
+	if b, ok := m.PopVal(); !ok || b == 0 && !m.Branch(0xc5, 4) {
+		return
+	}
+}
+
+func block00c7(m stackvm.BlockMach) {
+	// @0x00c7 4 halt
+	m.Tick(0x00c9)
+	m.Halt(4)
+}
+
+func block00c9(m stackvm.BlockMach) {
+	// @0x00c9 9 halt
+	m.Tick(0x00cb)
+	m.Halt(9)
+}
+
+func block00cb(m stackvm.BlockMach) {
+	// @0x00cb @0x0050 fetch
+	m.Tick(0x00cd)
+	if v, ok := m.FetchVal(0x50); !ok || !m.PushVal(v) {
+		return
+	}
+	// @0x00cd 1 hz
+	m.Tick(0x00cf)
+	if b, ok := m.PopVal(); !ok {
+		return
+	} else {
+		if b == 0 {
+			m.Halt(1)
+		}
+	}
+}
+
+func block00cf(m stackvm.BlockMach) {
+	// @0x00cf +0x0002 branch
+	m.Tick(0x00d1)
+	if !m.Branch(0xcf, 2) {
+		return
+	}
+}
+
+func block00d1(m stackvm.BlockMach) {
+	// @0x00d1 2 halt
+	m.Tick(0x00d3)
+	m.Halt(2)
+}
+
+func block00d3(m stackvm.BlockMach) {
+	// @0x00d3 0 halt
+	m.Tick(0x00d5)
+	m.Halt(0)
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func rem(a, b int32) int32 {
+	x := a % b
+	if x < 0 {
+		x += b
+	}
+	return x
+}
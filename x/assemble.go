@@ -6,6 +6,7 @@ import (
 	"sort"
 
 	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/cfg"
 )
 
 // MustAssemble uses assemble the input, using Assemble(), and panics
@@ -23,6 +24,28 @@ func MustAssemble(in ...interface{}) []byte {
 // argument. An immediate argument may be an integer value, or a label
 // reference string of the form ":name". Labels are defined with a string of
 // the form "name:".
+//
+// Immediates may also be constants, defined by ".const", "NAME", value, or
+// expressions over ints, constants, and label references, like ":values+4*3",
+// "SIZE*2", or ":end-:start"; those that reference labels are evaluated as
+// the program is encoded.
+//
+// Each ".include" has its own label scope: labels named like ".loop" are
+// local to it, as are any others not given to ".export", "name" if it exports
+// any. Local labels are qualified by the name given to ".scope" (default
+// "include"), e.g. "lib.loop".
+//
+// Structured control flow may be written with ".if" [".else"] ".end",
+// ".while" ".do" ".end", and ".forkeach", N ".end"; each block is lowered into
+// jumps or forks between generated labels, and annotated as a span.
+//
+// Token templates may be defined between ".macro", "name", "$param"... and
+// ".endm", and then expanded by ".name" followed by an argument for each
+// parameter. Labels defined within a macro are local to each expansion.
+//
+// A label may be followed by the stack effect of the routine at it, like
+// "( a b -- c )", which CheckStack checks; ".stackSize", "auto" sizes the stack
+// to fit the program, as computed by the same analysis.
 func Assemble(in ...interface{}) ([]byte, error) {
 	return NewAssembler().Assemble(in...)
 }
@@ -38,6 +61,7 @@ func NewAssembler(opts ...Option) Assembler {
 type Assembler interface {
 	With(opts ...Option) Assembler
 	Assemble(in ...interface{}) ([]byte, error)
+	AssembleObject(in ...interface{}) (*Object, error)
 }
 
 // Option is an opaque customization for an Assembler; it is not to be confused
@@ -225,6 +249,38 @@ func (tok token) NeededSize() int {
 	}
 }
 
+// encodedSize returns the number of bytes that EncodeInto would use.
+func (tok token) encodedSize() int {
+	switch tok.kind {
+	case optTK, opTK:
+		var tmp [stackvm.MaxVarCodeLen]byte
+		return tok.EncodeInto(tmp[:])
+	case addrLabelTK:
+		var tmp [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(tmp[:], uint64(tok.Arg))
+		n += binary.PutUvarint(tmp[:], uint64(len(tok.str)))
+		return n + len(tok.str)
+	default:
+		return tok.NeededSize()
+	}
+}
+
+// encodePadded encodes the token into all of p, which may be larger than
+// needed by an op with an argument: its argument is then padded with leading
+// zero groups. It returns the number of bytes encoded, which is less than
+// len(p) if the token can't be padded to fit.
+func (tok token) encodePadded(p []byte) int {
+	n := tok.encodedSize()
+	if n >= len(p) || tok.kind != opTK || !tok.Have {
+		return tok.EncodeInto(p)
+	}
+	pad := len(p) - n
+	for i := 0; i < pad; i++ {
+		p[i] = 0x80
+	}
+	return pad + tok.EncodeInto(p[pad:])
+}
+
 func optToken(name string, arg uint32, have bool) token {
 	return token{
 		kind: optTK,
@@ -238,7 +294,23 @@ func allocToken(n uint32) token     { return token{kind: allocTK, Op: stackvm.Op
 func stringToken(s string) token    { return token{kind: stringTK, str: s} }
 func addrLabelToken(s string) token { return token{kind: addrLabelTK, str: s} }
 
-type ref struct{ site, targ, off int }
+type ref struct {
+	site, targ, off int
+	terms           []refTerm // any further scaled targets, from an expression
+}
+
+type refTerm struct{ targ, scale int }
+
+// last returns the index of the last token that the ref targets.
+func (rf ref) last() int {
+	last := rf.targ
+	for _, t := range rf.terms {
+		if t.targ > last {
+			last = t.targ
+		}
+	}
+	return last
+}
 
 type unkRet struct {
 	label  string
@@ -247,26 +319,61 @@ type unkRet struct {
 }
 
 type assembler struct {
-	logff func(string, ...interface{})
+	logff  func(string, ...interface{})
+	listf  func(Listing)
+	locate func(at []int) string
+
+	optimize  bool
+	optReport func(OptimizeReport)
+
+	autoStack   bool
+	stackReport func(*cfg.StackAnalysis)
 
 	pendIn, pendOut string
 
 	adls, opts, prog section
 
-	stackSize *token
-	queueSize *token
-	maxOps    *token
-	maxCopies *token
+	locals map[string]bool // labels local to included scopes, once renamed
+
+	// indices of singular option tokens within opts, by name; indices, rather
+	// than pointers, stay valid as more options are added
+	optToks map[string]int
 }
 
 func (asm assembler) Assemble(in ...interface{}) ([]byte, error) {
+	if err := asm.scanAll(in); err != nil {
+		return nil, err
+	}
+	return asm.assemble()
+}
+
+func (asm assembler) AssembleObject(in ...interface{}) (*Object, error) {
+	if err := asm.scanAll(in); err != nil {
+		return nil, err
+	}
+	if asm.optimize {
+		if err := asm.optimizeProg(); err != nil {
+			return nil, err
+		}
+	}
+	return asm.object(), nil
+}
+
+func (asm *assembler) scanAll(in []interface{}) error {
 	asm.opts = makeSection()
 	asm.prog = makeSection()
 
-	asm.stackSize = asm.refOpt("stackSize", defaultStackSize, true)
+	asm.optToks = nil
+	asm.setOption("stackSize", defaultStackSize)
 
-	if err := asm.scan(in); err != nil {
-		return nil, err
+	return asm.scan(in)
+}
+
+func (asm *assembler) assemble() ([]byte, error) {
+	if asm.optimize {
+		if err := asm.optimizeProg(); err != nil {
+			return nil, err
+		}
 	}
 
 	enc, err := asm.finish()
@@ -274,7 +381,22 @@ func (asm assembler) Assemble(in ...interface{}) ([]byte, error) {
 		return nil, err
 	}
 
-	return enc.encode()
+	prog, err := enc.encode()
+	if err != nil {
+		return nil, err
+	}
+	if asm.autoStack || asm.stackReport != nil {
+		if enc, prog, err = asm.checkStack(enc, prog); err != nil {
+			return nil, err
+		}
+	}
+	if asm.listf != nil {
+		asm.listf(enc.listing())
+	}
+	if asm.locate != nil {
+		prog = enc.addSourceMap(prog, asm.locate)
+	}
+	return prog, nil
 }
 
 func (asm assembler) With(opts ...Option) Assembler {
@@ -290,32 +412,41 @@ func (asm *assembler) logf(format string, args ...interface{}) {
 	}
 }
 
-func (asm *assembler) setOption(ptok **token, name string, v uint32) {
-	if *ptok == nil {
-		*ptok = asm.refOpt(name, v, true)
-	} else {
-		(*ptok).Arg = v
+func (asm *assembler) setOption(name string, v uint32) {
+	if i, set := asm.optToks[name]; set {
+		asm.opts.toks[i].Arg = v
+		return
+	}
+	if asm.optToks == nil {
+		asm.optToks = make(map[string]int)
 	}
+	asm.optToks[name] = len(asm.opts.toks)
+	asm.addOpt(name, v, true)
+}
+
+// stackSize returns the program's stack size option.
+func (asm *assembler) stackSize() uint32 {
+	return asm.opts.toks[asm.optToks["stackSize"]].Arg
 }
 
 type namedRef struct {
 	targName string
+	terms    []exprTerm
 	ref
 }
 
 type section struct {
-	toks     []token
-	refs     []namedRef
-	labels   map[string]int
-	maxBytes int
+	toks    []token
+	refs    []namedRef
+	labels  map[string]int
+	effects map[int]cfg.Effect // declared stack effects, by token index
+
+	src  []int   // origin of any tokens now added, if tracked; see tokenAt
+	srcs [][]int // origin of each token, if tracked
 }
 
 func makeSection(toks ...token) section {
-	sec := section{toks: toks}
-	for i := range toks {
-		sec.maxBytes += toks[i].NeededSize()
-	}
-	return sec
+	return section{toks: toks}
 }
 
 func collectSections(secs ...section) (sec section) {
@@ -324,7 +455,6 @@ func collectSections(secs ...section) (sec section) {
 		numToks += len(s.toks)
 		numRefs += len(s.refs)
 		numLabels += len(s.labels)
-		sec.maxBytes += s.maxBytes
 	}
 	if numLabels > 0 {
 		sec.labels = make(map[string]int)
@@ -338,10 +468,10 @@ func collectSections(secs ...section) (sec section) {
 
 	base := 0
 	for _, s := range secs {
-		for i := range s.refs {
-			s.refs[i].site += base
+		for _, nrf := range s.refs {
+			nrf.site += base
+			sec.refs = append(sec.refs, nrf)
 		}
-		sec.refs = append(sec.refs, s.refs...)
 
 		for name, off := range s.labels {
 			if off >= 0 {
@@ -351,6 +481,13 @@ func collectSections(secs ...section) (sec section) {
 
 		sec.toks = append(sec.toks, s.toks...)
 
+		if len(s.srcs) > 0 {
+			for len(sec.srcs) < base {
+				sec.srcs = append(sec.srcs, nil)
+			}
+			sec.srcs = append(sec.srcs, s.srcs...)
+		}
+
 		base += len(s.toks)
 	}
 
@@ -360,13 +497,20 @@ func collectSections(secs ...section) (sec section) {
 func (sec section) checkLabels() error {
 	var undefined []string
 	noted := make(map[string]struct{}, len(sec.refs))
+	note := func(name string) {
+		if _, targNoted := noted[name]; !targNoted {
+			noted[name] = struct{}{}
+			if i, defined := sec.labels[name]; !defined || i < 0 {
+				undefined = append(undefined, name)
+			}
+		}
+	}
 	for _, nrf := range sec.refs {
 		if nrf.targName != "" {
-			if _, targNoted := noted[nrf.targName]; !targNoted {
-				if i, defined := sec.labels[nrf.targName]; !defined || i < 0 {
-					undefined = append(undefined, nrf.targName)
-				}
-			}
+			note(nrf.targName)
+		}
+		for _, t := range nrf.terms {
+			note(t.label)
 		}
 	}
 	if len(undefined) > 0 {
@@ -385,6 +529,12 @@ func (sec section) resolveRefs() (refs []ref) {
 			panic(fmt.Sprintf("NOPE %v", nrf))
 		}
 		nrf.targ = sec.labels[nrf.targName]
+		if len(nrf.terms) > 0 {
+			nrf.ref.terms = make([]refTerm, len(nrf.terms))
+			for i, t := range nrf.terms {
+				nrf.ref.terms[i] = refTerm{sec.labels[t.label], t.scale}
+			}
+		}
 		refs = append(refs, nrf.ref)
 	}
 	if len(refs) > 0 {
@@ -396,14 +546,33 @@ func (sec section) resolveRefs() (refs []ref) {
 }
 
 func (sec *section) add(tok token) {
+	sec.noteSrc()
 	sec.toks = append(sec.toks, tok)
-	sec.maxBytes += tok.NeededSize()
 }
 
 func (sec *section) addRef(tok token, name string, off int) {
-	sec.refs = append(sec.refs, namedRef{name, ref{site: len(sec.toks), off: off}})
+	sec.refs = append(sec.refs, namedRef{targName: name, ref: ref{site: len(sec.toks), off: off}})
+	sec.noteSrc()
 	sec.toks = append(sec.toks, tok)
-	sec.maxBytes += 6
+}
+
+// noteSrc records the current origin for the next token added.
+func (sec *section) noteSrc() {
+	if sec.src == nil {
+		return
+	}
+	for len(sec.srcs) < len(sec.toks) {
+		sec.srcs = append(sec.srcs, nil)
+	}
+	sec.srcs = append(sec.srcs, sec.src)
+}
+
+// srcAt returns the origin of the i-th token, or nil if it is unknown.
+func (sec section) srcAt(i int) []int {
+	if i < len(sec.srcs) {
+		return sec.srcs[i]
+	}
+	return nil
 }
 
 func (sec *section) stubLabel(name string) {
@@ -426,8 +595,10 @@ func (sec *section) genLabel(name string) string {
 	if _, defined := sec.labels[name]; name[0] == '.' || defined {
 		n, tmp := 1, fmt.Sprintf("%s.1", name)
 		for _, defined := sec.labels[tmp]; defined; _, defined = sec.labels[tmp] {
-			n, tmp = n+1, fmt.Sprintf("%s.%d", name, n)
+			n++
+			tmp = fmt.Sprintf("%s.%d", name, n)
 		}
+		return tmp
 	}
 	return name
 }
@@ -437,10 +608,20 @@ func (sec *section) renameLabel(old, new string) {
 		delete(sec.labels, old)
 		sec.labels[new] = n
 	}
-	for i := range sec.refs {
+	sec.renameRefs(old, new, 0)
+}
+
+// renameRefs renames the target of any refs, from the given index on.
+func (sec *section) renameRefs(old, new string, from int) {
+	for i := from; i < len(sec.refs); i++ {
 		if sec.refs[i].targName == old {
 			sec.refs[i].targName = new
 		}
+		for j := range sec.refs[i].terms {
+			if sec.refs[i].terms[j].label == old {
+				sec.refs[i].terms[j].label = new
+			}
+		}
 	}
 }
 
@@ -453,12 +634,6 @@ const (
 
 const defaultStackSize = 0x40
 
-func (asm *assembler) refOpt(name string, arg uint32, have bool) *token {
-	i := len(asm.opts.toks)
-	asm.addOpt(name, arg, have)
-	return &asm.opts.toks[i]
-}
-
 func (asm *assembler) addOpt(name string, arg uint32, have bool) {
 	asm.opts.add(optToken(name, arg, have))
 }
@@ -483,11 +658,8 @@ func (asm *assembler) renameLabel(old, new string) string {
 	asm.prog.renameLabel(old, new)
 	for i, tok := range asm.adls.toks {
 		if tok.kind == addrLabelTK && tok.str == old {
-			oldSize := tok.NeededSize()
 			tok.str = new
 			asm.adls.toks[i] = tok
-			newSize := tok.NeededSize()
-			asm.adls.maxBytes += newSize - oldSize
 			break
 		}
 	}
@@ -522,7 +694,7 @@ func (asm *assembler) finish() (encoder, error) {
 			asm.prog,
 		),
 		logf: asm.logf,
-		base: asm.stackSize.Arg,
+		base: asm.stackSize(),
 	}
 	err := enc.checkLabels()
 	if err == nil {
@@ -536,6 +708,8 @@ type scanner struct {
 	prior []scannerState
 	scannerState
 	unkRets map[string]*unkRet
+	macros  map[string]*macro
+	consts  map[string]int
 }
 
 type scannerState struct {
@@ -544,8 +718,12 @@ type scannerState struct {
 	state  assemblerState
 	label  string
 	open   []string
+	blocks []block
 	labels []string
 	opens  map[string]struct{}
+	scope  *labelScope // scope of the sub-program being scanned, if any
+	macro  string      // name of the macro being expanded, if any
+	at     int         // index of the token that expanded the macro
 }
 
 func (sc *scanner) scan(in []interface{}) error {
@@ -557,36 +735,122 @@ func (sc *scanner) scan(in []interface{}) error {
 	for {
 		for ; sc.i < len(sc.in); sc.i++ {
 			sc.label = ""
+			if sc.listf != nil || sc.locate != nil {
+				sc.prog.src = sc.tokenAt()
+			}
 			if err := sc.handle(sc.in[sc.i]); err != nil {
-				return err
+				return sc.tokenError(err)
 			}
 		}
-		if sc.popState() {
-			continue
+		more, err := sc.popState()
+		if err != nil {
+			return sc.tokenError(err)
+		}
+		if !more {
+			return nil
 		}
-		return nil
 	}
 }
 
-func (sc *scanner) pushState() {
+// tokenError is an error encountered while handling a token; at holds its
+// origin, as returned by tokenAt. Its message is just that of the underlying
+// error.
+type tokenError struct {
+	at  []int
+	err error
+}
+
+func (te tokenError) Cause() error  { return te.err }
+func (te tokenError) Error() string { return te.err.Error() }
+
+func (sc *scanner) tokenError(err error) error {
+	for i := len(sc.prior); i > 0; i-- {
+		st := &sc.scannerState
+		if i < len(sc.prior) {
+			st = &sc.prior[i]
+		}
+		if st.macro != "" {
+			err = fmt.Errorf("in .%s: %v", st.macro, err)
+		}
+	}
+	return tokenError{sc.tokenAt(), err}
+}
+
+// tokenAt returns the origin of the current token: its index, preceded by
+// those of any enclosing .include tokens. A token within a macro expansion is
+// instead attributed to the token that expanded it.
+func (sc *scanner) tokenAt() []int {
+	at := make([]int, 0, len(sc.prior)+1)
+	for _, st := range sc.prior {
+		at = append(at, st.i)
+	}
+	at = append(at, sc.i)
+	for i := len(sc.prior); i > 0; i-- {
+		st := &sc.scannerState
+		if i < len(sc.prior) {
+			st = &sc.prior[i]
+		}
+		if st.macro != "" {
+			at = append(at[:i-1], st.at)
+		}
+	}
+	return at
+}
+
+func (sc *scanner) pushState(in []interface{}) {
 	sc.prior, sc.scannerState = append(sc.prior, sc.scannerState), scannerState{
 		i:     -1, // TODO: because of how the loop in sc.scan works, bit regrettable
-		in:    subProg,
+		in:    in,
 		state: assemblerText,
+		scope: sc.newScope(),
 	}
 }
 
-func (sc *scanner) popState() bool {
+// pushMacro starts scanning a macro expansion; unlike an included
+// sub-program, it shares its section and span state with the expanding scope,
+// as if its tokens had been written there.
+func (sc *scanner) pushMacro(name string, at int, in []interface{}) {
+	st := sc.scannerState
+	sc.prior, sc.scannerState = append(sc.prior, st), scannerState{
+		i:      -1,
+		in:     in,
+		state:  st.state,
+		open:   st.open,
+		blocks: st.blocks,
+		labels: st.labels,
+		opens:  st.opens,
+		scope:  st.scope,
+		macro:  name,
+		at:     at,
+	}
+}
+
+func (sc *scanner) popState() (bool, error) {
 	i := len(sc.prior) - 1
 	if i < 0 {
-		return false
+		return false, sc.checkBlocks()
+	}
+	if sc.macro != "" {
+		st := sc.scannerState
+		sc.scannerState, sc.prior = sc.prior[i], sc.prior[:i]
+		sc.state, sc.open, sc.labels, sc.opens = st.state, st.open, st.labels, st.opens
+		sc.blocks = st.blocks
+		sc.i++
+		return true, nil
+	}
+	if err := sc.checkBlocks(); err != nil {
+		return false, err
 	}
 	if len(sc.open) > 0 {
 		panic(fmt.Sprintf("unclosed spans: %q", sc.open))
 	}
+	scope := sc.scope
 	sc.scannerState, sc.prior = sc.prior[i], sc.prior[:i]
+	if err := sc.closeScope(scope); err != nil {
+		return true, err
+	}
 	sc.i++
-	return true
+	return true, nil
 }
 
 func (sc *scanner) handle(val interface{}) error {
@@ -608,7 +872,7 @@ func (sc *scanner) handleQueueSize() error {
 	if n < 0 {
 		return fmt.Errorf("invalid .queueSize %v, must be non-negative", n)
 	}
-	sc.setOption(&sc.queueSize, "queueSize", uint32(n))
+	sc.setOption("queueSize", uint32(n))
 	return nil
 }
 
@@ -620,7 +884,7 @@ func (sc *scanner) handleMaxOps() error {
 	if n < 0 {
 		return fmt.Errorf("invalid .maxOps %v, must be non-negative", n)
 	}
-	sc.setOption(&sc.maxOps, "maxOps", uint32(n))
+	sc.setOption("maxOps", uint32(n))
 	return nil
 }
 
@@ -632,11 +896,28 @@ func (sc *scanner) handleMaxCopies() error {
 	if n < 0 {
 		return fmt.Errorf("invalid .maxCopies %v, must be non-negative", n)
 	}
-	sc.setOption(&sc.maxCopies, "maxCopies", uint32(n))
+	sc.setOption("maxCopies", uint32(n))
+	return nil
+}
+
+func (sc *scanner) handleMaxDepth() error {
+	n, err := sc.expectInt("maxDepth int")
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("invalid .maxDepth %v, must be non-negative", n)
+	}
+	sc.setOption("maxDepth", uint32(n))
 	return nil
 }
 
 func (sc *scanner) handleStackSize() error {
+	if sc.i+1 < len(sc.in) && sc.in[sc.i+1] == "auto" {
+		sc.i++
+		sc.autoStack = true
+		return nil
+	}
 	n, err := sc.expectInt("stackSize int")
 	if err != nil {
 		return err
@@ -644,7 +925,8 @@ func (sc *scanner) handleStackSize() error {
 	if n < +0 || n > 0xffff {
 		return fmt.Errorf("stackSize %d out of range, must be in (0x0000, 0xffff)", n)
 	}
-	sc.stackSize.Arg = uint32(n)
+	sc.setOption("stackSize", uint32(n))
+	sc.autoStack = false
 	return nil
 }
 
@@ -652,11 +934,18 @@ func (sc *scanner) handleData(val interface{}) error {
 	switch v := val.(type) {
 	case string:
 		switch {
+		case len(v) > 1 && v[len(v)-1] == ':':
+			return sc.handleLabel(v[:len(v)-1])
+
 		case len(v) > 1 && v[0] == '.':
 			return sc.handleDataDirective(v[1:])
 
-		case len(v) > 1 && v[len(v)-1] == ':':
-			return sc.handleLabel(v[:len(v)-1])
+		case sc.isExpr(v):
+			n, err := sc.evalConst(v)
+			if err != nil {
+				return err
+			}
+			return sc.handleDataWord(uint32(n))
 
 		default:
 			return fmt.Errorf("unexpected string %q", v)
@@ -665,6 +954,9 @@ func (sc *scanner) handleData(val interface{}) error {
 	case int:
 		return sc.handleDataWord(uint32(v))
 
+	case localLabel:
+		return sc.handleLabel(string(v))
+
 	default:
 		return fmt.Errorf(`invalid token %T(%v); expected ".directive", "label:", or an int`, val, val)
 	}
@@ -687,11 +979,17 @@ func (sc *scanner) handleText(val interface{}) error {
 	switch v := val.(type) {
 	case string:
 		switch {
+		case len(v) > 1 && v[len(v)-1] == ':':
+			return sc.handleLabel(v[:len(v)-1])
+
 		case len(v) > 1 && v[0] == '.':
 			return sc.handleTextDirective(v[1:])
 
-		case len(v) > 1 && v[len(v)-1] == ':':
-			return sc.handleLabel(v[:len(v)-1])
+		case isEffect(v):
+			return sc.handleEffect(v)
+
+		case sc.isExpr(v):
+			return sc.handleExpr(v, 0)
 
 		case len(v) > 1 && v[0] == ':':
 			return sc.handleRef(v[1:])
@@ -703,6 +1001,9 @@ func (sc *scanner) handleText(val interface{}) error {
 	case int:
 		return sc.handleImm(v)
 
+	case localLabel:
+		return sc.handleLabel(string(v))
+
 	default:
 		return fmt.Errorf(`invalid token %T(%v); expected ".directive", "label:", ":ref", "opName", or an int`, val, val)
 	}
@@ -714,6 +1015,18 @@ func (sc *scanner) handleTextDirective(s string) error {
 		return sc.handleSpanOpen()
 	case "spanClose":
 		return sc.handleSpanClose()
+	case "if":
+		return sc.handleIf()
+	case "else":
+		return sc.handleElse()
+	case "while":
+		return sc.handleWhile()
+	case "do":
+		return sc.handleDo()
+	case "forkeach":
+		return sc.handleForkEach()
+	case "end":
+		return sc.handleEnd()
 	default:
 		return sc.handleDirective(s)
 	}
@@ -724,10 +1037,14 @@ func (sc *scanner) handleSpanOpen() error {
 	if err != nil {
 		return err
 	}
+	sc.beginSpan(name)
+	return nil
+}
+
+func (sc *scanner) beginSpan(name string) {
 	sc.addSpanOpen(name)
 	sc.open = append(sc.open, name)
 	sc.labels = sc.labels[:0]
-	return nil
 }
 
 func (sc *scanner) handleSpanClose() error {
@@ -747,13 +1064,28 @@ func (sc *scanner) handleDirective(name string) error {
 		return sc.handleMaxOps()
 	case "maxCopies":
 		return sc.handleMaxCopies()
+	case "maxDepth":
+		return sc.handleMaxDepth()
 	case "data":
 		return sc.setState(assemblerData)
 	case "text":
 		return sc.setState(assemblerText)
 	case "include":
 		return sc.handleInclude()
+	case "const":
+		return sc.handleConst()
+	case "export":
+		return sc.handleExport()
+	case "scope":
+		return sc.handleScope()
+	case "macro":
+		return sc.handleMacro()
+	case "endm":
+		return errUnexpectedEndm
 	default:
+		if mac := sc.macros[name]; mac != nil {
+			return sc.expandMacro(mac)
+		}
 		return fmt.Errorf("invalid directive .%s", name)
 	}
 }
@@ -767,7 +1099,7 @@ func (sc *scanner) handleInclude() error {
 	if !ok {
 		return fmt.Errorf("invalid token %T(%v); expected []interface{}", val, val)
 	}
-	sc.pushState()
+	sc.pushState(subProg)
 	return nil
 }
 
@@ -826,7 +1158,11 @@ func (sc *scanner) handleLabel(name string) error {
 		}
 	}
 
-	if i, defined := sc.prog.labels[name]; defined && i >= 0 {
+	if sc.scope != nil {
+		if err := sc.defineLabel(sc.scope, name); err != nil {
+			return err
+		}
+	} else if i, defined := sc.prog.labels[name]; defined && i >= 0 {
 		return fmt.Errorf("label %q already defined", name)
 	}
 
@@ -969,6 +1305,9 @@ func (sc *scanner) handleImm(n int) error {
 	if err != nil {
 		return err
 	}
+	if sc.isExpr(s) {
+		return sc.handleExpr(s, n)
+	}
 	if len(s) > 1 && s[0] == ':' {
 		return sc.handleOffRef(s[1:], n)
 	}
@@ -1077,6 +1416,9 @@ func (sc *scanner) expectInt(desc string) (int, error) {
 		if n, ok := val.(int); ok {
 			return n, nil
 		}
+		if s, ok := val.(string); ok && sc.isExpr(s) {
+			return sc.evalConst(s)
+		}
 		err = fmt.Errorf("invalid token %T(%v); expected %s", val, val, desc)
 	}
 	return 0, err
@@ -1104,109 +1446,111 @@ type encoder struct {
 	offsets []uint32
 	c       uint32 // current token offset
 	i       int    // current token index
-}
-
-func (enc encoder) encode() ([]byte, error) {
-	enc.buf = make([]byte, enc.maxBytes)
-	enc.offsets = make([]uint32, len(enc.toks)+1)
+	nopts   int    // count of option tokens
+	boff    uint32 // offset of encoded program
+}
+
+// encode encodes the tokens, after relaxing the size of each program ref:
+// each starts out at its smallest encoding, and is grown whenever its resolved
+// argument needs more bytes, until no more grow. Since growing a token only
+// moves later addresses up, every absolute or forward argument only grows as
+// well, and this converges after a few passes. A ref left with more room than
+// its final argument needs is padded, rather than shrunk, so that the relaxed
+// addresses hold.
+func (enc *encoder) encode() ([]byte, error) {
+	enc.nopts = 0
+	for enc.nopts < len(enc.toks) {
+		tok := enc.toks[enc.nopts]
+		enc.nopts++
+		if tok.kind == optTK && tok.Code == optCodeEnd {
+			break
+		}
+	}
 
 	var (
-		boff  uint32           // offset of encoded program
-		nopts int              // count of option tokens
-		rfi   int              // index of next ref
-		rf    = ref{-1, -1, 0} // next ref
+		sizes    = make([]int, len(enc.toks))
+		addrs    = make([]uint32, len(enc.toks)+1)
+		progRefs []ref
 	)
-
-	if len(enc.refs) > 0 {
-		rf = enc.refs[rfi]
+	for i := enc.nopts; i < len(enc.toks); i++ {
+		sizes[i] = enc.toks[i].encodedSize()
 	}
-
-	// encode options
-encodeOptions:
-	nopts = 0
-	for enc.i < len(enc.toks) {
-		if tok, err := enc.encodeTok(); err != nil {
-			return nil, err
-		} else if tok.kind == optTK && tok.Code == optCodeEnd {
-			break
+	for _, rf := range enc.refs {
+		if rf.site >= enc.nopts {
+			progRefs = append(progRefs, rf)
 		}
 	}
-	nopts, boff = enc.i, enc.c
-
-	// encode program
-	for enc.i < len(enc.toks) {
-		// fix a previously encoded ref's target
-		for 0 <= rf.site && rf.site < enc.i && rf.targ <= enc.i {
-			// re-encode the ref and rewind if arg size changed
-			lo, hi := enc.offsets[rf.site], enc.offsets[rf.site+1]
-			site := enc.base + enc.offsets[rf.site] - boff
-			targ := enc.base + enc.offsets[rf.targ] - boff + uint32(enc.refs[rfi].off)
-			tok := enc.toks[rf.site]
-			tok = tok.ResolveRefArg(site, targ)
-			enc.toks[rf.site] = tok
-			p := enc.buf[lo:]
-			n := tok.EncodeInto(p)
-			if n <= 0 {
-				return nil, fmt.Errorf("failed to recode toks[%d]=%v", rf.site, tok)
-			}
-			p = p[:n]
-			if end := lo + uint32(n); end != hi {
-				// rewind to prior ref
-				enc.i, enc.c = rf.site+1, end
-				enc.offsets[enc.i] = enc.c
-				for rfi, rf = range enc.refs {
-					if rf.site >= enc.i || rf.targ >= enc.i {
-						break
-					}
-				}
-				if enc.i < nopts {
-					goto encodeOptions
-				}
-			} else {
-				// next ref
-				rfi++
-				if rfi >= len(enc.refs) {
-					rf = ref{site: -1, targ: -1}
-				} else {
-					rf = enc.refs[rfi]
-				}
+
+	for pass := 1; ; pass++ {
+		addrs[enc.nopts] = enc.base
+		for i := enc.nopts; i < len(enc.toks); i++ {
+			addrs[i+1] = addrs[i] + uint32(sizes[i])
+		}
+		grown := 0
+		for _, rf := range progRefs {
+			if n := enc.resolve(rf, addrs, sizes[rf.site]).encodedSize(); n > sizes[rf.site] {
+				sizes[rf.site] = n
+				grown++
 			}
 		}
-
-		if _, err := enc.encodeTok(); err != nil {
-			return nil, err
+		if grown == 0 {
+			enc.logf("relaxed %d refs in %d passes", len(progRefs), pass)
+			break
 		}
 	}
 
-	if rf.site >= 0 {
-		tok := enc.toks[rf.site]
-		name := "???"
-		for n, targ := range enc.labels {
-			if targ == rf.targ {
-				name = n
-			}
+	for _, rf := range enc.refs {
+		enc.toks[rf.site] = enc.resolve(rf, addrs, sizes[rf.site])
+	}
+	for i := 0; i < enc.nopts; i++ {
+		sizes[i] = enc.toks[i].encodedSize()
+	}
+
+	n := 0
+	for _, size := range sizes {
+		n += size
+	}
+	enc.buf = make([]byte, n)
+	enc.offsets = make([]uint32, len(enc.toks)+1)
+	for enc.i = 0; enc.i < len(enc.toks); {
+		if err := enc.encodeTok(sizes[enc.i]); err != nil {
+			return nil, err
 		}
-		if rf.off != 0 {
-			return nil, fmt.Errorf("unresolved reference for `%d, \":%s\", %q`", rf.off, name, tok.Name())
+		if enc.i == enc.nopts {
+			enc.boff = enc.c
 		}
-		return nil, fmt.Errorf("unresolved reference for `\":%s\", %q`", name, tok.Name())
 	}
-
 	return enc.buf[:enc.c], nil
 }
 
-func (enc *encoder) encodeTok() (token, error) {
+// resolve returns the token at a ref's site, with its argument resolved
+// against the given addresses, for an encoding of the given size.
+func (enc *encoder) resolve(rf ref, addrs []uint32, size int) token {
+	site := addrs[rf.site]
+	targ := addrs[rf.targ] + uint32(rf.off)
+	for _, t := range rf.terms {
+		targ += uint32(t.scale) * addrs[t.targ]
+	}
+	tok := enc.toks[rf.site].ResolveRefArg(site, targ)
+	if tok.kind == opTK && tok.RelativeRef() {
+		// relative to the end of the op, however it's padded
+		tok.Arg = targ - site - uint32(size)
+	}
+	return tok
+}
+
+func (enc *encoder) encodeTok(size int) error {
 	tok := enc.toks[enc.i]
 	p := enc.buf[enc.c:]
-	if len(p) == 0 {
-		return tok, fmt.Errorf("no space to encode toks[%d]=%v", enc.i, tok)
+	if len(p) < size {
+		return fmt.Errorf("no space to encode toks[%d]=%v", enc.i, tok)
 	}
-	n := tok.EncodeInto(p)
-	if n <= 0 {
-		return tok, fmt.Errorf("failed to encode toks[%d]=%v", enc.i, tok)
+	n := tok.encodePadded(p[:size])
+	if n != size {
+		return fmt.Errorf("failed to encode toks[%d]=%v", enc.i, tok)
 	}
 	enc.c += uint32(n)
 	enc.i++
 	enc.offsets[enc.i] = enc.c
-	return tok, nil
+	return nil
 }
@@ -230,3 +230,28 @@ func TestAssemble(t *testing.T) {
 		},
 	}.run(t)
 }
+
+func TestAssemble_options(t *testing.T) {
+	code := []interface{}{1, "push", 0, "halt"}
+	want, err := Assemble(append([]interface{}{
+		".stackSize", 0x80,
+		".maxOps", 100,
+		".queueSize", 4,
+		".maxCopies", 8,
+		".maxDepth", 3,
+	}, code...)...)
+	require.NoError(t, err, "unexpected error")
+
+	// each option added after the first moves the others; any given again
+	// must still replace its first value
+	prog, err := Assemble(append([]interface{}{
+		".maxOps", 1,
+		".queueSize", 4,
+		".maxCopies", 8,
+		".maxDepth", 3,
+		".maxOps", 100,
+		".stackSize", 0x80,
+	}, code...)...)
+	require.NoError(t, err, "unexpected error")
+	assert.Equal(t, want, prog, "expected the same options, in any order")
+}
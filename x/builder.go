@@ -0,0 +1,119 @@
+package xstackvm
+
+//go:generate python ../gen_op_codes.py -builder -i ../ops.go -o builder_ops.go
+
+// Builder builds the token stream of a program, as taken by Assemble, with
+// typed methods rather than a []interface{}. Each method returns the builder,
+// so that calls may be chained:
+//
+//	var b Builder
+//	b.Entry("main").
+//		PushImm(3).Label("loop").
+//		SubImm(1).Dup().JnzTo("loop").
+//		HaltImm(0)
+//	prog, err := b.Assemble()
+//
+// A method is generated for each operation, named after it, e.g. Add() for
+// "add". Operations that take an immediate value also have an Imm variant,
+// e.g. AddImm(1); those whose immediate is an address or offset also have a
+// variant taking a label instead: At for addresses, e.g. CallAt("f"), or To
+// for offsets, e.g. JumpTo("loop").
+type Builder struct {
+	toks []interface{}
+}
+
+// Tokens returns the built token stream.
+func (b *Builder) Tokens() []interface{} { return b.toks }
+
+// Assemble assembles the built token stream.
+func (b *Builder) Assemble(opts ...Option) ([]byte, error) {
+	return NewAssembler(opts...).Assemble(b.toks...)
+}
+
+func (b *Builder) add(toks ...interface{}) *Builder {
+	b.toks = append(b.toks, toks...)
+	return b
+}
+
+// Op adds an operation by name; any immediate must be added before it, with
+// Imm or Ref.
+func (b *Builder) Op(name string) *Builder { return b.add(name) }
+
+// Imm adds an immediate value for the next operation.
+func (b *Builder) Imm(v int) *Builder { return b.add(v) }
+
+// Ref adds a reference to a label, as an immediate for the next operation.
+func (b *Builder) Ref(label string) *Builder { return b.add(":" + label) }
+
+// Expr adds an immediate expression, like ":values+4*3", for the next
+// operation.
+func (b *Builder) Expr(expr string) *Builder { return b.add(expr) }
+
+// Label defines a label at the current position.
+func (b *Builder) Label(name string) *Builder { return b.add(name + ":") }
+
+// Directive adds any directive, with the given arguments.
+func (b *Builder) Directive(name string, args ...interface{}) *Builder {
+	return b.add("." + name).add(args...)
+}
+
+// Entry defines a label at which the program starts.
+func (b *Builder) Entry(label string) *Builder { return b.add(".entry", label+":") }
+
+// StackSize sets the size of the machine stack.
+func (b *Builder) StackSize(n int) *Builder { return b.add(".stackSize", n) }
+
+// Const defines a constant.
+func (b *Builder) Const(name string, v int) *Builder { return b.add(".const", name, v) }
+
+// Data switches to the data section.
+func (b *Builder) Data() *Builder { return b.add(".data") }
+
+// Text switches to the text section.
+func (b *Builder) Text() *Builder { return b.add(".text") }
+
+// Word adds data words.
+func (b *Builder) Word(vs ...int) *Builder {
+	for _, v := range vs {
+		b.add(v)
+	}
+	return b
+}
+
+// Alloc adds n zeroed data words.
+func (b *Builder) Alloc(n int) *Builder { return b.add(".alloc", n) }
+
+// In defines an input region, starting at the given label; the label is
+// defined by In, so data words should follow.
+func (b *Builder) In(label string) *Builder { return b.add(".in", label+":") }
+
+// Out defines an output region, starting at the given label; the label is
+// defined by Out, so data words should follow.
+func (b *Builder) Out(label string) *Builder { return b.add(".out", label+":") }
+
+// Include includes a sub-program, within its own label scope.
+func (b *Builder) Include(sub *Builder) *Builder { return b.add(".include", sub.toks) }
+
+// SpanOpen defines a label, opening a semantic span there.
+func (b *Builder) SpanOpen(label string) *Builder { return b.add(".spanOpen", label+":") }
+
+// SpanClose closes the last open semantic span.
+func (b *Builder) SpanClose() *Builder { return b.add(".spanClose") }
+
+// If starts an .if block, which pops its condition.
+func (b *Builder) If() *Builder { return b.add(".if") }
+
+// Else starts the .else branch of an .if block.
+func (b *Builder) Else() *Builder { return b.add(".else") }
+
+// While starts a .while block, whose condition ends at Do.
+func (b *Builder) While() *Builder { return b.add(".while") }
+
+// Do ends the condition of a .while block.
+func (b *Builder) Do() *Builder { return b.add(".do") }
+
+// ForkEach starts a .forkeach block, forking a copy for each i in [0, n).
+func (b *Builder) ForkEach(n int) *Builder { return b.add(".forkeach", n) }
+
+// End ends the innermost block.
+func (b *Builder) End() *Builder { return b.add(".end") }
@@ -0,0 +1,367 @@
+package xstackvm
+
+// Crash adds a "crash" operation.
+func (b *Builder) Crash() *Builder { return b.Op("crash") }
+
+// Nop adds a "nop" operation.
+func (b *Builder) Nop() *Builder { return b.Op("nop") }
+
+// Push adds a "push" operation.
+func (b *Builder) Push() *Builder { return b.Op("push") }
+
+// PushImm adds a "push" operation, with an immediate value.
+func (b *Builder) PushImm(v int) *Builder { return b.Imm(v).Op("push") }
+
+// Pop adds a "pop" operation.
+func (b *Builder) Pop() *Builder { return b.Op("pop") }
+
+// PopImm adds a "pop" operation, with an immediate value.
+func (b *Builder) PopImm(v int) *Builder { return b.Imm(v).Op("pop") }
+
+// Dup adds a "dup" operation.
+func (b *Builder) Dup() *Builder { return b.Op("dup") }
+
+// DupImm adds a "dup" operation, with an immediate value.
+func (b *Builder) DupImm(v int) *Builder { return b.Imm(v).Op("dup") }
+
+// Swap adds a "swap" operation.
+func (b *Builder) Swap() *Builder { return b.Op("swap") }
+
+// SwapImm adds a "swap" operation, with an immediate value.
+func (b *Builder) SwapImm(v int) *Builder { return b.Imm(v).Op("swap") }
+
+// Fetch adds a "fetch" operation.
+func (b *Builder) Fetch() *Builder { return b.Op("fetch") }
+
+// FetchImm adds a "fetch" operation, with an immediate value.
+func (b *Builder) FetchImm(v int) *Builder { return b.Imm(v).Op("fetch") }
+
+// FetchAt adds a "fetch" operation, referencing the given label.
+func (b *Builder) FetchAt(label string) *Builder { return b.Ref(label).Op("fetch") }
+
+// Store adds a "store" operation.
+func (b *Builder) Store() *Builder { return b.Op("store") }
+
+// StoreImm adds a "store" operation, with an immediate value.
+func (b *Builder) StoreImm(v int) *Builder { return b.Imm(v).Op("store") }
+
+// StoreTo adds a "storeTo" operation.
+func (b *Builder) StoreTo() *Builder { return b.Op("storeTo") }
+
+// StoreToImm adds a "storeTo" operation, with an immediate value.
+func (b *Builder) StoreToImm(v int) *Builder { return b.Imm(v).Op("storeTo") }
+
+// StoreToAt adds a "storeTo" operation, referencing the given label.
+func (b *Builder) StoreToAt(label string) *Builder { return b.Ref(label).Op("storeTo") }
+
+// Add adds a "add" operation.
+func (b *Builder) Add() *Builder { return b.Op("add") }
+
+// AddImm adds a "add" operation, with an immediate value.
+func (b *Builder) AddImm(v int) *Builder { return b.Imm(v).Op("add") }
+
+// Sub adds a "sub" operation.
+func (b *Builder) Sub() *Builder { return b.Op("sub") }
+
+// SubImm adds a "sub" operation, with an immediate value.
+func (b *Builder) SubImm(v int) *Builder { return b.Imm(v).Op("sub") }
+
+// Mul adds a "mul" operation.
+func (b *Builder) Mul() *Builder { return b.Op("mul") }
+
+// MulImm adds a "mul" operation, with an immediate value.
+func (b *Builder) MulImm(v int) *Builder { return b.Imm(v).Op("mul") }
+
+// Div adds a "div" operation.
+func (b *Builder) Div() *Builder { return b.Op("div") }
+
+// DivImm adds a "div" operation, with an immediate value.
+func (b *Builder) DivImm(v int) *Builder { return b.Imm(v).Op("div") }
+
+// Mod adds a "mod" operation.
+func (b *Builder) Mod() *Builder { return b.Op("mod") }
+
+// ModImm adds a "mod" operation, with an immediate value.
+func (b *Builder) ModImm(v int) *Builder { return b.Imm(v).Op("mod") }
+
+// Divmod adds a "divmod" operation.
+func (b *Builder) Divmod() *Builder { return b.Op("divmod") }
+
+// DivmodImm adds a "divmod" operation, with an immediate value.
+func (b *Builder) DivmodImm(v int) *Builder { return b.Imm(v).Op("divmod") }
+
+// Neg adds a "neg" operation.
+func (b *Builder) Neg() *Builder { return b.Op("neg") }
+
+// Lt adds a "lt" operation.
+func (b *Builder) Lt() *Builder { return b.Op("lt") }
+
+// LtImm adds a "lt" operation, with an immediate value.
+func (b *Builder) LtImm(v int) *Builder { return b.Imm(v).Op("lt") }
+
+// Lte adds a "lte" operation.
+func (b *Builder) Lte() *Builder { return b.Op("lte") }
+
+// LteImm adds a "lte" operation, with an immediate value.
+func (b *Builder) LteImm(v int) *Builder { return b.Imm(v).Op("lte") }
+
+// Gt adds a "gt" operation.
+func (b *Builder) Gt() *Builder { return b.Op("gt") }
+
+// GtImm adds a "gt" operation, with an immediate value.
+func (b *Builder) GtImm(v int) *Builder { return b.Imm(v).Op("gt") }
+
+// Gte adds a "gte" operation.
+func (b *Builder) Gte() *Builder { return b.Op("gte") }
+
+// GteImm adds a "gte" operation, with an immediate value.
+func (b *Builder) GteImm(v int) *Builder { return b.Imm(v).Op("gte") }
+
+// Eq adds a "eq" operation.
+func (b *Builder) Eq() *Builder { return b.Op("eq") }
+
+// EqImm adds a "eq" operation, with an immediate value.
+func (b *Builder) EqImm(v int) *Builder { return b.Imm(v).Op("eq") }
+
+// Neq adds a "neq" operation.
+func (b *Builder) Neq() *Builder { return b.Op("neq") }
+
+// NeqImm adds a "neq" operation, with an immediate value.
+func (b *Builder) NeqImm(v int) *Builder { return b.Imm(v).Op("neq") }
+
+// Not adds a "not" operation.
+func (b *Builder) Not() *Builder { return b.Op("not") }
+
+// And adds a "and" operation.
+func (b *Builder) And() *Builder { return b.Op("and") }
+
+// Or adds a "or" operation.
+func (b *Builder) Or() *Builder { return b.Op("or") }
+
+// Cpush adds a "cpush" operation.
+func (b *Builder) Cpush() *Builder { return b.Op("cpush") }
+
+// CpushImm adds a "cpush" operation, with an immediate value.
+func (b *Builder) CpushImm(v int) *Builder { return b.Imm(v).Op("cpush") }
+
+// Cpop adds a "cpop" operation.
+func (b *Builder) Cpop() *Builder { return b.Op("cpop") }
+
+// CpopImm adds a "cpop" operation, with an immediate value.
+func (b *Builder) CpopImm(v int) *Builder { return b.Imm(v).Op("cpop") }
+
+// P2c adds a "p2c" operation.
+func (b *Builder) P2c() *Builder { return b.Op("p2c") }
+
+// P2cImm adds a "p2c" operation, with an immediate value.
+func (b *Builder) P2cImm(v int) *Builder { return b.Imm(v).Op("p2c") }
+
+// C2p adds a "c2p" operation.
+func (b *Builder) C2p() *Builder { return b.Op("c2p") }
+
+// C2pImm adds a "c2p" operation, with an immediate value.
+func (b *Builder) C2pImm(v int) *Builder { return b.Imm(v).Op("c2p") }
+
+// Mark adds a "mark" operation.
+func (b *Builder) Mark() *Builder { return b.Op("mark") }
+
+// Jump adds a "jump" operation.
+func (b *Builder) Jump() *Builder { return b.Op("jump") }
+
+// JumpImm adds a "jump" operation, with an immediate value.
+func (b *Builder) JumpImm(v int) *Builder { return b.Imm(v).Op("jump") }
+
+// JumpTo adds a "jump" operation, referencing the given label.
+func (b *Builder) JumpTo(label string) *Builder { return b.Ref(label).Op("jump") }
+
+// Jnz adds a "jnz" operation.
+func (b *Builder) Jnz() *Builder { return b.Op("jnz") }
+
+// JnzImm adds a "jnz" operation, with an immediate value.
+func (b *Builder) JnzImm(v int) *Builder { return b.Imm(v).Op("jnz") }
+
+// JnzTo adds a "jnz" operation, referencing the given label.
+func (b *Builder) JnzTo(label string) *Builder { return b.Ref(label).Op("jnz") }
+
+// Jz adds a "jz" operation.
+func (b *Builder) Jz() *Builder { return b.Op("jz") }
+
+// JzImm adds a "jz" operation, with an immediate value.
+func (b *Builder) JzImm(v int) *Builder { return b.Imm(v).Op("jz") }
+
+// JzTo adds a "jz" operation, referencing the given label.
+func (b *Builder) JzTo(label string) *Builder { return b.Ref(label).Op("jz") }
+
+// Call adds a "call" operation.
+func (b *Builder) Call() *Builder { return b.Op("call") }
+
+// CallImm adds a "call" operation, with an immediate value.
+func (b *Builder) CallImm(v int) *Builder { return b.Imm(v).Op("call") }
+
+// CallAt adds a "call" operation, referencing the given label.
+func (b *Builder) CallAt(label string) *Builder { return b.Ref(label).Op("call") }
+
+// Ret adds a "ret" operation.
+func (b *Builder) Ret() *Builder { return b.Op("ret") }
+
+// Yield adds a "yield" operation.
+func (b *Builder) Yield() *Builder { return b.Op("yield") }
+
+// YieldImm adds a "yield" operation, with an immediate value.
+func (b *Builder) YieldImm(v int) *Builder { return b.Imm(v).Op("yield") }
+
+// Yieldr adds a "yieldr" operation.
+func (b *Builder) Yieldr() *Builder { return b.Op("yieldr") }
+
+// Brk adds a "brk" operation.
+func (b *Builder) Brk() *Builder { return b.Op("brk") }
+
+// Fork adds a "fork" operation.
+func (b *Builder) Fork() *Builder { return b.Op("fork") }
+
+// ForkImm adds a "fork" operation, with an immediate value.
+func (b *Builder) ForkImm(v int) *Builder { return b.Imm(v).Op("fork") }
+
+// ForkTo adds a "fork" operation, referencing the given label.
+func (b *Builder) ForkTo(label string) *Builder { return b.Ref(label).Op("fork") }
+
+// Fnz adds a "fnz" operation.
+func (b *Builder) Fnz() *Builder { return b.Op("fnz") }
+
+// FnzImm adds a "fnz" operation, with an immediate value.
+func (b *Builder) FnzImm(v int) *Builder { return b.Imm(v).Op("fnz") }
+
+// FnzTo adds a "fnz" operation, referencing the given label.
+func (b *Builder) FnzTo(label string) *Builder { return b.Ref(label).Op("fnz") }
+
+// Fz adds a "fz" operation.
+func (b *Builder) Fz() *Builder { return b.Op("fz") }
+
+// FzImm adds a "fz" operation, with an immediate value.
+func (b *Builder) FzImm(v int) *Builder { return b.Imm(v).Op("fz") }
+
+// FzTo adds a "fz" operation, referencing the given label.
+func (b *Builder) FzTo(label string) *Builder { return b.Ref(label).Op("fz") }
+
+// Branch adds a "branch" operation.
+func (b *Builder) Branch() *Builder { return b.Op("branch") }
+
+// BranchImm adds a "branch" operation, with an immediate value.
+func (b *Builder) BranchImm(v int) *Builder { return b.Imm(v).Op("branch") }
+
+// BranchTo adds a "branch" operation, referencing the given label.
+func (b *Builder) BranchTo(label string) *Builder { return b.Ref(label).Op("branch") }
+
+// Bnz adds a "bnz" operation.
+func (b *Builder) Bnz() *Builder { return b.Op("bnz") }
+
+// BnzImm adds a "bnz" operation, with an immediate value.
+func (b *Builder) BnzImm(v int) *Builder { return b.Imm(v).Op("bnz") }
+
+// BnzTo adds a "bnz" operation, referencing the given label.
+func (b *Builder) BnzTo(label string) *Builder { return b.Ref(label).Op("bnz") }
+
+// Bz adds a "bz" operation.
+func (b *Builder) Bz() *Builder { return b.Op("bz") }
+
+// BzImm adds a "bz" operation, with an immediate value.
+func (b *Builder) BzImm(v int) *Builder { return b.Imm(v).Op("bz") }
+
+// BzTo adds a "bz" operation, referencing the given label.
+func (b *Builder) BzTo(label string) *Builder { return b.Ref(label).Op("bz") }
+
+// Bitnot adds a "bitnot" operation.
+func (b *Builder) Bitnot() *Builder { return b.Op("bitnot") }
+
+// Bitand adds a "bitand" operation.
+func (b *Builder) Bitand() *Builder { return b.Op("bitand") }
+
+// BitandImm adds a "bitand" operation, with an immediate value.
+func (b *Builder) BitandImm(v int) *Builder { return b.Imm(v).Op("bitand") }
+
+// Bitor adds a "bitor" operation.
+func (b *Builder) Bitor() *Builder { return b.Op("bitor") }
+
+// BitorImm adds a "bitor" operation, with an immediate value.
+func (b *Builder) BitorImm(v int) *Builder { return b.Imm(v).Op("bitor") }
+
+// Bitxor adds a "bitxor" operation.
+func (b *Builder) Bitxor() *Builder { return b.Op("bitxor") }
+
+// BitxorImm adds a "bitxor" operation, with an immediate value.
+func (b *Builder) BitxorImm(v int) *Builder { return b.Imm(v).Op("bitxor") }
+
+// Shiftl adds a "shiftl" operation.
+func (b *Builder) Shiftl() *Builder { return b.Op("shiftl") }
+
+// ShiftlImm adds a "shiftl" operation, with an immediate value.
+func (b *Builder) ShiftlImm(v int) *Builder { return b.Imm(v).Op("shiftl") }
+
+// Shiftr adds a "shiftr" operation.
+func (b *Builder) Shiftr() *Builder { return b.Op("shiftr") }
+
+// ShiftrImm adds a "shiftr" operation, with an immediate value.
+func (b *Builder) ShiftrImm(v int) *Builder { return b.Imm(v).Op("shiftr") }
+
+// Bitest adds a "bitest" operation.
+func (b *Builder) Bitest() *Builder { return b.Op("bitest") }
+
+// BitestImm adds a "bitest" operation, with an immediate value.
+func (b *Builder) BitestImm(v int) *Builder { return b.Imm(v).Op("bitest") }
+
+// BitestAt adds a "bitest" operation, referencing the given label.
+func (b *Builder) BitestAt(label string) *Builder { return b.Ref(label).Op("bitest") }
+
+// Bitset adds a "bitset" operation.
+func (b *Builder) Bitset() *Builder { return b.Op("bitset") }
+
+// BitsetImm adds a "bitset" operation, with an immediate value.
+func (b *Builder) BitsetImm(v int) *Builder { return b.Imm(v).Op("bitset") }
+
+// BitsetAt adds a "bitset" operation, referencing the given label.
+func (b *Builder) BitsetAt(label string) *Builder { return b.Ref(label).Op("bitset") }
+
+// Bitost adds a "bitost" operation.
+func (b *Builder) Bitost() *Builder { return b.Op("bitost") }
+
+// BitostImm adds a "bitost" operation, with an immediate value.
+func (b *Builder) BitostImm(v int) *Builder { return b.Imm(v).Op("bitost") }
+
+// BitostAt adds a "bitost" operation, referencing the given label.
+func (b *Builder) BitostAt(label string) *Builder { return b.Ref(label).Op("bitost") }
+
+// Bitseta adds a "bitseta" operation.
+func (b *Builder) Bitseta() *Builder { return b.Op("bitseta") }
+
+// BitsetaImm adds a "bitseta" operation, with an immediate value.
+func (b *Builder) BitsetaImm(v int) *Builder { return b.Imm(v).Op("bitseta") }
+
+// BitsetaAt adds a "bitseta" operation, referencing the given label.
+func (b *Builder) BitsetaAt(label string) *Builder { return b.Ref(label).Op("bitseta") }
+
+// Bitosta adds a "bitosta" operation.
+func (b *Builder) Bitosta() *Builder { return b.Op("bitosta") }
+
+// BitostaImm adds a "bitosta" operation, with an immediate value.
+func (b *Builder) BitostaImm(v int) *Builder { return b.Imm(v).Op("bitosta") }
+
+// BitostaAt adds a "bitosta" operation, referencing the given label.
+func (b *Builder) BitostaAt(label string) *Builder { return b.Ref(label).Op("bitosta") }
+
+// Hnz adds a "hnz" operation.
+func (b *Builder) Hnz() *Builder { return b.Op("hnz") }
+
+// HnzImm adds a "hnz" operation, with an immediate value.
+func (b *Builder) HnzImm(v int) *Builder { return b.Imm(v).Op("hnz") }
+
+// Hz adds a "hz" operation.
+func (b *Builder) Hz() *Builder { return b.Op("hz") }
+
+// HzImm adds a "hz" operation, with an immediate value.
+func (b *Builder) HzImm(v int) *Builder { return b.Imm(v).Op("hz") }
+
+// Halt adds a "halt" operation.
+func (b *Builder) Halt() *Builder { return b.Op("halt") }
+
+// HaltImm adds a "halt" operation, with an immediate value.
+func (b *Builder) HaltImm(v int) *Builder { return b.Imm(v).Op("halt") }
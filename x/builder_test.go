@@ -0,0 +1,57 @@
+package xstackvm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestBuilder(t *testing.T) {
+	var lib Builder
+	lib.Label("double").MulImm(2).Ret()
+
+	var b Builder
+	b.Data().Out("sum").Word(0).
+		Entry("main").
+		PushImm(0).PushImm(3). // sum i :
+		Label("loop").
+		Dup().CallAt("double"). // sum i 2*i :
+		Swap().P2c().           // sum 2*i : i
+		Add().                  // sum+2*i : i
+		C2p().SubImm(1).        // sum i-1 :
+		Dup().JnzTo("loop").
+		Pop().StoreToAt("sum").
+		HaltImm(0).
+		Include(&lib)
+
+	toks := []interface{}{
+		".data", ".out", "sum:", 0,
+		".entry", "main:",
+		0, "push", 3, "push",
+		"loop:",
+		"dup", ":double", "call",
+		"swap", "p2c",
+		"add",
+		"c2p", 1, "sub",
+		"dup", ":loop", "jnz",
+		"pop", ":sum", "storeTo",
+		0, "halt",
+		".include", []interface{}{"double:", 2, "mul", "ret"},
+	}
+	assert.Equal(t, toks, b.Tokens(), "expected the same token stream")
+
+	prog, err := b.Assemble()
+	require.NoError(t, err, "unexpected assemble error")
+	assert.Equal(t, MustAssemble(toks...), prog, "expected the same program")
+
+	m, err := stackvm.New(prog)
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+	assert.Equal(t, map[string][]uint32{"sum": {2 * (3 + 2 + 1)}}, vals)
+}
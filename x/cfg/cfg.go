@@ -0,0 +1,431 @@
+// Package cfg extracts the control flow graph of a stackvm program: its basic
+// blocks, and the edges between them, annotated with any labels, spans, and
+// source positions from the program's debug info. Graphs may be written as
+// Graphviz DOT, or JSON, e.g. to review generated programs, or to spot
+// unreachable code.
+package cfg
+
+import (
+	"sort"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// Graph is the control flow graph of a program.
+type Graph struct {
+	Entry  uint32   `json:"entry"`
+	Blocks []*Block `json:"blocks"` // sorted by address
+	Edges  []Edge   `json:"edges"`  // sorted by source, then destination
+
+	byAddr map[uint32]*Block
+}
+
+// Block is a basic block: a run of ops entered only at its start, that ends
+// with an op that may transfer control, or before the start of another block.
+type Block struct {
+	Addr      uint32   `json:"addr"`
+	End       uint32   `json:"end"` // address after its last op
+	Ops       []Op     `json:"ops"`
+	Labels    []string `json:"labels,omitempty"`  // defined at Addr
+	Spans     []string `json:"spans,omitempty"`   // open at Addr, outermost first
+	Source    string   `json:"source,omitempty"`  // where Addr was assembled from
+	Reachable bool     `json:"reachable"`         // from the program's entry
+	Dynamic   bool     `json:"dynamic,omitempty"` // ends by transferring control to a popped address
+}
+
+// Op is an op within a block.
+type Op struct {
+	Site   uint32   `json:"site"`
+	Next   uint32   `json:"next"`
+	Code   string   `json:"op"` // e.g. "3 push"
+	Labels []string `json:"labels,omitempty"`
+
+	op stackvm.Op
+}
+
+// Op returns the decoded op.
+func (op Op) Op() stackvm.Op { return op.op }
+
+// EdgeKind is how control passes along an Edge.
+type EdgeKind string
+
+// Edge kinds; a call has both a call edge, to the called routine, and a
+// return edge to the op after it, which is also the target of a ret edge from
+// every block that may return from the routine.
+const (
+	EdgeNext   EdgeKind = "next"   // falls through, or continues after a fork or branch
+	EdgeJump   EdgeKind = "jump"   // unconditional jump
+	EdgeCond   EdgeKind = "cond"   // conditional jump, when taken
+	EdgeCall   EdgeKind = "call"   // call of a routine
+	EdgeReturn EdgeKind = "return" // continues after a call returns
+	EdgeRet    EdgeKind = "ret"    // returns from a routine
+	EdgeFork   EdgeKind = "fork"   // a copy of the machine continues here
+	EdgeBranch EdgeKind = "branch" // continues here, while a copy continues next
+)
+
+// Edge is a possible transfer of control between two blocks.
+type Edge struct {
+	From uint32   `json:"from"`
+	To   uint32   `json:"to"`
+	Kind EdgeKind `json:"kind"`
+}
+
+// Block returns the block starting at the given address, if any.
+func (g *Graph) Block(addr uint32) *Block { return g.byAddr[addr] }
+
+// Succs returns the edges out of the block at the given address.
+func (g *Graph) Succs(addr uint32) []Edge {
+	i := sort.Search(len(g.Edges), func(i int) bool { return g.Edges[i].From >= addr })
+	j := i
+	for j < len(g.Edges) && g.Edges[j].From == addr {
+		j++
+	}
+	return g.Edges[i:j]
+}
+
+// Preds returns the edges into the block at the given address.
+func (g *Graph) Preds(addr uint32) (preds []Edge) {
+	for _, e := range g.Edges {
+		if e.To == addr {
+			preds = append(preds, e)
+		}
+	}
+	return preds
+}
+
+// Unreachable returns the blocks that aren't reachable from the entry.
+func (g *Graph) Unreachable() (blks []*Block) {
+	for _, blk := range g.Blocks {
+		if !blk.Reachable {
+			blks = append(blks, blk)
+		}
+	}
+	return blks
+}
+
+// Build decodes a program's control flow graph.
+//
+// Blocks are found by following the program's static control flow from its
+// entry; code reached only dynamically, e.g. by a jump to a popped address, is
+// missed. Since programs don't distinguish code from data, other labeled
+// addresses are only decoded as (unreachable) code if they're not within an
+// input or output region, don't just close a span, and no reachable op refers
+// to them as data.
+func Build(prog []byte) (*Graph, error) {
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	if err != nil {
+		return nil, err
+	}
+	b := builder{
+		m:       m,
+		dbg:     dbg,
+		leaders: make(map[uint32]bool),
+		reached: make(map[uint32]bool),
+	}
+	b.g.Entry = m.IP()
+	b.g.byAddr = make(map[uint32]*Block)
+
+	b.explore(b.g.Entry, b.reached)
+	roots, err := b.deadRoots()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range roots {
+		b.explore(addr, make(map[uint32]bool))
+	}
+	b.blocks()
+	b.spans()
+	b.edges()
+	return &b.g, nil
+}
+
+type builder struct {
+	m       *stackvm.Mach
+	dbg     stackvm.DebugInfo
+	g       Graph
+	leaders map[uint32]bool
+	reached map[uint32]bool // addresses of ops reachable from the entry
+	dataRef map[uint32]bool // immediates of reachable non-control ops
+}
+
+// isControl returns true if the op transfers control to its immediate.
+func isControl(op stackvm.Op) bool {
+	return op.RelativeRef() || op.Name() == "call"
+}
+
+// target returns the static target of a control op, if it has one.
+func target(op stackvm.Op, next uint32) (uint32, bool) {
+	switch {
+	case !op.Have:
+		return 0, false
+	case op.RelativeRef():
+		return uint32(int32(next) + int32(op.Arg)), true
+	case op.Name() == "call":
+		return op.Arg, true
+	}
+	return 0, false
+}
+
+// successors returns the edges that control may statically take after the op
+// that ends a block; dynamic is true if it may also take one to a popped
+// address.
+func successors(op stackvm.Op, next uint32) (succs []Edge, dynamic bool) {
+	targ, static := target(op, next)
+	add := func(kind EdgeKind, to uint32) { succs = append(succs, Edge{To: to, Kind: kind}) }
+	name := op.Name()
+	switch name {
+	case "crash", "ret", "halt":
+	case "jump":
+	case "call":
+		add(EdgeReturn, next)
+	default:
+		add(EdgeNext, next)
+	}
+	kind := EdgeKind("")
+	switch name {
+	case "jump":
+		kind = EdgeJump
+	case "jnz", "jz":
+		kind = EdgeCond
+	case "call":
+		kind = EdgeCall
+	case "fork", "fnz", "fz":
+		kind = EdgeFork
+	case "branch", "bnz", "bz":
+		kind = EdgeBranch
+	}
+	if kind != "" {
+		if static {
+			add(kind, targ)
+		} else {
+			dynamic = true
+		}
+	}
+	return succs, dynamic
+}
+
+// explore decodes the code reachable from an address, marking block leaders,
+// and the address of every op decoded in seen.
+func (b *builder) explore(addr uint32, seen map[uint32]bool) {
+	b.leaders[addr] = true
+	pending := []uint32{addr}
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for !seen[addr] {
+			seen[addr] = true
+			op, next, err := b.m.ReadOp(addr)
+			if err != nil {
+				break
+			}
+			if !op.EndsBlock() {
+				addr = next
+				continue
+			}
+			succs, _ := successors(op, next)
+			for _, e := range succs {
+				b.leaders[e.To] = true
+				pending = append(pending, e.To)
+			}
+			break
+		}
+	}
+}
+
+// deadRoots returns the labeled addresses that may be unreachable code.
+func (b *builder) deadRoots() ([]uint32, error) {
+	if b.dbg == nil {
+		return nil, nil
+	}
+
+	ins, err := b.m.Inputs()
+	if err != nil {
+		return nil, err
+	}
+	outs, err := b.m.Outputs()
+	if err != nil {
+		return nil, err
+	}
+	regions := append(ins, outs...)
+
+	b.dataRef = make(map[uint32]bool)
+	for addr := range b.reached {
+		if op, _, err := b.m.ReadOp(addr); err == nil && op.Have && !isControl(op) {
+			b.dataRef[op.Arg] = true
+		}
+	}
+
+	var roots []uint32
+addrs:
+	for _, addr := range b.dbg.LabeledAddrs() {
+		if b.reached[addr] || b.dataRef[addr] || addr <= b.m.CBP() {
+			continue
+		}
+		if open, close := b.dbg.Span(addr); close && !open {
+			continue // the end of a span, not the start of code
+		}
+		for _, rg := range regions {
+			if rg.From <= addr && addr < rg.To {
+				continue addrs
+			}
+		}
+		roots = append(roots, addr)
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+	return roots, nil
+}
+
+// blocks decodes the block at every leader.
+func (b *builder) blocks() {
+	addrs := make([]uint32, 0, len(b.leaders))
+	for addr := range b.leaders {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	for _, addr := range addrs {
+		blk := &Block{Addr: addr, End: addr, Reachable: b.reached[addr]}
+		for {
+			op, next, err := b.m.ReadOp(addr)
+			if err != nil {
+				break
+			}
+			bop := Op{Site: addr, Next: next, Code: op.String(), op: op}
+			if b.dbg != nil {
+				bop.Labels = b.dbg.Labels(addr)
+			}
+			blk.Ops = append(blk.Ops, bop)
+			blk.End = next
+			if op.EndsBlock() || b.leaders[next] {
+				break
+			}
+			addr = next
+		}
+		if len(blk.Ops) == 0 {
+			continue
+		}
+		blk.Labels = blk.Ops[0].Labels
+		if b.dbg != nil {
+			blk.Source = b.dbg.Source(blk.Addr)
+		}
+		b.g.Blocks = append(b.g.Blocks, blk)
+		b.g.byAddr[blk.Addr] = blk
+	}
+}
+
+// spans annotates each block with the spans open at its start; a span is named
+// by the first label at its opening address.
+func (b *builder) spans() {
+	if b.dbg == nil {
+		return
+	}
+	marks := b.dbg.SpanAddrs()
+	sort.Slice(marks, func(i, j int) bool { return marks[i] < marks[j] })
+	var open []string
+	for _, blk := range b.g.Blocks {
+		for ; len(marks) > 0 && marks[0] <= blk.Addr; marks = marks[1:] {
+			addr := marks[0]
+			opens, closes := b.dbg.Span(addr)
+			if closes && len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			if opens {
+				name := "span"
+				if labels := b.dbg.Labels(addr); len(labels) > 0 {
+					name = labels[0]
+				}
+				open = append(open, name)
+			}
+		}
+		if len(open) > 0 {
+			blk.Spans = append([]string(nil), open...)
+		}
+	}
+}
+
+// edges collects the edges out of every block, adding ret edges from the
+// blocks that may return from each called routine.
+func (b *builder) edges() {
+	returns := make(map[uint32][]uint32) // return sites by routine address
+	for _, blk := range b.g.Blocks {
+		last := blk.Ops[len(blk.Ops)-1]
+		var succs []Edge
+		if last.op.EndsBlock() {
+			succs, blk.Dynamic = successors(last.op, last.Next)
+		} else {
+			succs = []Edge{{To: last.Next, Kind: EdgeNext}}
+		}
+		for _, e := range succs {
+			if b.g.byAddr[e.To] == nil {
+				continue
+			}
+			e.From = blk.Addr
+			b.g.Edges = append(b.g.Edges, e)
+			if e.Kind == EdgeCall {
+				returns[e.To] = append(returns[e.To], last.Next)
+			}
+		}
+	}
+
+	b.sortEdges()
+	for routine, sites := range returns {
+		for _, blk := range b.routine(routine) {
+			last := blk.Ops[len(blk.Ops)-1]
+			if last.op.Name() != "ret" {
+				continue
+			}
+			for _, site := range sites {
+				if b.g.byAddr[site] != nil {
+					b.g.Edges = append(b.g.Edges, Edge{blk.Addr, site, EdgeRet})
+				}
+			}
+		}
+	}
+
+	b.sortEdges()
+}
+
+// sortEdges sorts edges by source, destination, and kind, dropping duplicates.
+func (b *builder) sortEdges() {
+	sort.Slice(b.g.Edges, func(i, j int) bool {
+		ei, ej := b.g.Edges[i], b.g.Edges[j]
+		if ei.From != ej.From {
+			return ei.From < ej.From
+		}
+		if ei.To != ej.To {
+			return ei.To < ej.To
+		}
+		return ei.Kind < ej.Kind
+	})
+	uniq := b.g.Edges[:0]
+	for i, e := range b.g.Edges {
+		if i == 0 || e != b.g.Edges[i-1] {
+			uniq = append(uniq, e)
+		}
+	}
+	b.g.Edges = uniq
+}
+
+// routine returns the blocks of the routine starting at the given address:
+// those reachable from it without following calls into other routines.
+func (b *builder) routine(addr uint32) (blks []*Block) {
+	seen := map[uint32]bool{addr: true}
+	pending := []uint32{addr}
+	for len(pending) > 0 {
+		blk := b.g.byAddr[pending[len(pending)-1]]
+		pending = pending[:len(pending)-1]
+		blks = append(blks, blk)
+		for _, e := range b.g.Succs(blk.Addr) {
+			switch e.Kind {
+			case EdgeCall, EdgeRet:
+				continue
+			}
+			if !seen[e.To] {
+				seen[e.To] = true
+				pending = append(pending, e.To)
+			}
+		}
+	}
+	return blks
+}
@@ -0,0 +1,97 @@
+package cfg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/cfg"
+)
+
+func TestBuild(t *testing.T) {
+	prog := MustAssemble(
+		".data",
+		".out", "r:", 0,
+		"tmp:", 0,
+
+		".entry", "main:",
+		3, "push", ":tmp", "storeTo", // :
+		"loop:", ":tmp", "fetch", // i :
+		"dup", ":even", "fnz", // i :
+		":.f", "call", // i :
+		1, "sub", "dup", ":tmp", "storeTo", // i-1 :
+		":loop", "jnz", // :
+		0, "halt",
+
+		"even:", 1, "halt",
+
+		"dead:", 2, "halt",
+
+		".spanOpen", ".f:", ":r", "fetch", "add", ":r", "storeTo", "ret", ".spanClose",
+	)
+
+	g, err := cfg.Build(prog)
+	require.NoError(t, err, "unexpected build error")
+
+	names := make(map[uint32]string)
+	addrs := make(map[string]uint32)
+	for _, blk := range g.Blocks {
+		if len(blk.Labels) > 0 {
+			names[blk.Addr] = blk.Labels[0]
+			addrs[blk.Labels[0]] = blk.Addr
+		}
+	}
+	assert.Equal(t, []string{"main"}, g.Block(g.Entry).Labels, "expected the entry block")
+	for _, name := range []string{"main", "loop", "even", "dead", ".f"} {
+		assert.Contains(t, addrs, name, "expected a block at %s", name)
+	}
+	assert.NotContains(t, addrs, "tmp", "expected no block for data")
+	assert.NotContains(t, addrs, "r", "expected no block for an output")
+
+	var unreachable []string
+	for _, blk := range g.Unreachable() {
+		unreachable = append(unreachable, blk.Labels...)
+	}
+	assert.Equal(t, []string{"dead"}, unreachable, "expected unreachable blocks")
+	assert.Equal(t, []string{".f"}, g.Block(addrs[".f"]).Spans, "expected .f within its span")
+
+	kinds := make(map[cfg.EdgeKind]int)
+	for _, e := range g.Edges {
+		kinds[e.Kind]++
+		require.NotNil(t, g.Block(e.From), "expected an edge from a block")
+		require.NotNil(t, g.Block(e.To), "expected an edge to a block")
+	}
+	assert.Equal(t, map[cfg.EdgeKind]int{
+		cfg.EdgeNext:   3, // main -> loop, fnz -> call, jnz -> halt
+		cfg.EdgeFork:   1,
+		cfg.EdgeCall:   1,
+		cfg.EdgeReturn: 1,
+		cfg.EdgeRet:    1,
+		cfg.EdgeCond:   1,
+	}, kinds, "expected edge kinds")
+
+	ret := g.Succs(addrs[".f"])
+	if assert.Len(t, ret, 1, "expected one edge out of .f") {
+		assert.Equal(t, cfg.EdgeRet, ret[0].Kind)
+		assert.Equal(t, g.Block(ret[0].To).Ops[0].Code, "1 sub", "expected .f to return after the call")
+	}
+	assert.Len(t, g.Preds(addrs["loop"]), 2, "expected loop to be entered twice")
+
+	var dot bytes.Buffer
+	require.NoError(t, g.WriteDOT(&dot), "unexpected dot error")
+	assert.Contains(t, dot.String(), "subgraph cluster_", "expected a span cluster")
+	assert.Contains(t, dot.String(), `label=".f"`, "expected a span label")
+	assert.Contains(t, dot.String(), `[label="fork" style=dashed]`, "expected a fork edge")
+	assert.Contains(t, dot.String(), `dead:\l`, "expected the dead label")
+
+	var js bytes.Buffer
+	require.NoError(t, g.WriteJSON(&js), "unexpected json error")
+	var rt cfg.Graph
+	require.NoError(t, json.Unmarshal(js.Bytes(), &rt), "unexpected json decode error")
+	assert.Equal(t, g.Edges, rt.Edges, "expected json edges to round trip")
+	assert.Equal(t, len(g.Blocks), len(rt.Blocks), "expected json blocks to round trip")
+}
@@ -0,0 +1,125 @@
+package cfg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteJSON writes the graph as indented JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. Each block is a node
+// listing its labels and ops, within nested clusters for the spans open at its
+// start; unreachable blocks are dashed, and edges are labeled by their kind,
+// other than next edges.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph cfg {\n")
+	fmt.Fprintf(bw, "\tnode [shape=box fontname=monospace];\n")
+	fmt.Fprintf(bw, "\tentry [shape=point];\n")
+	fmt.Fprintf(bw, "\tentry -> %s;\n", nodeID(g.Entry))
+
+	var root cluster
+	for _, blk := range g.Blocks {
+		root.add(blk.Spans, blk)
+	}
+	root.write(bw, "\t")
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "\t%s -> %s", nodeID(e.From), nodeID(e.To))
+		switch e.Kind {
+		case EdgeNext:
+		case EdgeJump, EdgeCall, EdgeReturn:
+			fmt.Fprintf(bw, " [label=%q]", e.Kind)
+		default:
+			fmt.Fprintf(bw, " [label=%q style=dashed]", e.Kind)
+		}
+		fmt.Fprintf(bw, ";\n")
+	}
+
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+func nodeID(addr uint32) string { return fmt.Sprintf("b%04x", addr) }
+
+// cluster is a span, and the blocks and spans within it.
+type cluster struct {
+	name   string
+	blocks []*Block
+	subs   []*cluster
+}
+
+func (c *cluster) add(spans []string, blk *Block) {
+	if len(spans) == 0 {
+		c.blocks = append(c.blocks, blk)
+		return
+	}
+	var sub *cluster
+	if n := len(c.subs); n > 0 && c.subs[n-1].name == spans[0] {
+		sub = c.subs[n-1]
+	} else {
+		sub = &cluster{name: spans[0]}
+		c.subs = append(c.subs, sub)
+	}
+	sub.add(spans[1:], blk)
+}
+
+func (c *cluster) write(w io.Writer, indent string) {
+	for _, blk := range c.blocks {
+		fmt.Fprintf(w, "%s%s [label=\"%s\"", indent, nodeID(blk.Addr), blockLabel(blk))
+		if !blk.Reachable {
+			fmt.Fprintf(w, " style=dashed color=gray fontcolor=gray")
+		}
+		fmt.Fprintf(w, "];\n")
+	}
+	for _, sub := range c.subs {
+		fmt.Fprintf(w, "%ssubgraph cluster_%s {\n", indent, nodeID(sub.blocks0()))
+		fmt.Fprintf(w, "%s\tlabel=%q;\n", indent, sub.name)
+		sub.write(w, indent+"\t")
+		fmt.Fprintf(w, "%s}\n", indent)
+	}
+}
+
+// blocks0 returns the address of the first block within the cluster, to
+// identify it.
+func (c *cluster) blocks0() uint32 {
+	if len(c.blocks) > 0 {
+		return c.blocks[0].Addr
+	}
+	return c.subs[0].blocks0()
+}
+
+// blockLabel returns the DOT label of a block, one left-justified line per
+// label, and op.
+func blockLabel(blk *Block) string {
+	var lines []string
+	for _, op := range blk.Ops {
+		for _, label := range op.Labels {
+			lines = append(lines, label+":")
+		}
+		lines = append(lines, fmt.Sprintf("  @0x%04x %s", op.Site, op.Code))
+	}
+	if blk.Dynamic {
+		lines = append(lines, "  -> ?")
+	}
+	if blk.Source != "" {
+		lines = append(lines, "# "+blk.Source)
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(dotEscaper.Replace(line))
+		buf.WriteString(`\l`)
+	}
+	return buf.String()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,417 @@
+package cfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// Depth is the depth of the parameter and control stacks, in words.
+type Depth struct {
+	Param   int `json:"param"`
+	Control int `json:"control"`
+}
+
+func (d Depth) String() string { return fmt.Sprintf("%d:%d", d.Param, d.Control) }
+
+// Effect is a declared stack effect, written "( a b -- c )": a routine with
+// it takes the values named before the "--" from atop the parameter stack,
+// and leaves those named after it in their place.
+type Effect struct {
+	In, Out []string
+}
+
+var errEffectSyntax = errors.New(`stack effect must be like "( a b -- c )"`)
+
+// ParseEffect parses a stack effect, like "( a b -- c )".
+func ParseEffect(s string) (eff Effect, err error) {
+	words := strings.Fields(s)
+	if len(words) < 3 || words[0] != "(" || words[len(words)-1] != ")" {
+		return Effect{}, errEffectSyntax
+	}
+	words = words[1 : len(words)-1]
+	sep := -1
+	for i, word := range words {
+		if word == "--" {
+			if sep >= 0 {
+				return Effect{}, errEffectSyntax
+			}
+			sep = i
+		}
+	}
+	if sep < 0 {
+		return Effect{}, errEffectSyntax
+	}
+	eff.In, eff.Out = words[:sep], words[sep+1:]
+	return eff, nil
+}
+
+func (eff Effect) String() string {
+	parts := append([]string{"("}, eff.In...)
+	parts = append(parts, "--")
+	parts = append(parts, eff.Out...)
+	parts = append(parts, ")")
+	return strings.Join(parts, " ")
+}
+
+// Routine is the stack effect of a called routine, as computed by Stack.
+type Routine struct {
+	Addr     uint32   `json:"addr"`
+	Labels   []string `json:"labels,omitempty"`
+	In       int      `json:"in"`                 // values taken from the parameter stack
+	Out      int      `json:"out"`                // values left in their place
+	Returns  bool     `json:"returns"`            // false if no path returns
+	Max      Depth    `json:"max"`                // deepest, relative to its start
+	Declared *Effect  `json:"declared,omitempty"` // its declared effect, if any
+
+	maxWords int // deepest combined, relative to its start
+}
+
+// Problem is a stack problem found by Stack at an address.
+type Problem struct {
+	Addr uint32 `json:"addr"`
+	Msg  string `json:"msg"`
+}
+
+func (p Problem) String() string { return fmt.Sprintf("@0x%04x: %s", p.Addr, p.Msg) }
+
+// StackAnalysis is the result of Stack.
+type StackAnalysis struct {
+	// Depths holds the depth of the stacks before each reachable op,
+	// relative to the start of the routine that it's within: the entry, or a
+	// called routine (for code shared by routines, the first analyzed).
+	Depths map[uint32]Depth `json:"depths"`
+
+	// Routines holds each called routine's effect, by address.
+	Routines map[uint32]*Routine `json:"routines,omitempty"`
+
+	// Max holds the deepest each stack gets from the entry, and MaxWords the
+	// deepest they get combined, including within called routines.
+	Max      Depth `json:"max"`
+	MaxWords int   `json:"maxWords"`
+
+	// Problems holds any paths that may underflow, or whose depth can't be
+	// determined, and any routines that don't match their declared effect,
+	// sorted by address.
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// StackSize returns the .stackSize needed by the program, in bytes; it's only
+// reliable if there are no Problems.
+func (sa *StackAnalysis) StackSize() uint32 {
+	if sa.MaxWords < 1 {
+		return 4
+	}
+	return 4 * uint32(sa.MaxWords)
+}
+
+func (sa *StackAnalysis) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "max depth %v (%d words), needs .stackSize %d\n", sa.Max, sa.MaxWords, sa.StackSize())
+	addrs := make([]uint32, 0, len(sa.Routines))
+	for addr := range sa.Routines {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		r := sa.Routines[addr]
+		fmt.Fprintf(&buf, "routine @0x%04x %v takes %d leaves %d max %v\n", r.Addr, r.Labels, r.In, r.Out, r.Max)
+	}
+	for _, p := range sa.Problems {
+		fmt.Fprintf(&buf, "%v\n", p)
+	}
+	return buf.String()
+}
+
+// Stack computes the depth of the parameter and control stacks throughout
+// the reachable code, by abstract interpretation: each op's effect on them is
+// applied along every path from the entry, and from the start of every called
+// routine; each call applies its routine's effect. The effects map may
+// declare the effect of routines, by any label at their start; calls then
+// apply the declared effect, which is checked against the routine's code.
+//
+// Stack problems are those that would be a stack range error when run, or
+// where a stack is used before anything was put onto it; and paths whose depth
+// is inconsistent where they join (e.g. a loop that grows the stack), or can't
+// be determined (e.g. a recursive call).
+func (g *Graph) Stack(effects map[string]Effect) *StackAnalysis {
+	an := stackAnalyzer{
+		g: g,
+		sa: &StackAnalysis{
+			Depths:   make(map[uint32]Depth),
+			Routines: make(map[uint32]*Routine),
+		},
+		effects: make(map[uint32]Effect),
+		active:  make(map[uint32]bool),
+	}
+	for _, blk := range g.Blocks {
+		for _, label := range blk.Labels {
+			if eff, declared := effects[label]; declared {
+				an.effects[blk.Addr] = eff
+			}
+		}
+	}
+
+	if g.Block(g.Entry) != nil {
+		top := an.analyze(g.Entry, true)
+		an.sa.Max, an.sa.MaxWords = top.Max, top.maxWords
+	}
+
+	sort.SliceStable(an.sa.Problems, func(i, j int) bool {
+		return an.sa.Problems[i].Addr < an.sa.Problems[j].Addr
+	})
+	return an.sa
+}
+
+type stackAnalyzer struct {
+	g       *Graph
+	sa      *StackAnalysis
+	effects map[uint32]Effect
+	active  map[uint32]bool // routines being analyzed
+}
+
+func (an *stackAnalyzer) problem(addr uint32, format string, args ...interface{}) {
+	an.sa.Problems = append(an.sa.Problems, Problem{addr, fmt.Sprintf(format, args...)})
+}
+
+// routine returns the effect of the routine at the given address, analyzing
+// it if necessary; it returns nil for a recursive call.
+func (an *stackAnalyzer) routine(addr uint32) *Routine {
+	if r := an.sa.Routines[addr]; r != nil {
+		return r
+	}
+	if an.active[addr] {
+		return nil
+	}
+	r := an.analyze(addr, false)
+	an.sa.Routines[addr] = r
+	return r
+}
+
+// underflow is a use of a stack below the start of a routine.
+type underflow struct {
+	addr  uint32
+	depth int // negative
+	have  int
+	msg   string
+}
+
+// analyze interprets the code of the routine at the given address; top is
+// true for the entry, where nothing is on the stacks.
+func (an *stackAnalyzer) analyze(addr uint32, top bool) *Routine {
+	an.active[addr] = true
+	defer delete(an.active, addr)
+
+	r := &Routine{Addr: addr, Labels: an.g.Block(addr).Labels}
+	if eff, declared := an.effects[addr]; declared {
+		r.Declared = &eff
+	}
+
+	var (
+		depths     = map[uint32]Depth{addr: {}} // at the start of each block
+		pending    = []uint32{addr}
+		unders     []underflow
+		retDepth   int
+		mismatched = make(map[uint32]bool)
+	)
+	for len(pending) > 0 {
+		blk := an.g.Block(pending[len(pending)-1])
+		pending = pending[:len(pending)-1]
+		d := depths[blk.Addr]
+
+		returns := true // false if a call at the end of the block doesn't
+		for _, op := range blk.Ops {
+			if _, seen := an.sa.Depths[op.Site]; !seen {
+				an.sa.Depths[op.Site] = d
+			}
+
+			sop := op.Op()
+			if targ, static := target(sop, op.Next); static && sop.Name() == "call" {
+				callee := an.routine(targ)
+				if callee == nil {
+					an.problem(op.Site, "recursive call; depth unknown")
+					returns = false
+					break
+				}
+				in, out := callee.In, callee.Out
+				if callee.Declared != nil {
+					in, out = len(callee.Declared.In), len(callee.Declared.Out)
+				}
+				if d.Param < in {
+					unders = append(unders, underflow{op.Site, d.Param - in, d.Param,
+						fmt.Sprintf("call takes %d params", in)})
+				}
+				r.Max.Param = maxInt(r.Max.Param, d.Param+callee.Max.Param)
+				r.Max.Control = maxInt(r.Max.Control, d.Control+1+callee.Max.Control)
+				r.maxWords = maxInt(r.maxWords, d.Param+d.Control+1+callee.maxWords)
+				returns = callee.Returns
+				d.Param += out - in
+				continue
+			}
+
+			if sop.Name() == "ret" {
+				switch {
+				case top || d.Control < 0:
+					an.problem(op.Site, "control stack underflow; ret outside of any call")
+				case d.Control > 0:
+					an.problem(op.Site, "ret with %d values left on the control stack", d.Control)
+				case r.Returns && d.Param != retDepth:
+					an.problem(op.Site, "returns with parameter depth %d, but elsewhere %d", d.Param, retDepth)
+				default:
+					r.Returns, retDepth = true, d.Param
+				}
+				continue
+			}
+
+			pIn, pOut, cIn, cOut := effect(sop)
+			if d.Param < pIn {
+				unders = append(unders, underflow{op.Site, d.Param - pIn, d.Param,
+					fmt.Sprintf("%v takes %d params", sop, pIn)})
+			}
+			if d.Control < cIn {
+				an.problem(op.Site, "control stack underflow; %v takes %d values, have %d", sop, cIn, d.Control)
+			}
+			d.Param += pOut - pIn
+			d.Control += cOut - cIn
+			r.Max.Param = maxInt(r.Max.Param, d.Param)
+			r.Max.Control = maxInt(r.Max.Control, d.Control)
+			r.maxWords = maxInt(r.maxWords, d.Param+d.Control)
+		}
+
+		for _, e := range an.g.Succs(blk.Addr) {
+			switch e.Kind {
+			case EdgeCall, EdgeRet:
+				continue
+			case EdgeReturn:
+				if !returns {
+					continue
+				}
+			}
+			if prior, seen := depths[e.To]; !seen {
+				depths[e.To] = d
+				pending = append(pending, e.To)
+			} else if prior != d && !mismatched[e.To] {
+				mismatched[e.To] = true
+				an.problem(e.To, "inconsistent stack depth %v, and %v from @0x%04x", prior, d, blk.Addr)
+			}
+		}
+	}
+
+	// uses of the stack below its start are underflows at the top, or else
+	// values taken from the caller
+	limit := 0
+	if r.Declared != nil {
+		limit = -len(r.Declared.In)
+	}
+	for _, u := range unders {
+		r.In = maxInt(r.In, -u.depth)
+		switch {
+		case top:
+			an.problem(u.addr, "parameter stack underflow; %s, have %d", u.msg, u.have)
+		case r.Declared != nil && u.depth < limit:
+			an.problem(u.addr, "takes more params than declared %v", *r.Declared)
+		}
+	}
+	if r.Returns {
+		r.Out = r.In + retDepth
+	}
+	if r.Declared != nil && r.Returns &&
+		(r.In > len(r.Declared.In) || r.Out-r.In != len(r.Declared.Out)-len(r.Declared.In)) {
+		an.problem(addr, "effect takes %d leaves %d, but declared %v", r.In, r.Out, *r.Declared)
+	}
+	return r
+}
+
+// effect returns how many values an op (other than call with an immediate, or
+// ret) takes and leaves on the parameter and control stacks.
+func effect(op stackvm.Op) (pIn, pOut, cIn, cOut int) {
+	n := int(op.Arg)
+	switch op.Name() {
+	case "push":
+		return 0, 1, 0, 0
+	case "pop":
+		if op.Have {
+			return n, 0, 0, 0
+		}
+		return 1, 0, 0, 0
+	case "dup":
+		if op.Have && n > 1 {
+			return n, n + 1, 0, 0
+		}
+		return 1, 2, 0, 0
+	case "swap":
+		if op.Have {
+			return n + 1, n + 1, 0, 0
+		}
+		return 2, 2, 0, 0
+
+	case "fetch":
+		if op.Have {
+			return 0, 1, 0, 0
+		}
+		return 1, 1, 0, 0
+	case "store", "storeTo", "bitset", "bitost":
+		if op.Have {
+			return 1, 0, 0, 0
+		}
+		return 2, 0, 0, 0
+
+	case "add", "sub", "mul", "div", "mod",
+		"lt", "lte", "gt", "gte", "eq", "neq", "and", "or",
+		"bitand", "bitor", "bitxor", "shiftl", "shiftr",
+		"bitest", "bitseta", "bitosta":
+		if op.Have {
+			return 1, 1, 0, 0
+		}
+		return 2, 1, 0, 0
+	case "divmod":
+		if op.Have {
+			return 1, 2, 0, 0
+		}
+		return 2, 2, 0, 0
+	case "neg", "not", "bitnot":
+		return 1, 1, 0, 0
+
+	case "cpush", "mark":
+		return 0, 0, 0, 1
+	case "cpop":
+		if op.Have {
+			return 0, 0, n, 0
+		}
+		return 0, 0, 1, 0
+	case "p2c":
+		if op.Have {
+			return n, 0, 0, n
+		}
+		return 1, 0, 0, 1
+	case "c2p":
+		if op.Have {
+			return 0, n, n, 0
+		}
+		return 0, 1, 1, 0
+
+	case "jump", "fork", "branch", "yield":
+		if op.Have {
+			return 0, 0, 0, 0
+		}
+		return 1, 0, 0, 0
+	case "call":
+		return 1, 0, 0, 1
+	case "jnz", "jz", "fnz", "fz", "bnz", "bz", "hz", "hnz":
+		return 1, 0, 0, 0
+	case "yieldr":
+		return 2, 0, 0, 0
+	}
+	return 0, 0, 0, 0
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,154 @@
+package cfg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/cfg"
+)
+
+func TestParseEffect(t *testing.T) {
+	eff, err := cfg.ParseEffect("( a b -- c )")
+	require.NoError(t, err, "unexpected parse error")
+	assert.Equal(t, cfg.Effect{In: []string{"a", "b"}, Out: []string{"c"}}, eff)
+	assert.Equal(t, "( a b -- c )", eff.String())
+
+	eff, err = cfg.ParseEffect("(  --  )")
+	require.NoError(t, err, "unexpected parse error")
+	assert.Equal(t, "( -- )", eff.String())
+
+	for _, bad := range []string{"", "( a b )", "a -- b", "( a -- b -- c )"} {
+		_, err := cfg.ParseEffect(bad)
+		assert.Error(t, err, "expected an error parsing %q", bad)
+	}
+}
+
+func TestGraph_Stack(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		prog     []interface{}
+		effects  map[string]cfg.Effect
+		max      cfg.Depth
+		maxWords int
+		routines map[string][2]int // in, out by label
+		problems []string          // substrings of each problem
+	}{
+		{
+			name: "straight",
+			prog: []interface{}{
+				1, "push", 2, "push", 3, "push", // 1 2 3 :
+				"add", "p2c", // 1 : 5
+				"c2p", "add", "pop", 0, "halt",
+			},
+			max: cfg.Depth{Param: 3, Control: 1}, maxWords: 3,
+		},
+
+		{
+			name: "routines",
+			prog: []interface{}{
+				".entry", "main:",
+				3, "push", 4, "push", // 3 4 :
+				":sum", "call", // 7 :
+				":double", "call", // 14 :
+				"pop", 0, "halt",
+				"sum:", "add", "ret",
+				"double:", "dup", "add", "ret",
+			},
+			effects: map[string]cfg.Effect{
+				"double": {In: []string{"n"}, Out: []string{"2n"}},
+			},
+			max: cfg.Depth{Param: 2, Control: 1}, maxWords: 3,
+			routines: map[string][2]int{"sum": {2, 1}, "double": {1, 1}},
+		},
+
+		{
+			name: "underflow",
+			prog: []interface{}{
+				1, "push", "dup", "jz", // :
+				"add", 0, "halt",
+			},
+			max: cfg.Depth{Param: 2}, maxWords: 2,
+			problems: []string{"parameter stack underflow; add takes 2 params, have 1"},
+		},
+
+		{
+			name: "bad effect",
+			prog: []interface{}{
+				".entry", "main:",
+				1, "push", ":f", "call", "pop", 0, "halt",
+				"f:", "pop", "pop", "ret",
+			},
+			effects: map[string]cfg.Effect{
+				"f": {In: []string{"a"}, Out: []string{"b"}},
+			},
+			max: cfg.Depth{Param: 1, Control: 1}, maxWords: 2,
+			routines: map[string][2]int{"f": {2, 0}},
+			problems: []string{
+				"effect takes 2 leaves 0, but declared ( a -- b )",
+				"takes more params than declared ( a -- b )", // the second pop
+			},
+		},
+
+		{
+			name: "loop growth",
+			prog: []interface{}{
+				".entry", "main:",
+				"loop:", 1, "push", ":loop", "jump",
+			},
+			max: cfg.Depth{Param: 1}, maxWords: 1,
+			problems: []string{"inconsistent stack depth 0:0, and 1:0"},
+		},
+
+		{
+			name: "recursion",
+			prog: []interface{}{
+				".entry", "main:",
+				":f", "call", 0, "halt",
+				"f:", ":f", "call", "ret",
+			},
+			max: cfg.Depth{Control: 1}, maxWords: 1,
+			routines: map[string][2]int{"f": {0, 0}},
+			problems: []string{"recursive call"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := cfg.Build(MustAssemble(tc.prog...))
+			require.NoError(t, err, "unexpected build error")
+			sa := g.Stack(tc.effects)
+
+			assert.Equal(t, tc.max, sa.Max, "expected max depth")
+			assert.Equal(t, tc.maxWords, sa.MaxWords, "expected max words")
+			assert.Equal(t, uint32(4*tc.maxWords), sa.StackSize(), "expected stack size")
+
+			routines := make(map[string][2]int)
+			for _, r := range sa.Routines {
+				routines[r.Labels[0]] = [2]int{r.In, r.Out}
+			}
+			if tc.routines == nil {
+				tc.routines = map[string][2]int{}
+			}
+			assert.Equal(t, tc.routines, routines, "expected routine effects")
+
+			var problems []string
+			for _, p := range sa.Problems {
+				problems = append(problems, p.Msg)
+			}
+			if assert.Len(t, problems, len(tc.problems), "expected problems, got %q", problems) {
+				for i, want := range tc.problems {
+					assert.True(t, strings.Contains(problems[i], want), "expected problem %q, got %q", want, problems[i])
+				}
+			}
+
+			for _, blk := range g.Blocks {
+				if blk.Reachable && len(problems) == 0 {
+					_, analyzed := sa.Depths[blk.Addr]
+					assert.True(t, analyzed, "expected a depth for @0x%04x", blk.Addr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package xstackvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jcorbin/stackvm"
+)
+
+var (
+	errUnexpectedElse = errors.New("unexpected .else outside of .if")
+	errUnexpectedDo   = errors.New("unexpected .do outside of .while")
+	errUnexpectedEnd  = errors.New("unexpected .end outside of a block")
+)
+
+// block is an open structured control-flow construct; each is lowered into
+// jumps or forks between generated labels, within a span named by its label:
+//
+//	.if ... [.else ...] .end           ; pops a condition, runs one branch
+//	.while ... .do ... .end            ; loops while the condition is non-zero
+//	.forkeach N ... .end               ; forks a copy for each i in [0, N)
+//
+// For example, ".if" is lowered to ".if.1:" (opening a span), followed by
+// ":.if.1.else", "jz"; its ".end" then defines ".if.1.end:" and closes the
+// span.
+type block struct {
+	kind  string
+	label string
+}
+
+func (sc *scanner) openBlock(kind string) (string, error) {
+	label := sc.prog.genLabel("." + kind)
+	if err := sc.handleLabel(label); err != nil {
+		return "", err
+	}
+	sc.beginSpan(label)
+	sc.blocks = append(sc.blocks, block{kind, label})
+	return label, nil
+}
+
+func (sc *scanner) topBlock() *block {
+	if i := len(sc.blocks) - 1; i >= 0 {
+		return &sc.blocks[i]
+	}
+	return nil
+}
+
+func (sc *scanner) addJump(opName, label string) {
+	tok := opToken(mustResolveOp(opName, 0, true))
+	tok.str = label
+	sc.addProgRef(tok, label, 0)
+	sc.refLabel(label)
+}
+
+func (sc *scanner) addOp(name string, arg uint32, have bool) {
+	sc.addProgTok(opToken(mustResolveOp(name, arg, have)))
+}
+
+func mustResolveOp(name string, arg uint32, have bool) stackvm.Op {
+	op, err := stackvm.ResolveOp(name, arg, have)
+	if err != nil {
+		panic(err)
+	}
+	return op
+}
+
+func (sc *scanner) handleIf() error {
+	label, err := sc.openBlock("if")
+	if err == nil {
+		sc.addJump("jz", label+".else")
+	}
+	return err
+}
+
+func (sc *scanner) handleElse() error {
+	blk := sc.topBlock()
+	if blk == nil || blk.kind != "if" {
+		return errUnexpectedElse
+	}
+	blk.kind = "else"
+	sc.addJump("jump", blk.label+".end")
+	return sc.handleLabel(blk.label + ".else")
+}
+
+func (sc *scanner) handleWhile() error {
+	label, err := sc.openBlock("while")
+	if err != nil {
+		return err
+	}
+	return sc.handleLabel(label + ".top")
+}
+
+func (sc *scanner) handleDo() error {
+	blk := sc.topBlock()
+	if blk == nil || blk.kind != "while" {
+		return errUnexpectedDo
+	}
+	blk.kind = "do"
+	sc.addJump("jz", blk.label+".end")
+	return nil
+}
+
+func (sc *scanner) handleForkEach() error {
+	n, err := sc.expectInt(".forkeach N")
+	if err != nil {
+		return err
+	}
+	if n < 1 {
+		return fmt.Errorf("invalid .forkeach %v, must be positive", n)
+	}
+	label, err := sc.openBlock("forkeach")
+	if err != nil {
+		return err
+	}
+	sc.addOp("push", 0, true) // i=0 :
+	sc.addJump("jump", label+".loop")
+	if err := sc.handleLabel(label + ".next"); err != nil {
+		return err
+	}
+	sc.addOp("add", 1, true) // i++ :
+	if err := sc.handleLabel(label + ".loop"); err != nil {
+		return err
+	}
+	sc.addOp("dup", 0, false)         // i i :
+	sc.addOp("lt", uint32(n-1), true) // i i<N-1 :
+	sc.addJump("fnz", label+".next")  // i :   -- fork next
+	return nil
+}
+
+func (sc *scanner) handleEnd() error {
+	blk := sc.topBlock()
+	if blk == nil {
+		return errUnexpectedEnd
+	}
+	switch blk.kind {
+	case "if":
+		if err := sc.handleLabel(blk.label + ".else"); err != nil {
+			return err
+		}
+	case "while":
+		return fmt.Errorf("unexpected .end in .while, expected .do")
+	case "do":
+		sc.addJump("jump", blk.label+".top")
+	}
+	if err := sc.handleLabel(blk.label + ".end"); err != nil {
+		return err
+	}
+	sc.blocks = sc.blocks[:len(sc.blocks)-1]
+	sc.addSpanClose("spanClose")
+	return nil
+}
+
+func (sc *scanner) checkBlocks() error {
+	if blk := sc.topBlock(); blk != nil {
+		return fmt.Errorf("unclosed .%s block %q", blk.kind, blk.label)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package xstackvm_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_control(t *testing.T) {
+	prog, err := Assemble(
+		".data",
+		".out", "r:", 0, 0, 0,
+		".out", "s:", 0,
+		".out", "f:", 0,
+
+		".entry", "main:",
+		1, "push", ".if", 10, "push", ".else", 20, "push", ".end", ":r", "storeTo",
+		0, "push", ".if", 10, "push", ".else", 20, "push", ".end", ":r+4", "storeTo",
+		0, "push", ".if", 7, "push", ":r+8", "storeTo", ".end",
+
+		5, "push", // i :
+		".while", "dup", ".do", // i :
+		"dup", ":s", "fetch", "add", ":s", "storeTo", // i :   -- s += i
+		1, "sub", // i-1 :
+		".end", "pop",
+
+		".forkeach", 2, // i :
+		".forkeach", 3, // i j :
+		"swap", 3, "mul", "add", ":f", "storeTo", // :   -- f = 3*i + j
+		".end",
+		".end",
+		0, "halt",
+	)
+	require.NoError(t, err, "unexpected assemble error")
+
+	var (
+		dbg stackvm.DebugInfo
+		fs  []int
+	)
+	m, err := stackvm.New(prog,
+		stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }),
+		stackvm.Handler(stackvm.MachHandlerFunc(func(m *stackvm.Mach) error {
+			if err := m.Err(); err != nil {
+				return err
+			}
+			vals, err := m.NamedValues()
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, []uint32{10, 20, 0}, vals["r"], "expected .if results")
+			assert.Equal(t, []uint32{15}, vals["s"], "expected .while result")
+			fs = append(fs, int(vals["f"][0]))
+			return nil
+		})))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	sort.Ints(fs)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, fs, "expected a result for each .forkeach")
+
+	addrs := make(map[string]uint32)
+	for _, addr := range dbg.LabeledAddrs() {
+		for _, label := range dbg.Labels(addr) {
+			addrs[label] = addr
+		}
+	}
+	for _, label := range []string{".if.1", ".if.2", ".if.3", ".while.1", ".forkeach.1", ".forkeach.2"} {
+		addr, defined := addrs[label]
+		if assert.True(t, defined, "expected %q label", label) {
+			open, _ := dbg.Span(addr)
+			assert.True(t, open, "expected %q to open a span", label)
+		}
+		addr, defined = addrs[label+".end"]
+		if assert.True(t, defined, "expected %q label", label+".end") {
+			_, close := dbg.Span(addr)
+			assert.True(t, close, "expected %q to close a span", label+".end")
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		in   []interface{}
+		err  string
+	}{
+		{"stray else", []interface{}{".else"}, "unexpected .else outside of .if"},
+		{"stray do", []interface{}{".do"}, "unexpected .do outside of .while"},
+		{"stray end", []interface{}{".end"}, "unexpected .end outside of a block"},
+		{"double else", []interface{}{".if", ".else", ".else", ".end"}, "unexpected .else outside of .if"},
+		{"while without do", []interface{}{".while", ".end"}, "unexpected .end in .while, expected .do"},
+		{"unclosed", []interface{}{".if", "nop"}, `unclosed .if block ".if.1"`},
+		{"unclosed include", []interface{}{".include", []interface{}{".while"}, "nop"}, `unclosed .while block ".while.1"`},
+		{"empty forkeach", []interface{}{".forkeach", 0, ".end"}, "invalid .forkeach 0, must be positive"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Assemble(c.in...)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+var errNoContentLength = errors.New("missing Content-Length header")
+
+// message is the union of the DAP request, response, and event types.
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+// conn reads and writes base protocol messages: a Content-Length header
+// followed by a JSON body.
+type conn struct {
+	r   *textproto.Reader
+	br  *bufio.Reader
+	w   io.Writer
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	br := bufio.NewReader(rw)
+	return &conn{
+		r:  textproto.NewReader(br),
+		br: br,
+		w:  rw,
+	}
+}
+
+func (c *conn) read() (*message, error) {
+	hdr, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	cl := hdr.Get("Content-Length")
+	if cl == "" {
+		return nil, errNoContentLength
+	}
+	n, err := strconv.Atoi(cl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q: %v", cl, err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.br, buf); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(buf, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	c.seq++
+	msg.Seq = c.seq
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(buf)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(buf)
+	return err
+}
+
+func (c *conn) respond(req *message, body interface{}, err error) error {
+	ok := err == nil
+	resp := &message{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &ok,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	return c.write(resp)
+}
+
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(&message{Type: "event", Event: name, Body: body})
+}
+
+// capabilities is the subset of DAP capabilities supported by the server.
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsFunctionBreakpoints      bool `json:"supportsFunctionBreakpoints"`
+	SupportsInstructionBreakpoints   bool `json:"supportsInstructionBreakpoints"`
+}
+
+type launchArgs struct {
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+type threadArgs struct {
+	ThreadID int `json:"threadId"`
+}
+
+type frameArgs struct {
+	FrameID int `json:"frameId"`
+}
+
+type variablesArgs struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type functionBreakpointsArgs struct {
+	Breakpoints []struct {
+		Name string `json:"name"`
+	} `json:"breakpoints"`
+}
+
+type instructionBreakpointsArgs struct {
+	Breakpoints []struct {
+		InstructionReference string `json:"instructionReference"`
+		Offset               int    `json:"offset"`
+	} `json:"breakpoints"`
+}
+
+type sourceBreakpointsArgs struct {
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	ID       int    `json:"id,omitempty"`
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID                          int    `json:"id"`
+	Name                        string `json:"name"`
+	Line                        int    `json:"line"`
+	Column                      int    `json:"column"`
+	InstructionPointerReference string `json:"instructionPointerReference"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type stoppedEvent struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId,omitempty"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
+
+type threadEvent struct {
+	Reason   string `json:"reason"`
+	ThreadID int    `json:"threadId"`
+}
+
+type exitedEvent struct {
+	ExitCode int `json:"exitCode"`
+}
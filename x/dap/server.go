@@ -0,0 +1,565 @@
+// Package dap implements a Debug Adapter Protocol server for stackvm programs,
+// on top of an x/debug Session: threads are machines (the root and its forked
+// copies), stack frames come from the control stack, and variables expose the
+// stacks, registers, and named input and output regions.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/debug"
+)
+
+var (
+	errNotLaunched = errors.New("no program launched")
+	errNoThread    = errors.New("no such thread")
+)
+
+// LaunchFunc builds a debug session from the arguments of a launch request;
+// the "stopOnEntry" argument is handled by the server.
+type LaunchFunc func(args json.RawMessage) (*debug.Session, error)
+
+// Server serves DAP clients, one session per connection.
+type Server struct {
+	Launch LaunchFunc
+}
+
+// ListenAndServe listens on a local TCP address, serving each client
+// connection in turn.
+func (srv *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		err = srv.Serve(c)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve serves a single client over the given stream (e.g. stdio), until it
+// disconnects. Requests are read while the session runs, so that a client may
+// pause it, or change breakpoints.
+func (srv *Server) Serve(rw io.ReadWriter) error {
+	h := handler{conn: newConn(rw), srv: srv, stops: make(chan debug.Stop, 1)}
+
+	reqs := make(chan *message)
+	readErr := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			req, err := h.read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case st := <-h.stops:
+			h.running = false
+			if err := h.report(st); err != nil {
+				return err
+			}
+
+		case err := <-readErr:
+			if h.running {
+				h.interrupt()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+
+		case req := <-reqs:
+			if req.Type != "request" {
+				continue
+			}
+			if done, err := h.dispatch(req); err != nil || done {
+				return err
+			}
+		}
+	}
+}
+
+// variable scopes, packed into variablesReference along with a thread id
+const (
+	scopeParams = iota + 1
+	scopeControl
+	scopeRegs
+	scopeInputs
+	scopeOutputs
+	numScopes
+)
+
+type handler struct {
+	*conn
+	srv         *Server
+	sess        *debug.Session
+	stopOnEntry bool
+	fnBreaks    []int
+	insBreaks   []int
+
+	running    bool            // true while a session method runs
+	continuing bool            // true if that method is Continue
+	stops      chan debug.Stop // where it stops
+}
+
+// dispatch handles a request, first interrupting any running session method
+// unless the request is to pause it; a continue is then resumed, unless the
+// request ran the session itself.
+func (h *handler) dispatch(req *message) (done bool, err error) {
+	if !h.running {
+		return h.handle(req)
+	}
+	if req.Command == "pause" {
+		h.sess.Pause()
+		return false, h.respond(req, nil, nil)
+	}
+
+	cont := h.continuing
+	st := h.interrupt()
+	if done, err = h.handle(req); err != nil || done || h.running {
+		return done, err
+	}
+	if st.Reason == debug.StopPause && cont {
+		h.resume(h.sess.Continue, true)
+		return false, nil
+	}
+	return false, h.report(st)
+}
+
+// resume runs a session method on another goroutine, which sends where it
+// stops to h.stops; any pause requested too late to stop the last one is
+// dropped first.
+func (h *handler) resume(run func() debug.Stop, cont bool) {
+	h.sess.Unpause()
+	h.running, h.continuing = true, cont
+	go func() { h.stops <- run() }()
+}
+
+// interrupt pauses the running session method, returning where it stopped.
+func (h *handler) interrupt() debug.Stop {
+	h.sess.Pause()
+	st := <-h.stops
+	h.running = false
+	return st
+}
+
+func (h *handler) handle(req *message) (done bool, err error) {
+	var (
+		body    interface{}
+		reqErr  error
+		resumed func() debug.Stop
+		cont    bool // resumed is Continue
+	)
+
+	switch req.Command {
+	case "initialize":
+		body = capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsInstructionBreakpoints:   true,
+		}
+		if err := h.respond(req, body, nil); err != nil {
+			return false, err
+		}
+		return false, h.event("initialized", nil)
+
+	case "launch":
+		reqErr = h.launch(req.Arguments)
+
+	case "disconnect":
+		return true, h.respond(req, nil, nil)
+
+	case "setBreakpoints":
+		var args sourceBreakpointsArgs
+		if reqErr = json.Unmarshal(req.Arguments, &args); reqErr == nil {
+			bps := make([]breakpoint, len(args.Breakpoints))
+			for i := range bps {
+				bps[i].Message = "source breakpoints are not supported"
+			}
+			body = map[string]interface{}{"breakpoints": bps}
+		}
+
+	case "setFunctionBreakpoints":
+		var args functionBreakpointsArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			specs := make([]string, len(args.Breakpoints))
+			for i, bp := range args.Breakpoints {
+				specs[i] = bp.Name
+			}
+			body = map[string]interface{}{"breakpoints": h.setBreaks(&h.fnBreaks, specs)}
+		}
+
+	case "setInstructionBreakpoints":
+		var args instructionBreakpointsArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			specs := make([]string, len(args.Breakpoints))
+			for i, bp := range args.Breakpoints {
+				addr, err := strconv.ParseUint(bp.InstructionReference, 0, 32)
+				if err != nil {
+					specs[i] = bp.InstructionReference
+					continue
+				}
+				specs[i] = fmt.Sprintf("%#x", int64(addr)+int64(bp.Offset))
+			}
+			body = map[string]interface{}{"breakpoints": h.setBreaks(&h.insBreaks, specs)}
+		}
+
+	case "configurationDone":
+		if h.sess == nil {
+			reqErr = errNotLaunched
+		} else if h.stopOnEntry {
+			if err := h.respond(req, nil, nil); err != nil {
+				return false, err
+			}
+			return false, h.event("stopped", h.stopped("entry", "", nil))
+		} else {
+			resumed, cont = h.sess.Continue, true
+		}
+
+	case "threads":
+		if h.sess == nil {
+			reqErr = errNotLaunched
+		} else {
+			body = map[string]interface{}{"threads": h.threads()}
+		}
+
+	case "stackTrace":
+		var args threadArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			if t := h.sess.Thread(args.ThreadID); t == nil {
+				reqErr = errNoThread
+			} else {
+				frames := h.frames(t)
+				body = map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+			}
+		}
+
+	case "scopes":
+		var args frameArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			body = map[string]interface{}{"scopes": scopes(args.FrameID >> 16)}
+		}
+
+	case "variables":
+		var args variablesArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			var vars []variable
+			vars, reqErr = h.variables(args.VariablesReference)
+			body = map[string]interface{}{"variables": vars}
+		}
+
+	case "continue":
+		var args threadArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			reqErr = h.sess.Switch(args.ThreadID)
+		}
+		if reqErr == nil {
+			body = map[string]interface{}{"allThreadsContinued": true}
+			resumed, cont = h.sess.Continue, true
+		}
+
+	case "next", "stepIn":
+		var args threadArgs
+		if reqErr = h.unmarshal(req.Arguments, &args); reqErr == nil {
+			reqErr = h.sess.Switch(args.ThreadID)
+		}
+		if reqErr == nil {
+			if req.Command == "next" {
+				resumed = h.sess.Next
+			} else {
+				resumed = h.sess.Step
+			}
+		}
+
+	case "pause":
+		// nothing is running, else dispatch would have paused it
+
+	default:
+		reqErr = fmt.Errorf("unsupported request %q", req.Command)
+	}
+
+	if err := h.respond(req, body, reqErr); err != nil {
+		return false, err
+	}
+	if resumed != nil && reqErr == nil {
+		h.resume(resumed, cont)
+	}
+	return false, nil
+}
+
+func (h *handler) unmarshal(raw json.RawMessage, v interface{}) error {
+	if h.sess == nil {
+		return errNotLaunched
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (h *handler) launch(raw json.RawMessage) error {
+	if h.srv.Launch == nil {
+		return errors.New("launch not supported")
+	}
+	var args launchArgs
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return err
+		}
+	}
+	sess, err := h.srv.Launch(raw)
+	if err != nil {
+		return err
+	}
+	h.sess = sess
+	h.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+func (h *handler) setBreaks(ids *[]int, specs []string) []breakpoint {
+	for _, id := range *ids {
+		h.sess.Delete(id)
+	}
+	*ids = (*ids)[:0]
+	bps := make([]breakpoint, len(specs))
+	for i, spec := range specs {
+		b, err := h.sess.Break(spec)
+		if err != nil {
+			bps[i].Message = err.Error()
+			continue
+		}
+		*ids = append(*ids, b.ID)
+		bps[i] = breakpoint{ID: b.ID, Verified: true}
+	}
+	return bps
+}
+
+func (h *handler) report(st debug.Stop) error {
+	switch st.Reason {
+	case debug.StopStep:
+		return h.event("stopped", h.stopped("step", "", st.Thread))
+	case debug.StopBreak:
+		ev := h.stopped("breakpoint", st.Break.Spec, st.Thread)
+		ev.HitBreakpointIDs = []int{st.Break.ID}
+		return h.event("stopped", ev)
+	case debug.StopBrk:
+		return h.event("stopped", h.stopped("breakpoint", "brk", st.Thread))
+	case debug.StopYield:
+		vals, _ := st.Thread.Mach.Yielded()
+		return h.event("stopped", h.stopped("pause", fmt.Sprintf("yield %v", vals), st.Thread))
+	case debug.StopWatch:
+		desc := fmt.Sprintf("@0x%04x: %d -> %d", st.Watch.Addr, st.Old, st.New)
+		return h.event("stopped", h.stopped("data breakpoint", desc, st.Thread))
+	case debug.StopPause:
+		return h.event("stopped", h.stopped("pause", "", st.Thread))
+	case debug.StopExit:
+		if err := h.event("thread", threadEvent{"exited", st.Thread.ID}); err != nil {
+			return err
+		}
+		if t := h.sess.Current(); t != nil {
+			return h.event("stopped", h.stopped("step", "", t))
+		}
+	}
+
+	// done
+	code := 1
+	for _, t := range h.sess.Threads() {
+		if t.Done && t.Err == nil {
+			code = 0
+			break
+		}
+	}
+	if err := h.event("exited", exitedEvent{code}); err != nil {
+		return err
+	}
+	return h.event("terminated", nil)
+}
+
+func (h *handler) stopped(reason, desc string, t *debug.Thread) stoppedEvent {
+	if t == nil {
+		t = h.sess.Current()
+	}
+	ev := stoppedEvent{
+		Reason:            reason,
+		Description:       desc,
+		AllThreadsStopped: true,
+	}
+	if t != nil {
+		ev.ThreadID = t.ID
+	}
+	return ev
+}
+
+func (h *handler) threads() []thread {
+	var ts []thread
+	if t := h.sess.Current(); t != nil {
+		ts = append(ts, thread{t.ID, fmt.Sprintf("mach %d", t.ID)})
+	}
+	pending := h.sess.Pending()
+	for i := len(pending) - 1; i >= 0; i-- {
+		t := pending[i]
+		ts = append(ts, thread{t.ID, fmt.Sprintf("mach %d", t.ID)})
+	}
+	return ts
+}
+
+// frames returns a frame for the machine's IP, followed by one for each
+// control stack value that points into labeled code after the stack space,
+// like the return addresses pushed by call.
+func (h *handler) frames(t *debug.Thread) []stackFrame {
+	m := t.Mach
+	frames := []stackFrame{h.frame(t.ID, 0, m.IP())}
+	if _, cs, err := m.Stacks(); err == nil {
+		for i := len(cs) - 1; i >= 0; i-- {
+			if addr := cs[i]; addr > m.CBP() {
+				if _, _, ok := h.sess.Symbol(addr); ok {
+					frames = append(frames, h.frame(t.ID, len(frames), addr))
+				}
+			}
+		}
+	}
+	return frames
+}
+
+func (h *handler) frame(tid, i int, addr uint32) stackFrame {
+	return stackFrame{
+		ID:                          tid<<16 | i,
+		Name:                        h.frameName(addr),
+		InstructionPointerReference: fmt.Sprintf("0x%04x", addr),
+	}
+}
+
+// frameName names a frame after the span (like a function call) that it's
+// within, falling back to the nearest label.
+func (h *handler) frameName(addr uint32) string {
+	if dbg := h.sess.DebugInfo(); dbg != nil {
+		var (
+			best uint32
+			ok   bool
+		)
+		for _, sa := range dbg.SpanAddrs() {
+			if open, _ := dbg.Span(sa); open && sa <= addr && (!ok || sa > best) {
+				if len(dbg.Labels(sa)) > 0 {
+					best, ok = sa, true
+				}
+			}
+		}
+		if ok {
+			return fmt.Sprintf("%s+0x%x", dbg.Labels(best)[0], addr-best)
+		}
+	}
+	if label, off, ok := h.sess.Symbol(addr); ok {
+		return fmt.Sprintf("%s+0x%x", label, off)
+	}
+	return fmt.Sprintf("0x%04x", addr)
+}
+
+func scopes(tid int) []scope {
+	return []scope{
+		{"Parameter Stack", tid*numScopes + scopeParams, false},
+		{"Control Stack", tid*numScopes + scopeControl, false},
+		{"Registers", tid*numScopes + scopeRegs, false},
+		{"Inputs", tid*numScopes + scopeInputs, false},
+		{"Outputs", tid*numScopes + scopeOutputs, false},
+	}
+}
+
+func (h *handler) variables(ref int) ([]variable, error) {
+	t := h.sess.Thread(ref / numScopes)
+	if t == nil {
+		return nil, errNoThread
+	}
+	m := t.Mach
+	var vars []variable
+	switch ref % numScopes {
+	case scopeParams, scopeControl:
+		ps, cs, err := m.Stacks()
+		if err != nil {
+			return nil, err
+		}
+		if ref%numScopes == scopeParams {
+			for i, v := range ps {
+				vars = append(vars, variable{Name: fmt.Sprintf("[%d]", i), Value: strconv.FormatUint(uint64(v), 10)})
+			}
+		} else {
+			for i, v := range cs {
+				vars = append(vars, variable{Name: fmt.Sprintf("[%d]", i), Value: fmt.Sprintf("0x%04x", v)})
+			}
+		}
+	case scopeRegs:
+		for _, reg := range []struct {
+			name string
+			val  uint32
+		}{
+			{"ip", m.IP()},
+			{"pbp", m.PBP()},
+			{"psp", m.PSP()},
+			{"cbp", m.CBP()},
+			{"csp", m.CSP()},
+		} {
+			vars = append(vars, variable{Name: reg.name, Value: fmt.Sprintf("0x%04x", reg.val)})
+		}
+	case scopeInputs, scopeOutputs:
+		var (
+			rgs    []stackvm.Region
+			err    error
+			prefix string
+		)
+		if ref%numScopes == scopeInputs {
+			prefix = "input"
+			rgs, err = m.Inputs()
+		} else {
+			prefix = "output"
+			rgs, err = m.Outputs()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, rg := range rgs {
+			name := rg.Name
+			if name == "" {
+				name = fmt.Sprintf("%s[%d]", prefix, i)
+			}
+			vars = append(vars, variable{Name: name, Value: regionValue(m, rg)})
+		}
+	}
+	return vars, nil
+}
+
+func regionValue(m *stackvm.Mach, rg stackvm.Region) string {
+	parts := make([]string, 0, (rg.To-rg.From)/4)
+	for addr := rg.From; addr < rg.To; addr += 4 {
+		val, err := m.Fetch(addr)
+		if err != nil {
+			return err.Error()
+		}
+		parts = append(parts, strconv.FormatUint(uint64(val), 10))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
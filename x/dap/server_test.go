@@ -0,0 +1,265 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/dap"
+	"github.com/jcorbin/stackvm/x/debug"
+)
+
+type testClient struct {
+	t   *testing.T
+	c   net.Conn
+	r   *textproto.Reader
+	br  *bufio.Reader
+	seq int
+}
+
+type testMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func (tc *testClient) send(command string, args interface{}) int {
+	tc.seq++
+	buf, err := json.Marshal(map[string]interface{}{
+		"seq":       tc.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	require.NoError(tc.t, err)
+	_, err = fmt.Fprintf(tc.c, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	require.NoError(tc.t, err)
+	return tc.seq
+}
+
+func (tc *testClient) recv() testMessage {
+	hdr, err := tc.r.ReadMIMEHeader()
+	require.NoError(tc.t, err)
+	n, err := strconv.Atoi(hdr.Get("Content-Length"))
+	require.NoError(tc.t, err)
+	buf := make([]byte, n)
+	_, err = io.ReadFull(tc.br, buf)
+	require.NoError(tc.t, err)
+	var msg testMessage
+	require.NoError(tc.t, json.Unmarshal(buf, &msg))
+	return msg
+}
+
+// request sends a request, returning its response body along with any events
+// sent before or after it, up to and including one named by until.
+func (tc *testClient) request(command string, args interface{}, until string, body interface{}) []testMessage {
+	seq := tc.send(command, args)
+	var events []testMessage
+	responded := false
+	for !responded || (until != "" && (len(events) == 0 || events[len(events)-1].Event != until)) {
+		msg := tc.recv()
+		switch msg.Type {
+		case "response":
+			require.Equal(tc.t, seq, msg.RequestSeq, "unexpected response")
+			require.True(tc.t, msg.Success, "%s failed: %s", command, msg.Message)
+			if body != nil {
+				require.NoError(tc.t, json.Unmarshal(msg.Body, body))
+			}
+			responded = true
+		case "event":
+			events = append(events, msg)
+		}
+	}
+	return events
+}
+
+func TestServer(t *testing.T) {
+	prog := xstackvm.MustAssemble(
+		".data",
+		".in", "n:", 0,
+		".out", "v:", 0,
+
+		".entry", "main:",
+		":n", "fetch", ":double", "call",
+		":v", "storeTo",
+		":other", "fork",
+		"halt",
+		"other:", 1, "halt",
+
+		".spanOpen", "double:",
+		"dup", "add",
+		"back:", "ret",
+	)
+
+	srv := dap.Server{Launch: func(args json.RawMessage) (*debug.Session, error) {
+		var la struct {
+			N uint32 `json:"n"`
+		}
+		if err := json.Unmarshal(args, &la); err != nil {
+			return nil, err
+		}
+		return debug.New(prog, stackvm.Input([]uint32{la.N}))
+	}}
+
+	sc, cc := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(sc) }()
+	br := bufio.NewReader(cc)
+	tc := &testClient{t: t, c: cc, r: textproto.NewReader(br), br: br}
+
+	var caps map[string]bool
+	events := tc.request("initialize", map[string]string{"adapterID": "stackvm"}, "initialized", &caps)
+	assert.True(t, caps["supportsFunctionBreakpoints"])
+	assert.Len(t, events, 1)
+
+	tc.request("launch", map[string]interface{}{"n": 21}, "", nil)
+
+	var bps struct {
+		Breakpoints []struct {
+			ID       int  `json:"id"`
+			Verified bool `json:"verified"`
+		} `json:"breakpoints"`
+	}
+	tc.request("setFunctionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]string{{"name": "back"}, {"name": "nope"}},
+	}, "", &bps)
+	require.Len(t, bps.Breakpoints, 2)
+	assert.True(t, bps.Breakpoints[0].Verified)
+	assert.False(t, bps.Breakpoints[1].Verified)
+
+	events = tc.request("configurationDone", nil, "stopped", nil)
+	var stopped struct {
+		Reason   string `json:"reason"`
+		ThreadID int    `json:"threadId"`
+		HitIDs   []int  `json:"hitBreakpointIds"`
+	}
+	require.NoError(t, json.Unmarshal(events[len(events)-1].Body, &stopped))
+	assert.Equal(t, "breakpoint", stopped.Reason)
+	assert.Equal(t, 1, stopped.ThreadID)
+	assert.Equal(t, []int{bps.Breakpoints[0].ID}, stopped.HitIDs)
+
+	var trace struct {
+		StackFrames []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"stackFrames"`
+	}
+	tc.request("stackTrace", map[string]int{"threadId": 1}, "", &trace)
+	require.Len(t, trace.StackFrames, 2, "expected a frame for the call")
+	assert.Equal(t, "double+0x2", trace.StackFrames[0].Name)
+	assert.Equal(t, "main+0x4", trace.StackFrames[1].Name)
+
+	var scopes struct {
+		Scopes []struct {
+			Name string `json:"name"`
+			Ref  int    `json:"variablesReference"`
+		} `json:"scopes"`
+	}
+	tc.request("scopes", map[string]int{"frameId": trace.StackFrames[0].ID}, "", &scopes)
+	vars := make(map[string]map[string]string)
+	for _, sc := range scopes.Scopes {
+		var vs struct {
+			Variables []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"variables"`
+		}
+		tc.request("variables", map[string]int{"variablesReference": sc.Ref}, "", &vs)
+		vars[sc.Name] = make(map[string]string)
+		for _, v := range vs.Variables {
+			vars[sc.Name][v.Name] = v.Value
+		}
+	}
+	assert.Equal(t, map[string]string{"[0]": "42"}, vars["Parameter Stack"])
+	assert.Equal(t, map[string]string{"n": "[21]"}, vars["Inputs"])
+	assert.Equal(t, map[string]string{"v": "[0]"}, vars["Outputs"])
+	assert.Equal(t, "0x003c", vars["Registers"]["cbp"])
+
+	tc.request("next", map[string]int{"threadId": 1}, "stopped", nil)
+	tc.request("next", map[string]int{"threadId": 1}, "stopped", nil)
+	tc.request("next", map[string]int{"threadId": 1}, "stopped", nil)
+
+	for _, command := range []string{"next", "continue"} {
+		seq := tc.send(command, map[string]int{"threadId": 99})
+		msg := tc.recv()
+		require.Equal(t, seq, msg.RequestSeq, "unexpected response")
+		assert.False(t, msg.Success, "expected %s of an unknown thread to fail", command)
+		assert.Equal(t, "no pending thread 99", msg.Message, "expected a switch error")
+	}
+
+	var threads struct {
+		Threads []struct {
+			ID int `json:"id"`
+		} `json:"threads"`
+	}
+	tc.request("threads", nil, "", &threads)
+	assert.Len(t, threads.Threads, 2, "expected the fork to add a thread")
+
+	events = tc.request("continue", map[string]int{"threadId": 1}, "terminated", nil)
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	require.True(t, len(events) >= 2)
+	require.Equal(t, "exited", events[len(events)-2].Event)
+	require.NoError(t, json.Unmarshal(events[len(events)-2].Body, &exited))
+	assert.Equal(t, 0, exited.ExitCode)
+
+	tc.request("disconnect", nil, "", nil)
+	require.NoError(t, <-done)
+}
+
+func TestServer_pause(t *testing.T) {
+	prog := xstackvm.MustAssemble(
+		".entry", "main:",
+		0, "push",
+		"loop:", 1, "add", ":loop", "jump",
+	)
+	srv := dap.Server{Launch: func(json.RawMessage) (*debug.Session, error) {
+		return debug.New(prog)
+	}}
+
+	sc, cc := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(sc) }()
+	br := bufio.NewReader(cc)
+	tc := &testClient{t: t, c: cc, r: textproto.NewReader(br), br: br}
+
+	tc.request("initialize", map[string]string{"adapterID": "stackvm"}, "initialized", nil)
+	tc.request("launch", map[string]interface{}{}, "", nil)
+	tc.request("configurationDone", nil, "", nil)
+
+	var stopped struct {
+		Reason      string `json:"reason"`
+		Description string `json:"description"`
+	}
+	events := tc.request("pause", nil, "stopped", nil)
+	require.NoError(t, json.Unmarshal(events[len(events)-1].Body, &stopped))
+	assert.Equal(t, "pause", stopped.Reason, "expected the loop to pause")
+
+	// breakpoints may be changed while running
+	tc.request("continue", map[string]int{"threadId": 1}, "", nil)
+	events = tc.request("setFunctionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]string{{"name": "loop"}},
+	}, "stopped", nil)
+	require.NoError(t, json.Unmarshal(events[len(events)-1].Body, &stopped))
+	assert.Equal(t, "breakpoint", stopped.Reason, "expected the new breakpoint to stop the loop")
+
+	tc.request("continue", map[string]int{"threadId": 1}, "", nil)
+	tc.request("disconnect", nil, "", nil)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,578 @@
+// Package debug implements an interactive debugging session over a stackvm
+// program: machines are stepped one operation at a time, every forked copy is
+// tracked as a thread, and execution stops on breakpoints and watchpoints.
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/action"
+)
+
+var errNoThread = errors.New("no such thread")
+
+// Thread is one machine within a session: the root machine, or a copy made by
+// an operation like fork or branch.
+type Thread struct {
+	ID     int
+	Parent int // id of the thread that made this copy, 0 for the root
+	Mach   *stackvm.Mach
+	Done   bool  // true once the machine has terminated
+	Err    error // any abnormal termination error, once done
+
+	began  bool
+	steps  int               // ops stepped, including any before the copy was made
+	queued map[int][]*Thread // threads queued by each step, by steps after it
+}
+
+func (t *Thread) String() string {
+	if t.Done {
+		if t.Err != nil {
+			return fmt.Sprintf("thread %d %v", t.ID, t.Err)
+		}
+		return fmt.Sprintf("thread %d done", t.ID)
+	}
+	return fmt.Sprintf("thread %d @0x%04x", t.ID, t.Mach.IP())
+}
+
+// StopReason describes why a session stopped.
+type StopReason int
+
+const (
+	// StopStep means a step completed normally.
+	StopStep = StopReason(iota + 1)
+	// StopBreak means a breakpoint matched.
+	StopBreak
+	// StopBrk means the machine executed a brk operation.
+	StopBrk
+	// StopYield means the machine executed a yield operation.
+	StopYield
+	// StopWatch means a watched memory word changed.
+	StopWatch
+	// StopExit means the thread terminated; the next pending thread, if
+	// any, is now current.
+	StopExit
+	// StopDone means there are no machines left to run.
+	StopDone
+	// StopPause means Pause stopped a Continue or Next.
+	StopPause
+)
+
+func (sr StopReason) String() string {
+	switch sr {
+	case StopStep:
+		return "step"
+	case StopBreak:
+		return "break"
+	case StopBrk:
+		return "brk"
+	case StopYield:
+		return "yield"
+	case StopWatch:
+		return "watch"
+	case StopExit:
+		return "exit"
+	case StopDone:
+		return "done"
+	case StopPause:
+		return "pause"
+	default:
+		return fmt.Sprintf("InvalidStopReason(%d)", int(sr))
+	}
+}
+
+// Stop describes where and why a session stopped.
+type Stop struct {
+	Reason   StopReason
+	Thread   *Thread // the thread that stopped, or terminated for StopExit (or last terminated for StopDone)
+	Break    *Break  // the breakpoint matched by StopBreak
+	Watch    *Watch  // the watchpoint changed by StopWatch
+	Old, New uint32  // the watched word's values for StopWatch
+}
+
+// Break is a breakpoint; it matches trace actions (begin, before, after,
+// queue, end) of any thread in the session.
+type Break struct {
+	ID   int
+	Spec string
+	Pred action.Predicate
+	Hits int
+}
+
+// Watch is a watchpoint on a memory word.
+type Watch struct {
+	ID   int
+	Addr uint32
+}
+
+// Session runs a program's machines under debugger control. It implements
+// stackvm.MachQueue so that it sees every machine copy; rather than using the
+// machine run loop, each machine is stepped in turn.
+type Session struct {
+	dbg     stackvm.DebugInfo
+	threads []*Thread
+	cur     *Thread
+	pending []*Thread // lifo, like the default run queue
+	breaks  []*Break
+	watches []*Watch
+	nextID  int
+	lastTID int
+	queued  []*Thread // queued during the current step
+	skip    bool      // skip breakpoints before the next op
+	pause   int32     // set by Pause, atomically
+}
+
+// New builds a session from a program; any machine options are passed to
+// stackvm.New, except that the session is always used as the queue.
+func New(prog []byte, opts ...stackvm.MachBuildOpt) (*Session, error) {
+	s := &Session{}
+	opts = append(opts[:len(opts):len(opts)],
+		stackvm.Queue(s),
+		stackvm.WithDebugInfo(func(dbg stackvm.DebugInfo) { s.dbg = dbg }))
+	m, err := stackvm.New(prog, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.cur = s.addThread(0, m)
+	return s, nil
+}
+
+func (s *Session) addThread(parent int, m *stackvm.Mach) *Thread {
+	s.lastTID++
+	t := &Thread{
+		ID:     s.lastTID,
+		Parent: parent,
+		Mach:   m,
+	}
+	s.threads = append(s.threads, t)
+	return t
+}
+
+// dropThread removes a thread that's no longer pending from the session.
+func (s *Session) dropThread(t *Thread) {
+	for i, ot := range s.threads {
+		if ot == t {
+			s.threads = append(s.threads[:i], s.threads[i+1:]...)
+			return
+		}
+	}
+}
+
+// Enqueue records a machine copy as a new pending thread.
+func (s *Session) Enqueue(m *stackvm.Mach) error {
+	parent := 0
+	if s.cur != nil {
+		parent = s.cur.ID
+	}
+	t := s.addThread(parent, m)
+	if s.cur != nil {
+		// the copy's history includes the step that's making it
+		t.steps = s.cur.steps + 1
+	}
+	s.pending = append(s.pending, t)
+	s.queued = append(s.queued, t)
+	return nil
+}
+
+// Dequeue makes the most recently queued pending thread current, returning
+// its machine.
+func (s *Session) Dequeue() *stackvm.Mach {
+	if i := len(s.pending) - 1; i >= 0 {
+		s.cur = s.pending[i]
+		s.pending = s.pending[:i]
+		return s.cur.Mach
+	}
+	s.cur = nil
+	return nil
+}
+
+// DebugInfo returns any debug info defined by the program, or nil.
+func (s *Session) DebugInfo() stackvm.DebugInfo { return s.dbg }
+
+// Labels returns any labels defined for the given address.
+func (s *Session) Labels(addr uint32) []string {
+	if s.dbg == nil {
+		return nil
+	}
+	return s.dbg.Labels(addr)
+}
+
+// LabelAddrs returns all addresses labeled with the given name.
+func (s *Session) LabelAddrs(label string) []uint32 {
+	if s.dbg == nil {
+		return nil
+	}
+	var addrs []uint32
+	for _, addr := range s.dbg.LabeledAddrs() {
+		for _, l := range s.dbg.Labels(addr) {
+			if l == label {
+				addrs = append(addrs, addr)
+				break
+			}
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Symbol returns the nearest label at or before the given address, along
+// with the address's offset from it; ok is false if no such label exists.
+func (s *Session) Symbol(addr uint32) (label string, off uint32, ok bool) {
+	if s.dbg == nil {
+		return "", 0, false
+	}
+	var best uint32
+	for _, la := range s.dbg.LabeledAddrs() {
+		if la <= addr && (!ok || la > best) {
+			if labels := s.dbg.Labels(la); len(labels) > 0 {
+				best, label, ok = la, labels[0], true
+			}
+		}
+	}
+	return label, addr - best, ok
+}
+
+// ResolveAddr resolves a label name, or an address given in decimal or with
+// a 0x prefix.
+func (s *Session) ResolveAddr(spec string) (uint32, error) {
+	if n, err := strconv.ParseUint(spec, 0, 32); err == nil {
+		return uint32(n), nil
+	}
+	switch addrs := s.LabelAddrs(spec); len(addrs) {
+	case 0:
+		return 0, fmt.Errorf("no such label %q", spec)
+	case 1:
+		return addrs[0], nil
+	default:
+		return 0, fmt.Errorf("ambiguous label %q at %v", spec, addrs)
+	}
+}
+
+// Current returns the current thread, or nil if all are done.
+func (s *Session) Current() *Thread { return s.cur }
+
+// Threads returns all threads in the session, ordered by id.
+func (s *Session) Threads() []*Thread { return s.threads }
+
+// Thread returns the thread with the given id, or nil.
+func (s *Session) Thread(id int) *Thread {
+	i := sort.Search(len(s.threads), func(i int) bool { return s.threads[i].ID >= id })
+	if i < len(s.threads) && s.threads[i].ID == id {
+		return s.threads[i]
+	}
+	return nil
+}
+
+// Pending returns the queued threads, the next one to run last.
+func (s *Session) Pending() []*Thread { return s.pending }
+
+// Switch makes a pending thread current, queueing the current thread (if it
+// isn't done) in its place.
+func (s *Session) Switch(id int) error {
+	if s.cur != nil && s.cur.ID == id {
+		return nil
+	}
+	for i, t := range s.pending {
+		if t.ID == id {
+			if s.cur != nil && !s.cur.Done {
+				s.pending[i] = s.cur
+			} else {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			}
+			s.cur = t
+			s.skip = false
+			return nil
+		}
+	}
+	return fmt.Errorf("no pending thread %d", id)
+}
+
+// Break adds a breakpoint. The spec may be a label name or an address, which
+// break before executing the operation there; otherwise it's parsed with
+// action.ParsePredicate, allowing conditions like "before:fork" or "queue".
+func (s *Session) Break(spec string) (*Break, error) {
+	pred, err := s.parseBreak(spec)
+	if err != nil {
+		return nil, err
+	}
+	s.nextID++
+	b := &Break{ID: s.nextID, Spec: spec, Pred: pred}
+	s.breaks = append(s.breaks, b)
+	return b, nil
+}
+
+func (s *Session) parseBreak(spec string) (action.Predicate, error) {
+	var addrs []uint32
+	if n, err := strconv.ParseUint(spec, 0, 32); err == nil {
+		addrs = []uint32{uint32(n)}
+	} else {
+		addrs = s.LabelAddrs(spec)
+	}
+	if len(addrs) == 0 {
+		pred, err := action.ParsePredicate(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid breakpoint %q: not a label, address, or predicate", spec)
+		}
+		return pred, nil
+	}
+	ps := make([]action.Predicate, len(addrs))
+	for i, addr := range addrs {
+		p, err := action.ParsePredicate(fmt.Sprintf("before@%d", addr))
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = p
+	}
+	return action.Any(ps...), nil
+}
+
+// Breaks returns all breakpoints.
+func (s *Session) Breaks() []*Break { return s.breaks }
+
+// Watches returns all watchpoints.
+func (s *Session) Watches() []*Watch { return s.watches }
+
+// Delete removes the breakpoint or watchpoint with the given id.
+func (s *Session) Delete(id int) error {
+	for i, b := range s.breaks {
+		if b.ID == id {
+			s.breaks = append(s.breaks[:i], s.breaks[i+1:]...)
+			return nil
+		}
+	}
+	for i, w := range s.watches {
+		if w.ID == id {
+			s.watches = append(s.watches[:i], s.watches[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no breakpoint or watchpoint %d", id)
+}
+
+// Watch adds a watchpoint that stops after any step that changes the memory
+// word at the given address.
+func (s *Session) Watch(addr uint32) *Watch {
+	s.nextID++
+	w := &Watch{ID: s.nextID, Addr: addr}
+	s.watches = append(s.watches, w)
+	return w
+}
+
+func (s *Session) hit(act action.TraceAction, ip uint32, op stackvm.Op) *Break {
+	for _, b := range s.breaks {
+		if b.Pred.Test(act, ip, op) {
+			b.Hits++
+			return b
+		}
+	}
+	return nil
+}
+
+// Step executes one operation of the current thread, ignoring any
+// breakpoint before it.
+func (s *Session) Step() Stop {
+	s.skip = true
+	return s.step()
+}
+
+// Pause stops a Continue or Next, which may be running on another goroutine,
+// before its next step; if none is running, the next one to start stops
+// before its first step. It is the only method that may be called while
+// another is running.
+func (s *Session) Pause() { atomic.StoreInt32(&s.pause, 1) }
+
+// Unpause cancels a Pause that hasn't stopped anything yet, such as one made
+// as a Continue returned for another reason.
+func (s *Session) Unpause() { atomic.StoreInt32(&s.pause, 0) }
+
+// paused returns true, once, after Pause.
+func (s *Session) paused() bool { return atomic.CompareAndSwapInt32(&s.pause, 1, 0) }
+
+// Next is like Step, except that it steps over call operations, stopping
+// once the call returns.
+func (s *Session) Next() Stop {
+	t := s.cur
+	if t == nil {
+		return Stop{Reason: StopDone}
+	}
+	op, end, err := t.Mach.ReadOp(t.Mach.IP())
+	if err != nil || op.Name() != "call" {
+		return s.Step()
+	}
+	csp := t.Mach.CSP()
+	s.skip = true
+	for {
+		if s.paused() {
+			return Stop{Reason: StopPause, Thread: s.cur}
+		}
+		st := s.step()
+		if st.Reason != StopStep {
+			return st
+		}
+		if s.cur == t && t.Mach.IP() == end && t.Mach.CSP() == csp {
+			return st
+		}
+	}
+}
+
+// StepBack rewinds one operation of the given thread (0 for the current
+// one), which requires the session to have been built with the
+// stackvm.Record option. A terminated thread is revived by stepping back, and
+// made current, queueing the prior current thread in its place. Any threads
+// queued by the operation, like the copy made by a fork, are dropped from the
+// session if they're still pending, since stepping forward makes them again.
+func (s *Session) StepBack(id int) (Stop, error) {
+	t := s.cur
+	if id != 0 {
+		t = s.Thread(id)
+	}
+	if t == nil {
+		return Stop{}, errNoThread
+	}
+	if err := t.Mach.StepBack(); err != nil {
+		return Stop{}, err
+	}
+	s.unqueue(t)
+	if t.Done {
+		t.Done, t.Err = false, nil
+		if s.cur != nil && s.cur != t {
+			s.pending = append(s.pending, s.cur)
+		}
+		s.cur = t
+	} else if t != s.cur {
+		if err := s.Switch(t.ID); err != nil {
+			return Stop{}, err
+		}
+	}
+	s.skip = true
+	return Stop{Reason: StopStep, Thread: t}, nil
+}
+
+// unqueue drops any pending threads queued by the step that t just undid.
+func (s *Session) unqueue(t *Thread) {
+	for _, n := range t.queued[t.steps] {
+		for i, pt := range s.pending {
+			if pt == n {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				s.dropThread(n)
+				break
+			}
+		}
+	}
+	delete(t.queued, t.steps)
+	if t.steps > 0 {
+		t.steps--
+	}
+}
+
+// Continue runs threads until a breakpoint, watchpoint, brk, yield, or Pause
+// stops one, or until there are no machines left to run; threads that
+// terminate along the way are recorded, and the next pending one is run.
+func (s *Session) Continue() Stop {
+	var last *Thread
+	for {
+		if s.paused() {
+			return Stop{Reason: StopPause, Thread: s.cur}
+		}
+		st := s.step()
+		switch st.Reason {
+		case StopStep:
+		case StopExit:
+			last = st.Thread
+		case StopDone:
+			if st.Thread == nil {
+				st.Thread = last
+			}
+			return st
+		default:
+			return st
+		}
+	}
+}
+
+func (s *Session) step() Stop {
+	t := s.cur
+	if t == nil {
+		return Stop{Reason: StopDone}
+	}
+	m := t.Mach
+	ip := m.IP()
+	op, _, _ := m.ReadOp(ip)
+
+	if s.skip {
+		s.skip = false
+	} else {
+		if !t.began {
+			if b := s.hit(action.TraceBegin, ip, op); b != nil {
+				t.began = true
+				s.skip = true
+				return Stop{Reason: StopBreak, Thread: t, Break: b}
+			}
+		}
+		if b := s.hit(action.TraceBefore, ip, op); b != nil {
+			t.began = true
+			s.skip = true
+			return Stop{Reason: StopBreak, Thread: t, Break: b}
+		}
+	}
+	t.began = true
+
+	var olds []uint32
+	if len(s.watches) > 0 {
+		olds = make([]uint32, len(s.watches))
+		for i, w := range s.watches {
+			olds[i], _ = m.Fetch(w.Addr)
+		}
+	}
+
+	s.queued = s.queued[:0]
+	err := m.Step()
+	t.steps++
+	if len(s.queued) > 0 {
+		if t.queued == nil {
+			t.queued = make(map[int][]*Thread)
+		}
+		t.queued[t.steps] = append([]*Thread(nil), s.queued...)
+	}
+
+	st := Stop{Reason: StopStep, Thread: t}
+	for _, n := range s.queued {
+		if b := s.hit(action.TraceQueue, n.Mach.IP(), op); b != nil && st.Reason == StopStep {
+			st = Stop{Reason: StopBreak, Thread: t, Break: b}
+		}
+	}
+
+	if _, ok := err.(stackvm.Paused); ok {
+		return Stop{Reason: StopBrk, Thread: t}
+	}
+	if _, ok := m.Yielded(); ok {
+		return Stop{Reason: StopYield, Thread: t}
+	}
+	if _, halted := m.HaltCode(); halted || err != nil {
+		t.Done, t.Err = true, err
+		b := s.hit(action.TraceEnd, m.IP(), op)
+		s.Dequeue()
+		if b != nil {
+			return Stop{Reason: StopBreak, Thread: t, Break: b}
+		}
+		return Stop{Reason: StopExit, Thread: t}
+	}
+
+	for i, w := range s.watches {
+		if val, _ := m.Fetch(w.Addr); val != olds[i] {
+			return Stop{Reason: StopWatch, Thread: t, Watch: w, Old: olds[i], New: val}
+		}
+	}
+
+	if st.Reason == StopStep {
+		if b := s.hit(action.TraceAfter, m.IP(), op); b != nil {
+			st = Stop{Reason: StopBreak, Thread: t, Break: b}
+		}
+	}
+	return st
+}
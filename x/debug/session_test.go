@@ -0,0 +1,134 @@
+package debug_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/debug"
+)
+
+func TestSession(t *testing.T) {
+	sess, err := debug.New(xstackvm.MustAssemble(
+		".data",
+		".out", "v:", 0,
+
+		".entry", "main:",
+		":other", "fork",
+		5, "push", ":v", "storeTo",
+		"brk",
+		"done:", "halt",
+		"other:", 6, "push", ":v", "storeTo",
+		1, "halt",
+	))
+	require.NoError(t, err, "unexpected session error")
+
+	v, err := sess.ResolveAddr("v")
+	require.NoError(t, err, "unexpected resolve error")
+	sess.Watch(v)
+	_, err = sess.Break("done")
+	require.NoError(t, err, "unexpected break error")
+
+	st := sess.Step()
+	assert.Equal(t, debug.StopStep, st.Reason, "expected fork to step")
+	require.Len(t, sess.Pending(), 1, "expected fork to queue a thread")
+	assert.Equal(t, 1, sess.Pending()[0].Parent, "expected copy parented by root")
+
+	st = sess.Continue()
+	require.Equal(t, debug.StopWatch, st.Reason, "expected watchpoint")
+	assert.Equal(t, uint32(0), st.Old)
+	assert.Equal(t, uint32(5), st.New)
+
+	st = sess.Continue()
+	assert.Equal(t, debug.StopBrk, st.Reason, "expected brk")
+
+	st = sess.Continue()
+	require.Equal(t, debug.StopBreak, st.Reason, "expected breakpoint")
+	assert.Equal(t, []string{"done"}, sess.Labels(st.Thread.Mach.IP()))
+
+	// switch to the copy before the original halts
+	require.NoError(t, sess.Switch(2), "unexpected switch error")
+	st = sess.Continue()
+	require.Equal(t, debug.StopWatch, st.Reason, "expected watchpoint in copy")
+	assert.Equal(t, 2, st.Thread.ID)
+	assert.Equal(t, uint32(6), st.New)
+
+	st = sess.Continue()
+	assert.Equal(t, debug.StopBreak, st.Reason, "expected to return to the breakpoint")
+	assert.Equal(t, 1, st.Thread.ID)
+
+	st = sess.Continue()
+	assert.Equal(t, debug.StopDone, st.Reason, "expected to run all machines")
+	threads := sess.Threads()
+	require.Len(t, threads, 2)
+	assert.True(t, threads[0].Done)
+	assert.NoError(t, threads[0].Err)
+	assert.True(t, threads[1].Done)
+	require.Error(t, threads[1].Err)
+	assert.Contains(t, threads[1].Err.Error(), "HALT(1)")
+}
+
+func TestSession_StepBack(t *testing.T) {
+	sess, err := debug.New(xstackvm.MustAssemble(
+		".data",
+		".out", "v:", 0,
+		".entry", "main:",
+		5, "push", ":v", "storeTo",
+		3, "halt",
+	), stackvm.Record())
+	require.NoError(t, err, "unexpected session error")
+	v, err := sess.ResolveAddr("v")
+	require.NoError(t, err, "unexpected resolve error")
+
+	st := sess.Continue()
+	require.Equal(t, debug.StopDone, st.Reason, "expected to run all machines")
+	require.NotNil(t, st.Thread, "expected the last thread")
+	require.True(t, st.Thread.Done)
+
+	// stepping back revives the halted thread
+	st, err = sess.StepBack(st.Thread.ID)
+	require.NoError(t, err, "unexpected step back error")
+	assert.Equal(t, st.Thread, sess.Current())
+	assert.False(t, st.Thread.Done)
+
+	_, err = sess.StepBack(0)
+	require.NoError(t, err, "unexpected step back error")
+	val, _ := st.Thread.Mach.Fetch(v)
+	assert.Equal(t, uint32(0), val, "expected store to be undone")
+
+	st = sess.Step()
+	val, _ = st.Thread.Mach.Fetch(v)
+	assert.Equal(t, uint32(5), val, "expected store to be redone")
+
+	// stepping back over a fork drops the copy it made
+	sess, err = debug.New(xstackvm.MustAssemble(
+		".entry", "main:",
+		":other", "fork",
+		1, "halt",
+		"other:", 2, "halt",
+	), stackvm.Record())
+	require.NoError(t, err, "unexpected session error")
+	sess.Step()
+	require.Len(t, sess.Pending(), 1, "expected fork to queue a thread")
+	_, err = sess.StepBack(0)
+	require.NoError(t, err, "unexpected step back error")
+	assert.Empty(t, sess.Pending(), "expected the copy to be dropped")
+	assert.Len(t, sess.Threads(), 1, "expected only the root thread")
+
+	sess.Step()
+	require.Len(t, sess.Pending(), 1, "expected fork to queue a thread again")
+	st = sess.Continue()
+	require.Equal(t, debug.StopDone, st.Reason, "expected to run all machines")
+	var codes []uint32
+	for _, th := range sess.Threads() {
+		assert.True(t, th.Done, "expected %v to be done", th)
+		code, _ := th.Mach.HaltCode()
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []uint32{1, 2}, codes, "expected each halt once")
+	assert.Nil(t, sess.Thread(2), "expected the dropped thread's id to stay unused")
+	assert.Equal(t, 3, sess.Threads()[1].ID)
+}
@@ -15,15 +15,23 @@ var DumpPointers = false
 
 type dumper struct {
 	m    *stackvm.Mach
+	dbg  stackvm.DebugInfo
 	f    func(string, ...interface{})
 	last uint32
 }
 
 // Dump dumps the machine's memory to a log formating function.
 func Dump(m *stackvm.Mach, f func(string, ...interface{})) error {
+	return DumpDebug(m, nil, f)
+}
+
+// DumpDebug is like Dump, but also annotates each line with the source
+// position of any code within it, if the given debug info has any.
+func DumpDebug(m *stackvm.Mach, dbg stackvm.DebugInfo, f func(string, ...interface{})) error {
 	d := dumper{
-		m: m,
-		f: f,
+		m:   m,
+		dbg: dbg,
+		f:   f,
 	}
 	return m.EachPage(d.page)
 }
@@ -59,7 +67,7 @@ func (d dumper) line(addr uint32, l []byte) {
 
 func (d dumper) annotate(addr uint32, l []byte) string {
 	var (
-		parts [3]string
+		parts [4]string
 		i     int
 	)
 
@@ -78,6 +86,11 @@ func (d dumper) annotate(addr uint32, l []byte) string {
 		i++
 	}
 
+	if ann := d.annotateSource(addr, l); ann != "" {
+		parts[i] = ann
+		i++
+	}
+
 	if i > 0 {
 		return strings.Join(parts[:i], " ")
 	}
@@ -103,3 +116,21 @@ func (d dumper) annotateStackBytes(addr uint32, l []byte, bp, sp uint32) string
 	}
 	return fmt.Sprintf("%d", ns)
 }
+
+func (d dumper) annotateSource(addr uint32, l []byte) string {
+	if d.dbg == nil {
+		return ""
+	}
+	var srcs []string
+	last := ""
+	for a := addr; a < addr+uint32(len(l)); a++ {
+		if src := d.dbg.Source(a); src != "" && src != last {
+			srcs = append(srcs, src)
+			last = src
+		}
+	}
+	if len(srcs) == 0 {
+		return ""
+	}
+	return "src=" + strings.Join(srcs, ",")
+}
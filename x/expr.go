@@ -0,0 +1,297 @@
+package xstackvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// exprOps are the bytes that make a string token an expression, rather than
+// a plain ":ref", op, or constant name.
+const exprOps = "+-*/()"
+
+// expr is a linear immediate expression: a constant offset, plus any scaled
+// label addresses. For example ":values+4*3" has one term (values, 1) and an
+// offset of 12, while ":end-:start" has terms (end, 1) and (start, -1).
+type expr struct {
+	off   int
+	terms []exprTerm
+}
+
+type exprTerm struct {
+	label string
+	scale int
+}
+
+func (e expr) add(other expr, sign int) expr {
+	e.off += sign * other.off
+	for _, t := range other.terms {
+		e = e.addTerm(t.label, sign*t.scale)
+	}
+	return e
+}
+
+func (e expr) addTerm(label string, scale int) expr {
+	terms := make([]exprTerm, 0, len(e.terms)+1)
+	found := false
+	for _, t := range e.terms {
+		if t.label == label {
+			t.scale += scale
+			found = true
+		}
+		if t.scale != 0 {
+			terms = append(terms, t)
+		}
+	}
+	if !found && scale != 0 {
+		terms = append(terms, exprTerm{label, scale})
+	}
+	e.terms = terms
+	return e
+}
+
+func (e expr) scale(n int) expr {
+	if n == 0 {
+		return expr{}
+	}
+	e.off *= n
+	terms := make([]exprTerm, len(e.terms))
+	for i, t := range e.terms {
+		terms[i] = exprTerm{t.label, t.scale * n}
+	}
+	e.terms = terms
+	return e
+}
+
+// primary returns the index of the term that the expression refers to
+// foremost: the first with a unit scale, if any.
+func (e expr) primary() int {
+	for i, t := range e.terms {
+		if t.scale == 1 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (sc *scanner) isExpr(s string) bool {
+	if _, defined := sc.consts[s]; defined {
+		return true
+	}
+	return strings.ContainsAny(s, exprOps)
+}
+
+// parseExpr parses an immediate expression, made up of ints, constant names,
+// and ":label" addresses, combined by + - * / and parentheses. Only constant
+// terms may be multiplied or divided.
+func (sc *scanner) parseExpr(s string) (expr, error) {
+	p := exprParser{s: s, consts: sc.consts}
+	e, err := p.sum()
+	if err == nil && p.i < len(p.s) {
+		err = fmt.Errorf("unexpected %q", p.s[p.i:])
+	}
+	if err != nil {
+		return expr{}, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	return e, nil
+}
+
+// evalConst parses an immediate expression that must not reference labels.
+func (sc *scanner) evalConst(s string) (int, error) {
+	e, err := sc.parseExpr(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(e.terms) > 0 {
+		return 0, fmt.Errorf("invalid constant expression %q: references label %q", s, e.terms[0].label)
+	}
+	return e.off, nil
+}
+
+type exprParser struct {
+	s      string
+	i      int
+	consts map[string]int
+}
+
+func (p *exprParser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (expr, error) {
+	e, err := p.product()
+	for err == nil {
+		sign := 1
+		switch p.peek() {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return e, nil
+		}
+		p.i++
+		var other expr
+		other, err = p.product()
+		e = e.add(other, sign)
+	}
+	return expr{}, err
+}
+
+func (p *exprParser) product() (expr, error) {
+	e, err := p.unary()
+	for err == nil {
+		c := p.peek()
+		if c != '*' && c != '/' {
+			return e, nil
+		}
+		p.i++
+		var other expr
+		other, err = p.unary()
+		if err != nil {
+			break
+		}
+		switch {
+		case c == '*' && len(e.terms) == 0:
+			e = other.scale(e.off)
+		case c == '*' && len(other.terms) == 0:
+			e = e.scale(other.off)
+		case c == '*':
+			err = fmt.Errorf("cannot multiply label addresses")
+		case len(e.terms) > 0 || len(other.terms) > 0:
+			err = fmt.Errorf("cannot divide label addresses")
+		case other.off == 0:
+			err = fmt.Errorf("division by zero")
+		default:
+			e.off /= other.off
+		}
+	}
+	return expr{}, err
+}
+
+func (p *exprParser) unary() (expr, error) {
+	switch p.peek() {
+	case '-':
+		p.i++
+		e, err := p.unary()
+		return e.scale(-1), err
+	case '(':
+		p.i++
+		e, err := p.sum()
+		if err != nil {
+			return expr{}, err
+		}
+		if p.peek() != ')' {
+			return expr{}, fmt.Errorf("missing )")
+		}
+		p.i++
+		return e, nil
+	}
+	return p.atom()
+}
+
+func (p *exprParser) atom() (expr, error) {
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(exprOps, p.s[p.i]) < 0 {
+		p.i++
+	}
+	word := p.s[start:p.i]
+	switch {
+	case word == "":
+		if p.i < len(p.s) {
+			return expr{}, fmt.Errorf("unexpected %q", p.s[p.i:])
+		}
+		return expr{}, fmt.Errorf("unexpected end")
+	case word[0] == ':':
+		if len(word) == 1 {
+			return expr{}, fmt.Errorf("missing label name")
+		}
+		return expr{terms: []exprTerm{{word[1:], 1}}}, nil
+	case '0' <= word[0] && word[0] <= '9':
+		n, err := strconv.ParseInt(word, 0, 64)
+		if err != nil {
+			return expr{}, fmt.Errorf("invalid integer %q", word)
+		}
+		return expr{off: int(n)}, nil
+	}
+	if n, defined := p.consts[word]; defined {
+		return expr{off: n}, nil
+	}
+	return expr{}, fmt.Errorf("undefined constant %q", word)
+}
+
+func (sc *scanner) handleConst() error {
+	name, err := sc.expectString(".const NAME")
+	if err != nil {
+		return err
+	}
+	if !isIdent(name) || '0' <= name[0] && name[0] <= '9' {
+		return fmt.Errorf("invalid .const name %q", name)
+	}
+	if _, err := stackvm.ResolveOp(name, 0, false); err == nil {
+		return fmt.Errorf("invalid .const name %q, already an operation", name)
+	}
+	if _, defined := sc.consts[name]; defined {
+		return fmt.Errorf("constant %q already defined", name)
+	}
+	n, err := sc.expectInt(".const value")
+	if err != nil {
+		return err
+	}
+	if sc.consts == nil {
+		sc.consts = make(map[string]int, 1)
+	}
+	sc.consts[name] = n
+	return nil
+}
+
+func (sc *scanner) handleExpr(s string, n int) error {
+	e, err := sc.parseExpr(s)
+	if err != nil {
+		return err
+	}
+	e.off += n
+	if len(e.terms) == 0 {
+		return sc.handleImm(e.off)
+	}
+	name := e.terms[e.primary()].label
+	tok, err := sc.expectRefOp(0, true, name)
+	if err != nil {
+		return err
+	}
+	sc.addProgExprRef(tok, e)
+	for _, t := range e.terms {
+		sc.refLabel(t.label)
+	}
+	return nil
+}
+
+func (sc *scanner) addProgExprRef(tok token, e expr) {
+	i := e.primary()
+	name := e.terms[i].label
+	if tok.kind == opTK && tok.Name() == "call" {
+		sc.addSpanOpen(name)
+	}
+	sc.prog.addExprRef(tok, e)
+}
+
+// addExprRef adds a token that refers to an expression: its primary term
+// becomes the ref's target, and any others (along with any extra scale of the
+// primary) become its additional terms.
+func (sec *section) addExprRef(tok token, e expr) {
+	i := e.primary()
+	primary := e.terms[i]
+	nrf := namedRef{targName: primary.label, ref: ref{site: len(sec.toks), off: e.off}}
+	if primary.scale != 1 {
+		nrf.terms = append(nrf.terms, exprTerm{primary.label, primary.scale - 1})
+	}
+	nrf.terms = append(nrf.terms, e.terms[:i]...)
+	nrf.terms = append(nrf.terms, e.terms[i+1:]...)
+	sec.refs = append(sec.refs, nrf)
+	sec.noteSrc()
+	sec.toks = append(sec.toks, tok)
+}
@@ -0,0 +1,99 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_expr(t *testing.T) {
+	assert.Equal(t,
+		MustAssemble(
+			".data", "values:", ".alloc", 4,
+			".entry", "main:", 12, ":values", "push", 0, "halt",
+		),
+		MustAssemble(
+			".const", "SIZE", 4,
+			".data", "values:", ".alloc", "SIZE",
+			".entry", "main:", ":values+4*3", "push", 0, "halt",
+		),
+		"expected an expression to assemble like an offset ref")
+
+	src, err := ParseSource("expr.svm", strings.NewReader(`
+.const N 3
+.const SIZE N*4
+.data
+.out values: .alloc N
+.out size: 0
+.out diff: 0
+.out consts: SIZE*2-1 0 0
+.text
+.entry main:
+	(N+1)*2   push  :values+4*2 storeTo   ; values[2] = 8
+	:end-:start push :size storeTo        ; 2 ops, each of 1 byte
+	:values-:end push :diff storeTo
+	-N push :consts+4*1 storeTo
+	SIZE/5 push :consts+4*2 storeTo
+start:
+	nop nop
+end:
+	0 halt
+`))
+	require.NoError(t, err, "unexpected parse error")
+	prog, err := src.Assemble()
+	require.NoError(t, err, "unexpected assemble error")
+
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+
+	var values, end uint32
+	for _, addr := range dbg.LabeledAddrs() {
+		for _, label := range dbg.Labels(addr) {
+			switch label {
+			case "values":
+				values = addr
+			case "end":
+				end = addr
+			}
+		}
+	}
+	require.NotZero(t, values, "expected values label")
+	require.NotZero(t, end, "expected end label")
+	assert.Equal(t, map[string][]uint32{
+		"values": {0, 0, 8},
+		"size":   {2},
+		"diff":   {values - end},
+		"consts": {23, uint32(0xfffffffd), 2},
+	}, vals)
+
+	for _, c := range []struct {
+		name string
+		in   []interface{}
+		err  string
+	}{
+		{"undefined const", []interface{}{"X*2", "push"}, `invalid expression "X*2": undefined constant "X"`},
+		{"label product", []interface{}{"a:", ":a*:a", "push"}, `invalid expression ":a*:a": cannot multiply label addresses`},
+		{"label quotient", []interface{}{"a:", ":a/2", "push"}, `invalid expression ":a/2": cannot divide label addresses`},
+		{"division by zero", []interface{}{"1/0", "push"}, `invalid expression "1/0": division by zero`},
+		{"unbalanced", []interface{}{"(1+2", "push"}, `invalid expression "(1+2": missing )`},
+		{"trailing", []interface{}{"1+2)", "push"}, `invalid expression "1+2)": unexpected ")"`},
+		{"label const", []interface{}{"a:", ".const", "A", ":a+1"}, `invalid constant expression ":a+1": references label "a"`},
+		{"op const", []interface{}{".const", "push", 1}, `invalid .const name "push", already an operation`},
+		{"redefined const", []interface{}{".const", "A", 1, ".const", "A", 2}, `constant "A" already defined`},
+		{"undefined label", []interface{}{".entry", "main:", ":a-:b", "push", 0, "halt", "a:", "nop"}, `undefined labels: ["b"]`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Assemble(c.in...)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package gdbrsp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// conn reads and writes remote serial protocol packets: "$data#xx", where xx
+// is the hex modulo-256 sum of data; each packet is acknowledged with a "+"
+// (or "-" to request retransmission).
+type conn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: rw}
+}
+
+func checksum(data []byte) (sum byte) {
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// read returns the next packet's data, acknowledging it; acks and interrupts
+// (0x03) between packets are skipped, since machines are only ever run until
+// their next stop.
+func (c *conn) read() ([]byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '$' {
+			continue
+		}
+		data, err := c.r.ReadBytes('#')
+		if err != nil {
+			return nil, err
+		}
+		data = data[:len(data)-1]
+		var sum [2]byte
+		if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+			return nil, err
+		}
+		var want byte
+		if _, err := fmt.Sscanf(string(sum[:]), "%02x", &want); err != nil || want != checksum(data) {
+			if _, err := c.w.Write([]byte{'-'}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := c.w.Write([]byte{'+'}); err != nil {
+			return nil, err
+		}
+		return unescape(data), nil
+	}
+}
+
+// write sends a packet, retransmitting it until acknowledged.
+func (c *conn) write(data string) error {
+	pkt := fmt.Sprintf("$%s#%02x", data, checksum([]byte(data)))
+	for {
+		if _, err := io.WriteString(c.w, pkt); err != nil {
+			return err
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case '+':
+			return nil
+		case '-':
+			continue
+		default:
+			return c.r.UnreadByte()
+		}
+	}
+}
+
+// unescape undoes the "}" escaping used by binary data.
+func unescape(data []byte) []byte {
+	if bytes.IndexByte(data, '}') < 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
@@ -0,0 +1,436 @@
+// Package gdbrsp implements a GDB remote serial protocol stub for stackvm
+// programs, on top of an x/debug Session: the IP, PSP, CSP, PBP, and CBP
+// machine registers are exposed as 32-bit registers, memory is read and
+// written directly, and queued fork copies appear as separate threads.
+package gdbrsp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/debug"
+)
+
+var errNoThread = errors.New("no such thread")
+
+// Registers are reported in this order, each as a little endian 32-bit word.
+const (
+	regIP = iota
+	regPSP
+	regCSP
+	regPBP
+	regCBP
+	numRegs
+)
+
+// maxRead bounds memory reads, so that replies fit within the advertised
+// packet size of 0x4000 bytes.
+const maxRead = 0x1fff
+
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+<feature name="org.stackvm.core">
+<reg name="ip" bitsize="32" type="code_ptr"/>
+<reg name="psp" bitsize="32" type="data_ptr"/>
+<reg name="csp" bitsize="32" type="data_ptr"/>
+<reg name="pbp" bitsize="32" type="data_ptr"/>
+<reg name="cbp" bitsize="32" type="data_ptr"/>
+</feature>
+</target>
+`
+
+// LaunchFunc builds a debug session for each new client connection.
+type LaunchFunc func() (*debug.Session, error)
+
+// Server serves RSP clients, one session per connection.
+type Server struct {
+	Launch LaunchFunc
+}
+
+// ListenAndServe listens on a local TCP address, serving each client
+// connection in turn.
+func (srv *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return srv.ServeListener(ln)
+}
+
+// ServeListener serves each client connection accepted from a listener in
+// turn.
+func (srv *Server) ServeListener(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		err = srv.Serve(c)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve serves a single client over the given stream, until it detaches,
+// kills the target, or disconnects.
+func (srv *Server) Serve(rw io.ReadWriter) error {
+	if srv.Launch == nil {
+		return errors.New("no launch function")
+	}
+	sess, err := srv.Launch()
+	if err != nil {
+		return err
+	}
+	h := handler{conn: newConn(rw), sess: sess}
+	for {
+		pkt, err := h.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		resp, done := h.handle(string(pkt))
+		if err := h.write(resp); err != nil || done {
+			return err
+		}
+	}
+}
+
+type handler struct {
+	*conn
+	sess    *debug.Session
+	gThread int // thread for register and memory access, 0 for current
+	cThread int // thread to resume, 0 for current
+	swbreak bool
+	breaks  map[uint32]int
+	watches map[uint32]int
+}
+
+func (h *handler) handle(pkt string) (resp string, done bool) {
+	if pkt == "" {
+		return "", false
+	}
+	args := pkt[1:]
+	switch pkt[0] {
+	case '?':
+		if t := h.sess.Current(); t != nil {
+			return h.stopReply(debug.Stop{Reason: debug.StopStep, Thread: t}), false
+		}
+		return h.stopReply(debug.Stop{Reason: debug.StopDone}), false
+
+	case 'q':
+		return h.query(args), false
+
+	case 'H':
+		if args == "" {
+			return "E01", false
+		}
+		id, err := parseThreadID(args[1:])
+		if err != nil {
+			return "E01", false
+		}
+		switch args[0] {
+		case 'g':
+			h.gThread = id
+		case 'c':
+			h.cThread = id
+		default:
+			return "E01", false
+		}
+		return "OK", false
+
+	case 'T':
+		id, err := parseThreadID(args)
+		if err != nil {
+			return "E01", false
+		}
+		if t := h.sess.Thread(id); t == nil || t.Done {
+			return "E01", false
+		}
+		return "OK", false
+
+	case 'g':
+		m, err := h.mach()
+		if err != nil {
+			return "E01", false
+		}
+		var buf [4 * numRegs]byte
+		for i := 0; i < numRegs; i++ {
+			binary.LittleEndian.PutUint32(buf[4*i:], register(m, i))
+		}
+		return hex.EncodeToString(buf[:]), false
+
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 32)
+		m, merr := h.mach()
+		if err != nil || merr != nil || n >= numRegs {
+			return "E01", false
+		}
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], register(m, int(n)))
+		return hex.EncodeToString(buf[:]), false
+
+	case 'm':
+		addr, n, err := parseAddrLen(args)
+		m, merr := h.mach()
+		if err != nil || merr != nil || n > maxRead {
+			return "E01", false
+		}
+		buf := make([]byte, n)
+		m.MemCopy(addr, buf)
+		return hex.EncodeToString(buf), false
+
+	case 'M':
+		i := strings.IndexByte(args, ':')
+		if i < 0 {
+			return "E01", false
+		}
+		addr, n, err := parseAddrLen(args[:i])
+		if err != nil {
+			return "E01", false
+		}
+		buf, err := hex.DecodeString(args[i+1:])
+		m, merr := h.mach()
+		if err != nil || merr != nil || len(buf) != int(n) {
+			return "E01", false
+		}
+		m.MemStore(addr, buf)
+		return "OK", false
+
+	case 's', 'c':
+		if args != "" {
+			// resuming at an address is not supported
+			return "E01", false
+		}
+		if h.cThread > 0 {
+			if err := h.sess.Switch(h.cThread); err != nil {
+				return "E01", false
+			}
+		}
+		h.gThread, h.cThread = 0, 0
+		if pkt[0] == 's' {
+			return h.stopReply(h.sess.Step()), false
+		}
+		return h.stopReply(h.sess.Continue()), false
+
+	case 'Z', 'z':
+		return h.point(pkt[0] == 'Z', args), false
+
+	case 'D':
+		return "OK", true
+
+	case 'k':
+		return "", true
+	}
+
+	// unsupported, including vCont and register writes
+	return "", false
+}
+
+func (h *handler) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		h.swbreak = strings.Contains(args, "swbreak+")
+		resp := "PacketSize=4000;qXfer:features:read+"
+		if h.swbreak {
+			resp += ";swbreak+"
+		}
+		return resp
+
+	case args == "Attached":
+		return "1"
+
+	case args == "C":
+		if t := h.sess.Current(); t != nil {
+			return fmt.Sprintf("QC%x", t.ID)
+		}
+		return ""
+
+	case args == "fThreadInfo":
+		var ids []string
+		if t := h.sess.Current(); t != nil {
+			ids = append(ids, strconv.FormatInt(int64(t.ID), 16))
+		}
+		for _, t := range h.sess.Pending() {
+			ids = append(ids, strconv.FormatInt(int64(t.ID), 16))
+		}
+		if len(ids) == 0 {
+			return "l"
+		}
+		return "m" + strings.Join(ids, ",")
+
+	case args == "sThreadInfo":
+		return "l"
+
+	case strings.HasPrefix(args, "ThreadExtraInfo,"):
+		id, err := parseThreadID(args[len("ThreadExtraInfo,"):])
+		t := h.sess.Thread(id)
+		if err != nil || t == nil {
+			return "E01"
+		}
+		desc := fmt.Sprintf("parent %d", t.Parent)
+		if label, off, ok := h.sess.Symbol(t.Mach.IP()); ok {
+			desc = fmt.Sprintf("%s+0x%x, %s", label, off, desc)
+		}
+		return hex.EncodeToString([]byte(desc))
+
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		off, n, err := parseAddrLen(args[len("Xfer:features:read:target.xml:"):])
+		if err != nil {
+			return "E01"
+		}
+		if int(off) >= len(targetXML) {
+			return "l"
+		}
+		if end := int(off) + int(n); end < len(targetXML) {
+			return "m" + targetXML[off:end]
+		}
+		return "l" + targetXML[off:]
+	}
+	return ""
+}
+
+// point adds or removes a breakpoint (types 0 and 1) or a write watchpoint
+// (type 2) at an address.
+func (h *handler) point(add bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 2 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return "E01"
+	}
+
+	var ids *map[uint32]int
+	switch parts[0] {
+	case "0", "1":
+		ids = &h.breaks
+	case "2":
+		ids = &h.watches
+	default:
+		return ""
+	}
+
+	a := uint32(addr)
+	id, have := (*ids)[a]
+	if !add {
+		if have {
+			delete(*ids, a)
+			if err := h.sess.Delete(id); err != nil {
+				return "E01"
+			}
+		}
+		return "OK"
+	}
+	if have {
+		return "OK"
+	}
+	if *ids == nil {
+		*ids = make(map[uint32]int)
+	}
+	if ids == &h.watches {
+		(*ids)[a] = h.sess.Watch(a).ID
+		return "OK"
+	}
+	b, err := h.sess.Break(strconv.FormatUint(addr, 10))
+	if err != nil {
+		return "E01"
+	}
+	(*ids)[a] = b.ID
+	return "OK"
+}
+
+func (h *handler) stopReply(st debug.Stop) string {
+	if st.Reason == debug.StopExit {
+		// report the exit as a stop in whichever thread runs next
+		st = debug.Stop{Reason: debug.StopDone}
+		if t := h.sess.Current(); t != nil {
+			st = debug.Stop{Reason: debug.StopStep, Thread: t}
+		}
+	}
+	if st.Reason == debug.StopDone {
+		code := 1
+		for _, t := range h.sess.Threads() {
+			if t.Done && t.Err == nil {
+				code = 0
+				break
+			}
+		}
+		return fmt.Sprintf("W%02x", code)
+	}
+	resp := fmt.Sprintf("T05thread:%x;", st.Thread.ID)
+	switch st.Reason {
+	case debug.StopBreak:
+		if h.swbreak {
+			resp += "swbreak:;"
+		}
+	case debug.StopWatch:
+		resp += fmt.Sprintf("watch:%x;", st.Watch.Addr)
+	}
+	return resp
+}
+
+func (h *handler) mach() (*stackvm.Mach, error) {
+	t := h.sess.Current()
+	if h.gThread > 0 {
+		t = h.sess.Thread(h.gThread)
+	}
+	if t == nil {
+		return nil, errNoThread
+	}
+	return t.Mach, nil
+}
+
+func register(m *stackvm.Mach, i int) uint32 {
+	switch i {
+	case regIP:
+		return m.IP()
+	case regPSP:
+		return m.PSP()
+	case regCSP:
+		return m.CSP()
+	case regPBP:
+		return m.PBP()
+	case regCBP:
+		return m.CBP()
+	}
+	return 0
+}
+
+// parseThreadID parses a hex thread id; "0" (any thread) and "-1" (all
+// threads) are both taken to mean the current thread, and returned as 0.
+func parseThreadID(s string) (int, error) {
+	if s == "-1" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	return int(n), err
+}
+
+func parseAddrLen(s string) (uint32, uint32, error) {
+	i := strings.IndexByte(s, ',')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid addr,length %q", s)
+	}
+	addr, err := strconv.ParseUint(s[:i], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(s[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(addr), uint32(n), nil
+}
@@ -0,0 +1,180 @@
+package gdbrsp_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/debug"
+	"github.com/jcorbin/stackvm/x/gdbrsp"
+)
+
+type testClient struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func checksum(s string) (sum byte) {
+	for i := 0; i < len(s); i++ {
+		sum += s[i]
+	}
+	return sum
+}
+
+// request sends a packet, returning the reply packet's data.
+func (tc *testClient) request(pkt string) string {
+	_, err := fmt.Fprintf(tc.c, "$%s#%02x", pkt, checksum(pkt))
+	require.NoError(tc.t, err)
+	ack, err := tc.r.ReadByte()
+	require.NoError(tc.t, err)
+	require.Equal(tc.t, byte('+'), ack, "expected %q to be acknowledged", pkt)
+
+	b, err := tc.r.ReadByte()
+	require.NoError(tc.t, err)
+	require.Equal(tc.t, byte('$'), b, "expected a reply to %q", pkt)
+	data, err := tc.r.ReadString('#')
+	require.NoError(tc.t, err)
+	data = data[:len(data)-1]
+	var sum [2]byte
+	_, err = tc.r.Read(sum[:1])
+	require.NoError(tc.t, err)
+	_, err = tc.r.Read(sum[1:])
+	require.NoError(tc.t, err)
+	assert.Equal(tc.t, fmt.Sprintf("%02x", checksum(data)), string(sum[:]), "bad reply checksum")
+	_, err = tc.c.Write([]byte{'+'})
+	require.NoError(tc.t, err)
+	return data
+}
+
+func le32(val uint32) string {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], val)
+	return hex.EncodeToString(buf[:])
+}
+
+func TestServer(t *testing.T) {
+	sess, err := debug.New(xstackvm.MustAssemble(
+		".data",
+		".out", "v:", 0,
+
+		".entry", "main:",
+		":other", "fork",
+		5, "push", ":v", "storeTo",
+		"brk",
+		"done:", "halt",
+		"other:", 6, "push", ":v", "storeTo",
+		1, "halt",
+	))
+	require.NoError(t, err, "unexpected session error")
+	v, err := sess.ResolveAddr("v")
+	require.NoError(t, err)
+	done, err := sess.ResolveAddr("done")
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	srv := gdbrsp.Server{Launch: func() (*debug.Session, error) { return sess, nil }}
+	served := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			err = srv.Serve(c)
+			c.Close()
+		}
+		served <- err
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	tc := testClient{t: t, c: c, r: bufio.NewReader(c)}
+
+	assert.Contains(t, tc.request("qSupported:multiprocess+;swbreak+"), "swbreak+")
+	assert.Equal(t, "T05thread:1;", tc.request("?"))
+	assert.Equal(t, "m1", tc.request("qfThreadInfo"))
+	assert.Equal(t, "l", tc.request("qsThreadInfo"))
+	assert.Contains(t, tc.request("qXfer:features:read:target.xml:0,fff"), `<reg name="cbp"`)
+
+	assert.Equal(t, "OK", tc.request(fmt.Sprintf("Z0,%x,1", done)))
+	assert.Equal(t, "OK", tc.request(fmt.Sprintf("Z2,%x,4", v)))
+
+	// fork queues a copy as a new thread
+	assert.Equal(t, "T05thread:1;", tc.request("s"))
+	assert.Equal(t, "m1,2", tc.request("qfThreadInfo"))
+
+	assert.Equal(t, fmt.Sprintf("T05thread:1;watch:%x;", v), tc.request("c"))
+	assert.Equal(t, le32(5), tc.request(fmt.Sprintf("m%x,4", v)))
+	assert.Equal(t, "OK", tc.request(fmt.Sprintf("M%x,4:%s", v, le32(7))))
+	assert.Equal(t, le32(7), tc.request(fmt.Sprintf("m%x,4", v)))
+
+	regs := tc.request("g")
+	require.Len(t, regs, 5*8)
+	assert.Equal(t, le32(sess.Current().Mach.IP()), regs[:8], "expected ip first")
+	assert.Equal(t, le32(sess.Current().Mach.CBP()), regs[32:], "expected cbp last")
+
+	// brk, then the breakpoint
+	assert.Equal(t, "T05thread:1;", tc.request("c"))
+	assert.Equal(t, "T05thread:1;swbreak:;", tc.request("c"))
+	assert.Equal(t, le32(done), tc.request("p0"))
+	assert.Equal(t, "OK", tc.request(fmt.Sprintf("z0,%x,1", done)))
+
+	// resume the copy, which sees its own memory
+	assert.Equal(t, "OK", tc.request("Hc2"))
+	assert.Equal(t, fmt.Sprintf("T05thread:2;watch:%x;", v), tc.request("c"))
+	assert.Equal(t, le32(6), tc.request(fmt.Sprintf("m%x,4", v)))
+	assert.Equal(t, "OK", tc.request("Hg1"))
+	assert.Equal(t, le32(7), tc.request(fmt.Sprintf("m%x,4", v)))
+	assert.True(t, strings.HasPrefix(tc.request("qThreadExtraInfo,2"), hex.EncodeToString([]byte("other+"))))
+
+	assert.Equal(t, "W00", tc.request("c"))
+	assert.Equal(t, "OK", tc.request("D"))
+	require.NoError(t, <-served, "unexpected serve error")
+}
+
+func TestServer_patch(t *testing.T) {
+	sess, err := debug.New(xstackvm.MustAssemble(
+		".data",
+		".out", "v:", 0,
+		"n:", 0,
+
+		".entry", "main:",
+		"patch:", 1, "push",
+		":v", "storeTo",
+		":n", "fetch", 1, "add", "dup", ":n", "storeTo",
+		2, "lt", ":patch", "jnz",
+		"brk",
+		"halt",
+	))
+	require.NoError(t, err, "unexpected session error")
+	v, err := sess.ResolveAddr("v")
+	require.NoError(t, err)
+	patch, err := sess.ResolveAddr("patch")
+	require.NoError(t, err)
+
+	sc, cc := net.Pipe()
+	srv := gdbrsp.Server{Launch: func() (*debug.Session, error) { return sess, nil }}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(sc) }()
+	tc := testClient{t: t, c: cc, r: bufio.NewReader(cc)}
+
+	// after running "1 push" once, patch it into "9 push", which the loop then
+	// runs instead
+	assert.Equal(t, "T05thread:1;", tc.request("s"))
+	assert.Equal(t, "8102", tc.request(fmt.Sprintf("m%x,2", patch)))
+	assert.Equal(t, "OK", tc.request(fmt.Sprintf("M%x,2:8902", patch)))
+	assert.Equal(t, "T05thread:1;", tc.request("c"), "expected to stop at brk")
+	assert.Equal(t, le32(9), tc.request(fmt.Sprintf("m%x,4", v)), "expected the patched op to run")
+
+	assert.Equal(t, "OK", tc.request("D"))
+	require.NoError(t, <-served, "unexpected serve error")
+}
@@ -0,0 +1,198 @@
+// Package link combines relocatable objects, as assembled by
+// xstackvm.AssembleObject, into a single machine program: references between
+// objects are resolved by their public symbols, while each object's local
+// symbols are renamed to be qualified by its name.
+package link
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	xstackvm "github.com/jcorbin/stackvm/x"
+)
+
+// limitOptions are merged by taking their largest value.
+var limitOptions = map[string]bool{
+	"stackSize": true,
+	"queueSize": true,
+	"maxOps":    true,
+	"maxCopies": true,
+	"maxDepth":  true,
+}
+
+// Program links objects, and assembles the result into a machine program.
+func Program(objs []*xstackvm.Object, opts ...xstackvm.Option) ([]byte, error) {
+	obj, err := Link(objs...)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Assemble(opts...)
+}
+
+// Link combines objects into one, whose code is that of each object in
+// order; it fails if any public symbol is defined by more than one object, or
+// if any reference can't be resolved.
+//
+// Options like .stackSize take the largest value given by any object, while
+// at most one object may set an .entry; all others, like input and output
+// regions, and semantic spans, are kept from each object.
+func Link(objs ...*xstackvm.Object) (*xstackvm.Object, error) {
+	var lk linker
+	if err := lk.collect(objs); err != nil {
+		return nil, err
+	}
+	for i, obj := range objs {
+		if err := lk.add(i, obj); err != nil {
+			return nil, err
+		}
+	}
+	if undefined := lk.out.Undefined(); len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined symbols: %q", undefined)
+	}
+	return &lk.out, nil
+}
+
+type linker struct {
+	out     xstackvm.Object
+	names   []string       // of each object
+	taken   map[string]int // every public or referenced symbol, and by which object
+	limits  map[string]int // index of each limit option within out.Options
+	entryBy string
+}
+
+func objName(i int, obj *xstackvm.Object) string {
+	if obj.Name != "" {
+		return obj.Name
+	}
+	return fmt.Sprintf("object%d", i+1)
+}
+
+// collect notes every public symbol, and every referenced label, so that
+// renamed local symbols can't collide with them.
+func (lk *linker) collect(objs []*xstackvm.Object) error {
+	lk.names = make([]string, len(objs))
+	lk.taken = make(map[string]int)
+	var dups []string
+	for i, obj := range objs {
+		lk.names[i] = objName(i, obj)
+		names := make([]string, 0, len(obj.Symbols))
+		for name, sym := range obj.Symbols {
+			if !sym.Local {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if j, defined := lk.taken[name]; defined && j >= 0 {
+				dups = append(dups, fmt.Sprintf("%q (in %s and %s)", name, lk.names[j], lk.names[i]))
+				continue
+			}
+			lk.taken[name] = i
+		}
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("duplicate symbols: %s", strings.Join(dups, ", "))
+	}
+	for _, obj := range objs {
+		for _, toks := range [][]xstackvm.ObjectToken{obj.Options, obj.Code} {
+			for _, tok := range toks {
+				if tok.Ref == nil {
+					continue
+				}
+				for _, label := range tok.Ref.Labels() {
+					if _, taken := lk.taken[label]; !taken {
+						lk.taken[label] = -1
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renamer returns a function that renames the i-th object's local symbols.
+func (lk *linker) renamer(i int, obj *xstackvm.Object) func(string) string {
+	locals := make([]string, 0, len(obj.Symbols))
+	for name, sym := range obj.Symbols {
+		if sym.Local {
+			locals = append(locals, name)
+		}
+	}
+	if len(locals) == 0 {
+		return func(name string) string { return name }
+	}
+	sort.Strings(locals)
+	renames := make(map[string]string, len(locals))
+	for _, name := range locals {
+		base := lk.names[i] + "." + strings.TrimPrefix(name, ".")
+		new := base
+		for n := 1; ; n++ {
+			if _, taken := lk.taken[new]; !taken {
+				break
+			}
+			new = fmt.Sprintf("%s.%d", base, n)
+		}
+		lk.taken[new] = i
+		renames[name] = new
+	}
+	return func(name string) string {
+		if new, renamed := renames[name]; renamed {
+			return new
+		}
+		return name
+	}
+}
+
+func (lk *linker) add(i int, obj *xstackvm.Object) error {
+	rename := lk.renamer(i, obj)
+
+	for _, tok := range obj.Options {
+		if tok.Ref != nil {
+			tok.Ref = tok.Ref.Rename(rename)
+		}
+		switch {
+		case tok.Kind == "opt" && limitOptions[tok.Name]:
+			lk.addLimit(tok)
+			continue
+		case tok.Kind == "opt" && tok.Name == "entry":
+			if lk.entryBy != "" {
+				return fmt.Errorf("duplicate .entry, in %s and %s", lk.entryBy, lk.names[i])
+			}
+			lk.entryBy = lk.names[i]
+		}
+		lk.out.Options = append(lk.out.Options, tok)
+	}
+
+	base := len(lk.out.Code)
+	for _, tok := range obj.Code {
+		if tok.Ref != nil {
+			tok.Ref = tok.Ref.Rename(rename)
+		}
+		lk.out.Code = append(lk.out.Code, tok)
+	}
+	for name, sym := range obj.Symbols {
+		if lk.out.Symbols == nil {
+			lk.out.Symbols = make(map[string]xstackvm.Symbol)
+		}
+		sym.At += base
+		lk.out.Symbols[rename(name)] = sym
+	}
+	for _, name := range obj.AddrLabels {
+		lk.out.AddrLabels = append(lk.out.AddrLabels, rename(name))
+	}
+	return nil
+}
+
+func (lk *linker) addLimit(tok xstackvm.ObjectToken) {
+	j, have := lk.limits[tok.Name]
+	if !have {
+		if lk.limits == nil {
+			lk.limits = make(map[string]int, len(limitOptions))
+		}
+		lk.limits[tok.Name] = len(lk.out.Options)
+		lk.out.Options = append(lk.out.Options, tok)
+	} else if prior := &lk.out.Options[j]; tok.Arg > prior.Arg {
+		prior.Arg, prior.Have = tok.Arg, tok.Have
+	}
+}
@@ -0,0 +1,105 @@
+package link_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/link"
+)
+
+func mustObject(t *testing.T, name string, in ...interface{}) *xstackvm.Object {
+	obj, err := xstackvm.AssembleObject(in...)
+	require.NoError(t, err, "unexpected assemble error for %s", name)
+	obj.Name = name
+
+	// round trip through serialization
+	var buf bytes.Buffer
+	_, err = obj.WriteTo(&buf)
+	require.NoError(t, err, "unexpected write error for %s", name)
+	obj, err = xstackvm.ReadObject(&buf)
+	require.NoError(t, err, "unexpected read error for %s", name)
+	return obj
+}
+
+func TestLink(t *testing.T) {
+	main := mustObject(t, "main",
+		".stackSize", 0x40,
+		".data",
+		".out", "v:", 0,
+		".out", "w:", 0,
+		".entry", "main:",
+		3, "push", ":quad", "call", ":v", "storeTo",
+		2, "push",
+		".loop:", 1, "sub", "dup", ":.loop", "jnz",
+		":w", "storeTo",
+		0, "halt",
+	)
+	lib := mustObject(t, "lib",
+		".stackSize", 0x80,
+		"quad:", ":.double", "call", ":.double", "call", "ret",
+		".double:", 2, "mul", "ret",
+		".loop:", "nop",
+	)
+	assert.Equal(t, []string{"quad"}, main.Undefined(), "expected main to reference quad")
+
+	prog, err := link.Program([]*xstackvm.Object{main, lib})
+	require.NoError(t, err, "unexpected link error")
+
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	require.NoError(t, err, "unexpected build error")
+	assert.Equal(t, uint32(0x7c), m.CBP(), "expected the largest stack size")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+	assert.Equal(t, map[string][]uint32{"v": {12}, "w": {0}}, vals)
+
+	labels := make(map[string]bool)
+	for _, addr := range dbg.LabeledAddrs() {
+		for _, label := range dbg.Labels(addr) {
+			labels[label] = true
+		}
+	}
+	for _, label := range []string{"main", "quad", "main.loop", "lib.double", "lib.loop"} {
+		assert.True(t, labels[label], "expected label %q", label)
+	}
+	spans := 0
+	for _, addr := range dbg.SpanAddrs() {
+		if open, _ := dbg.Span(addr); open {
+			spans++
+		}
+	}
+	assert.Equal(t, 2, spans, "expected spans opened by quad and .double")
+
+	for _, c := range []struct {
+		name string
+		objs []*xstackvm.Object
+		err  string
+	}{
+		{"undefined", []*xstackvm.Object{main}, `undefined symbols: ["quad"]`},
+		{"duplicate", []*xstackvm.Object{
+			main,
+			mustObject(t, "other", "quad:", ":.double", "call", "ret"),
+			lib,
+		}, `duplicate symbols: "quad" (in other and lib)`},
+		{"duplicate entry", []*xstackvm.Object{
+			main,
+			lib,
+			mustObject(t, "other", ".entry", "other:", 0, "halt"),
+		}, `duplicate .entry, in main and other`},
+		{"private ref", []*xstackvm.Object{
+			mustObject(t, "other", ":.double", "call", ":quad", "call", 0, "halt"),
+			lib,
+		}, `undefined symbols: [".double"]`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := link.Link(c.objs...)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package xstackvm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// Listing describes an assembled program, with an entry for each token
+// encoded after its options.
+type Listing []ListingEntry
+
+// ListingEntry describes an encoded program token.
+type ListingEntry struct {
+	Addr   uint32   // address of the token once loaded
+	Bytes  []byte   // encoding of the token
+	Token  string   // the token, e.g. "push 3" or ".data 7"
+	At     []int    // origin of the token within the input, see SourceMap
+	Labels []string // any labels defined at Addr
+}
+
+// WithListing sets a function to receive a listing of each program
+// assembled.
+func WithListing(f func(Listing)) Option {
+	return func(asm *assembler) {
+		asm.listf = f
+	}
+}
+
+// SourceMap sets a function that locates each program token's origin within
+// the input, so that the source position of its code may be embedded as
+// debug info (see stackvm.DebugInfo.Source); it should return the empty
+// string for any origin that it cannot locate.
+//
+// An origin is the index of the input token from which a program token was
+// assembled, preceded by those of any enclosing .include tokens; tokens
+// expanded from a macro originate from the token that expanded it.
+func SourceMap(locate func(at []int) string) Option {
+	return func(asm *assembler) {
+		asm.locate = locate
+	}
+}
+
+// String formats the listing, one entry per line, with its address, up to
+// 8 bytes of its encoding, its token, and any labels.
+func (lst Listing) String() string {
+	var buf bytes.Buffer
+	for _, ent := range lst {
+		fmt.Fprintf(&buf, "0x%04x  ", ent.Addr)
+		for i := 0; i < 8; i++ {
+			switch {
+			case i < len(ent.Bytes) && (i < 7 || len(ent.Bytes) == 8):
+				fmt.Fprintf(&buf, "%02x ", ent.Bytes[i])
+			case i < len(ent.Bytes):
+				buf.WriteString(".. ")
+			default:
+				buf.WriteString("   ")
+			}
+		}
+		fmt.Fprintf(&buf, " %s", ent.Token)
+		if len(ent.Labels) > 0 {
+			fmt.Fprintf(&buf, "  ; %s:", strings.Join(ent.Labels, ": "))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func (enc *encoder) addr(i int) uint32 {
+	return enc.base + enc.offsets[i] - enc.boff
+}
+
+func (enc *encoder) listing() Listing {
+	labels := make(map[int][]string, len(enc.labels))
+	for name, i := range enc.labels {
+		labels[i] = append(labels[i], name)
+	}
+	lst := make(Listing, 0, len(enc.toks)-enc.nopts)
+	for i := enc.nopts; i < len(enc.toks); i++ {
+		lo, hi := enc.offsets[i], enc.offsets[i+1]
+		ent := ListingEntry{
+			Addr:   enc.addr(i),
+			Bytes:  append([]byte(nil), enc.buf[lo:hi]...),
+			Token:  enc.toks[i].String(),
+			At:     enc.srcAt(i),
+			Labels: labels[i],
+		}
+		sort.Strings(ent.Labels)
+		lst = append(lst, ent)
+	}
+	return lst
+}
+
+// addSourceMap returns the encoded program with a sourceMap option added
+// before its end option. Since code addresses are relative to the end of the
+// options, they're unaffected.
+func (enc *encoder) addSourceMap(prog []byte, locate func(at []int) string) []byte {
+	var ents []token
+	last := ""
+	for i := enc.nopts; i < len(enc.toks); i++ {
+		if src := enc.locateAt(i, locate); src != last {
+			ents = append(ents, token{kind: addrLabelTK, str: src, Op: stackvm.Op{Arg: enc.addr(i)}})
+			last = src
+		}
+	}
+	if len(ents) == 0 {
+		return prog
+	}
+	if last != "" {
+		// mark the end of the program, so that any memory after it isn't
+		// attributed to its last source
+		ents = append(ents, token{kind: addrLabelTK, Op: stackvm.Op{Arg: enc.addr(len(enc.toks))}})
+	}
+
+	opt := optToken("sourceMap", uint32(len(ents)), true)
+	n := opt.NeededSize()
+	for _, tok := range ents {
+		n += tok.NeededSize()
+	}
+	at := enc.offsets[enc.nopts-1] // of the end option
+	buf := make([]byte, 0, len(prog)+n)
+	buf = append(buf, prog[:at]...)
+	p := make([]byte, n)
+	n = opt.EncodeInto(p)
+	for _, tok := range ents {
+		n += tok.EncodeInto(p[n:])
+	}
+	buf = append(buf, p[:n]...)
+	return append(buf, prog[at:]...)
+}
+
+func (enc *encoder) locateAt(i int, locate func(at []int) string) string {
+	if at := enc.srcAt(i); at != nil {
+		return locate(at)
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_listing(t *testing.T) {
+	src, err := ParseSource("list.svm", strings.NewReader(`
+.macro inc $v
+	:$v fetch 1 add :$v storeTo
+.endm
+.data
+.out v: 0
+.text
+.entry main:
+	.inc v
+	.inc v
+	0 halt
+`))
+	require.NoError(t, err, "unexpected parse error")
+
+	var lst Listing
+	prog, err := src.Assemble(WithListing(func(l Listing) { lst = l }), src.SourceMap())
+	require.NoError(t, err, "unexpected assemble error")
+	plain, err := src.Assemble()
+	require.NoError(t, err, "unexpected assemble error")
+	assert.True(t, len(prog) > len(plain), "expected an embedded source map")
+
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+	assert.Equal(t, map[string][]uint32{"v": {2}}, vals)
+
+	require.NotEmpty(t, lst, "expected a listing")
+	assert.Equal(t, uint32(0x40), lst[0].Addr, "expected listing to start after the stack")
+	assert.Equal(t, ".data 0", lst[0].Token)
+	assert.Equal(t, []string{"v"}, lst[0].Labels)
+	for i := 1; i < len(lst); i++ {
+		assert.Equal(t, lst[i-1].Addr+uint32(len(lst[i-1].Bytes)), lst[i].Addr,
+			"expected listing entry %v to follow the last", lst[i])
+	}
+
+	var tokens []string
+	for _, ent := range lst {
+		tokens = append(tokens, ent.Token)
+		pos, ok := src.Position(ent.At)
+		require.True(t, ok, "expected a position for %v", ent)
+		assert.Equal(t, pos.String(), dbg.Source(ent.Addr), "expected source of %v", ent)
+	}
+	assert.Equal(t, []string{
+		".data 0", `.string "v"`,
+		"@0x0040 fetch (:v)", "1 add", "@0x0040 storeTo (:v)",
+		"@0x0040 fetch (:v)", "1 add", "@0x0040 storeTo (:v)",
+		"0 halt",
+	}, tokens)
+	assert.Equal(t, "list.svm:9:2", dbg.Source(lst[2].Addr), "expected macro code at its expansion")
+	assert.Equal(t, "list.svm:10:2", dbg.Source(lst[5].Addr), "expected macro code at its expansion")
+	assert.Equal(t, "list.svm:11:2", dbg.Source(lst[8].Addr+1), "expected source within an op")
+	assert.Equal(t, "", dbg.Source(0), "expected no source for the stack")
+	assert.Equal(t, "", dbg.Source(lst[8].Addr+uint32(len(lst[8].Bytes))), "expected no source after the program")
+
+	assert.Contains(t, lst.String(), "0x0040  00 00 00 00")
+	assert.Contains(t, lst.String(), ".data 0  ; v:")
+}
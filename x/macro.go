@@ -0,0 +1,283 @@
+package xstackvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errNestedMacro    = errors.New("nested .macro definition")
+	errUnexpectedEndm = errors.New("unexpected .endm outside of .macro")
+)
+
+// macro is a parameterized template of tokens, defined by:
+//
+//	".macro", "name", "$param", ...
+//	    ...body tokens...
+//	".endm",
+//
+// Parameters are the distinct "$ident" tokens that immediately follow the
+// name; the body starts with the first token that isn't one (so a body may
+// start by using a parameter that it has already declared).
+//
+// A macro is expanded by the ".name" directive, which takes one argument token
+// for each parameter. Within the expanded body, any token that is exactly
+// "$param" is replaced by its argument, and any "$param" within a larger
+// string (e.g. ":$param" or "$param_loop:") is replaced by its int or string
+// argument.
+//
+// Labels defined by the body itself (rather than by way of a parameter) are
+// local to each expansion: they, and any references to them, are renamed to
+// fresh ".name.label.N" labels.
+type macro struct {
+	name   string
+	params []string
+	body   []interface{}
+	locals []string
+}
+
+// localLabel is a label definition within a macro expansion; its name is
+// generated, and can't be defined by any other token.
+type localLabel string
+
+// reservedDirectives are builtin directive names that may not be used to name
+// a macro.
+var reservedDirectives = map[string]bool{
+	"entry": true, "stackSize": true, "queueSize": true, "maxOps": true,
+	"maxCopies": true, "maxDepth": true, "data": true, "text": true,
+	"include": true, "spanOpen": true, "spanClose": true, "alloc": true,
+	"in": true, "out": true, "macro": true, "endm": true, "const": true,
+	"export": true, "scope": true, "if": true, "else": true, "while": true,
+	"do": true, "forkeach": true, "end": true,
+}
+
+func (sc *scanner) handleMacro() error {
+	name, err := sc.expectString(".macro name")
+	if err != nil {
+		return err
+	}
+	if !isIdent(name) {
+		return fmt.Errorf("invalid .macro name %q", name)
+	}
+	if reservedDirectives[name] {
+		return fmt.Errorf("invalid .macro name %q, reserved for a directive", name)
+	}
+	if _, defined := sc.macros[name]; defined {
+		return fmt.Errorf("macro %q already defined", name)
+	}
+
+	mac := &macro{name: name}
+	for sc.i+1 < len(sc.in) {
+		s, ok := sc.in[sc.i+1].(string)
+		if !ok || !isParam(s) || mac.param(s[1:]) >= 0 {
+			break
+		}
+		mac.params = append(mac.params, s[1:])
+		sc.i++
+	}
+
+	for {
+		val, err := sc.expect(`".endm"`)
+		if err != nil {
+			return err
+		}
+		if s, ok := val.(string); ok {
+			switch s {
+			case ".endm":
+				mac.locals = collectLocals(mac.body, nil)
+				if sc.macros == nil {
+					sc.macros = make(map[string]*macro, 1)
+				}
+				sc.macros[name] = mac
+				return nil
+			case ".macro":
+				return errNestedMacro
+			}
+		}
+		mac.body = append(mac.body, val)
+	}
+}
+
+func (sc *scanner) expandMacro(mac *macro) error {
+	at := sc.i
+	for _, st := range sc.prior {
+		if st.macro == mac.name {
+			return fmt.Errorf("recursive expansion of .%s", mac.name)
+		}
+	}
+	if sc.macro == mac.name {
+		return fmt.Errorf("recursive expansion of .%s", mac.name)
+	}
+
+	args := make([]interface{}, len(mac.params))
+	for i, param := range mac.params {
+		val, err := sc.expect(fmt.Sprintf(".%s $%s", mac.name, param))
+		if err != nil {
+			return err
+		}
+		args[i] = val
+	}
+
+	renames := make(map[string]string, len(mac.locals))
+	for _, label := range mac.locals {
+		local := sc.prog.genLabel(fmt.Sprintf(".%s.%s", mac.name, strings.TrimPrefix(label, ".")))
+		sc.prog.stubLabel(local)
+		renames[label] = local
+	}
+
+	body, err := mac.expand(mac.body, args, renames)
+	if err != nil {
+		return err
+	}
+	sc.pushMacro(mac.name, at, body)
+	return nil
+}
+
+func (mac *macro) param(name string) int {
+	for i, param := range mac.params {
+		if param == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (mac *macro) expand(in, args []interface{}, renames map[string]string) ([]interface{}, error) {
+	out := make([]interface{}, len(in))
+	for i, val := range in {
+		switch v := val.(type) {
+		case []interface{}:
+			sub, err := mac.expand(v, args, renames)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sub
+		case string:
+			tok, err := mac.expandString(v, args, renames)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = tok
+		default:
+			out[i] = val
+		}
+	}
+	return out, nil
+}
+
+func (mac *macro) expandString(s string, args []interface{}, renames map[string]string) (interface{}, error) {
+	if label, ok := labelName(s); ok {
+		if local, ok := renames[label]; ok {
+			return localLabel(local), nil
+		}
+	} else if strings.IndexByte(s, ':') >= 0 {
+		s = renameRefs(s, renames)
+	}
+
+	if isParam(s) {
+		if i := mac.param(s[1:]); i >= 0 {
+			return args[i], nil
+		}
+	}
+
+	var parts []string
+	for rest := s; ; {
+		j := strings.IndexByte(rest, '$')
+		if j < 0 {
+			if parts == nil {
+				return s, nil
+			}
+			return strings.Join(append(parts, rest), ""), nil
+		}
+		k := j + 1
+		for k < len(rest) && isIdentByte(rest[k]) {
+			k++
+		}
+		i := mac.param(rest[j+1 : k])
+		if i < 0 {
+			return nil, fmt.Errorf("undefined .%s parameter %q in %q", mac.name, rest[j:k], s)
+		}
+		switch arg := args[i].(type) {
+		case int:
+			parts = append(parts, rest[:j], fmt.Sprint(arg))
+		case string:
+			parts = append(parts, rest[:j], arg)
+		default:
+			return nil, fmt.Errorf("invalid .%s argument %T(%v) for %q; expected an int or string",
+				mac.name, args[i], args[i], s)
+		}
+		rest = rest[k:]
+	}
+}
+
+// renameRefs renames any ":label" references within s, which may be an
+// expression like ":end-:start".
+func renameRefs(s string, renames map[string]string) string {
+	var parts []string
+	for rest := s; ; {
+		j := strings.IndexByte(rest, ':')
+		if j < 0 {
+			if parts == nil {
+				return s
+			}
+			return strings.Join(append(parts, rest), "")
+		}
+		k := j + 1
+		for k < len(rest) && strings.IndexByte(exprOps, rest[k]) < 0 {
+			k++
+		}
+		label := rest[j+1 : k]
+		if local, ok := renames[label]; ok {
+			label = local
+		}
+		parts = append(parts, rest[:j+1], label)
+		rest = rest[k:]
+	}
+}
+
+// collectLocals returns the names of labels defined by the given body tokens,
+// not counting any whose names involve a parameter.
+func collectLocals(body []interface{}, locals []string) []string {
+	for _, val := range body {
+		switch v := val.(type) {
+		case []interface{}:
+			locals = collectLocals(v, locals)
+		case string:
+			if label, ok := labelName(v); ok && !strings.ContainsRune(label, '$') {
+				locals = append(locals, label)
+			}
+		}
+	}
+	return locals
+}
+
+func labelName(s string) (string, bool) {
+	if len(s) > 1 && s[0] != ':' && s[len(s)-1] == ':' {
+		return s[:len(s)-1], true
+	}
+	return "", false
+}
+
+func isParam(s string) bool {
+	return len(s) > 1 && s[0] == '$' && isIdent(s[1:])
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' ||
+		'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9'
+}
@@ -0,0 +1,131 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_macro(t *testing.T) {
+	header := []interface{}{
+		".data",
+		".out", "a:", 0,
+		".out", "b:", 0,
+	}
+	sumMacro := []interface{}{
+		".macro", "sum", "$n", "$out", // : -- :   -- $out = 1 + 2 + ... + $n
+		".spanOpen", "sum_$out:",
+		"$n", "push", // i :
+		"loop:",
+		"dup", ":$out", "fetch", "add", ":$out", "storeTo", // i :   -- $out += i
+		1, "sub", "dup", ":loop", "jnz", // i-1 :
+		"pop",
+		".spanClose",
+		".endm",
+	}
+	sumInline := func(n int, out string) []interface{} {
+		return []interface{}{
+			".spanOpen", "sum_" + out + ":",
+			n, "push",
+			"loop_" + out + ":",
+			"dup", ":" + out, "fetch", "add", ":" + out, "storeTo",
+			1, "sub", "dup", ":loop_" + out, "jnz",
+			"pop",
+			".spanClose",
+		}
+	}
+
+	var expanded []interface{}
+	expanded = append(expanded, header...)
+	expanded = append(expanded, sumMacro...)
+	expanded = append(expanded,
+		".entry", "main:",
+		".sum", 3, "a",
+		".sum", 4, "b",
+		0, "halt",
+	)
+
+	var inlined []interface{}
+	inlined = append(inlined, header...)
+	inlined = append(inlined, ".entry", "main:")
+	inlined = append(inlined, sumInline(3, "a")...)
+	inlined = append(inlined, sumInline(4, "b")...)
+	inlined = append(inlined, 0, "halt")
+
+	run := func(prog []byte) (map[string][]uint32, stackvm.DebugInfo) {
+		var dbg stackvm.DebugInfo
+		m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+		require.NoError(t, err, "unexpected build error")
+		require.NoError(t, m.Run(), "unexpected run error")
+		vals, err := m.NamedValues()
+		require.NoError(t, err, "unexpected values error")
+		require.NotNil(t, dbg, "expected debug info")
+		return vals, dbg
+	}
+
+	expVals, expDbg := run(MustAssemble(inlined...))
+	vals, dbg := run(MustAssemble(expanded...))
+	assert.Equal(t, map[string][]uint32{"a": {6}, "b": {10}}, vals, "expected output values")
+	assert.Equal(t, expVals, vals, "expected same values as inlined code")
+	require.Len(t, dbg.SpanAddrs(), len(expDbg.SpanAddrs()), "expected as many spans as inlined code")
+	for _, addr := range expDbg.SpanAddrs() {
+		expOpen, expClose := expDbg.Span(addr)
+		open, close := dbg.Span(addr)
+		assert.Equal(t, expOpen, open, "expected same span open @0x%04x", addr)
+		assert.Equal(t, expClose, close, "expected same span close @0x%04x", addr)
+	}
+
+	for _, addr := range expDbg.LabeledAddrs() {
+		for _, label := range expDbg.Labels(addr) {
+			switch label {
+			case "loop_a":
+				assert.Equal(t, []string{".sum.loop.1"}, dbg.Labels(addr), "expected first local label")
+			case "loop_b":
+				assert.Equal(t, []string{".sum.loop.2"}, dbg.Labels(addr), "expected second local label")
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		in   []interface{}
+		err  string
+	}{
+		{"unterminated", []interface{}{".macro", "m", "$x", "$x", "push"}, `unexpected end of input, expected ".endm"`},
+		{"nested", []interface{}{".macro", "m", ".macro", "n", ".endm", ".endm"}, "nested .macro definition"},
+		{"stray endm", []interface{}{".endm"}, "unexpected .endm outside of .macro"},
+		{"reserved name", []interface{}{".macro", "data", ".endm"}, `invalid .macro name "data", reserved for a directive`},
+		{"redefined", []interface{}{".macro", "m", ".endm", ".macro", "m", ".endm"}, `macro "m" already defined`},
+		{"missing arg", []interface{}{".macro", "m", "$x", "$x", "push", ".endm", ".m"}, "unexpected end of input, expected .m $x"},
+		{"undefined param", []interface{}{".macro", "m", "$x", ":$y", "jump", ".endm", ".m", 1}, `undefined .m parameter "$y" in ":$y"`},
+		{"recursive", []interface{}{".macro", "m", ".m", ".endm", ".m"}, "in .m: recursive expansion of .m"},
+		{"error in expansion", []interface{}{
+			".macro", "m", "$op", 1, "$op", ".endm",
+			".macro", "n", ".m", "frob", ".endm",
+			".n",
+		}, `in .n: in .m: no such operation "frob"`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Assemble(c.in...)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+
+	src, err := ParseSource("m.svm", strings.NewReader(`
+.macro twice $op
+	$op $op
+.endm
+.entry main:
+	1 push 2 push
+	.twice add
+	.twice frob
+`))
+	require.NoError(t, err, "unexpected parse error")
+	_, err = src.Assemble()
+	assert.EqualError(t, err, `m.svm:8:2: in .twice: no such operation "frob"`)
+}
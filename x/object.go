@@ -0,0 +1,292 @@
+package xstackvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jcorbin/stackvm"
+)
+
+// Object is a relocatable program, assembled but not yet encoded, so that it
+// may be linked with others (see package x/link) before being encoded into a
+// machine program by its Assemble method. References to labels that it doesn't
+// define are left unresolved.
+//
+// Objects may be serialized as JSON, by WriteTo and ReadObject.
+type Object struct {
+	Name    string            `json:"name,omitempty"`
+	Options []ObjectToken     `json:"options,omitempty"`
+	Code    []ObjectToken     `json:"code"`
+	Symbols map[string]Symbol `json:"symbols,omitempty"`
+
+	// AddrLabels lists the labels given as debug info, in order.
+	AddrLabels []string `json:"addrLabels,omitempty"`
+}
+
+// ObjectToken is an option or program token within an Object.
+type ObjectToken struct {
+	Kind string     `json:"kind"`           // "opt", "op", "data", "alloc", or "string"
+	Name string     `json:"name,omitempty"` // of an option or operation
+	Arg  uint32     `json:"arg,omitempty"`
+	Have bool       `json:"have,omitempty"`
+	Str  string     `json:"str,omitempty"` // of a string
+	Ref  *ObjectRef `json:"ref,omitempty"` // resolves Arg, if any
+	Src  string     `json:"src,omitempty"` // source position, if known
+}
+
+// ObjectRef is a reference from an ObjectToken to a label, offset by Off,
+// plus any further scaled label addresses from an expression.
+type ObjectRef struct {
+	Label string       `json:"label"`
+	Off   int          `json:"off,omitempty"`
+	Terms []ObjectTerm `json:"terms,omitempty"`
+}
+
+// ObjectTerm is a scaled label address within an ObjectRef.
+type ObjectTerm struct {
+	Label string `json:"label"`
+	Scale int    `json:"scale"`
+}
+
+// Symbol is a label defined by an Object, at the index of a code token (or
+// at the end of the code). Local symbols, those named like ".loop" or private
+// to an included scope, may not be referenced by other objects.
+type Symbol struct {
+	At    int  `json:"at"`
+	Local bool `json:"local,omitempty"`
+}
+
+// Labels returns the labels referenced by the ref.
+func (rf ObjectRef) Labels() []string {
+	labels := make([]string, 0, 1+len(rf.Terms))
+	labels = append(labels, rf.Label)
+	for _, t := range rf.Terms {
+		labels = append(labels, t.Label)
+	}
+	return labels
+}
+
+// Rename returns a copy of the ref with any labels renamed by the given
+// function.
+func (rf ObjectRef) Rename(f func(string) string) *ObjectRef {
+	rf.Label = f(rf.Label)
+	if len(rf.Terms) > 0 {
+		terms := make([]ObjectTerm, len(rf.Terms))
+		for i, t := range rf.Terms {
+			terms[i] = ObjectTerm{f(t.Label), t.Scale}
+		}
+		rf.Terms = terms
+	}
+	return &rf
+}
+
+// AssembleObject assembles an Object from tokens, like Assemble.
+func AssembleObject(in ...interface{}) (*Object, error) {
+	return NewAssembler().AssembleObject(in...)
+}
+
+// ReadObject reads an Object written by WriteTo.
+func ReadObject(r io.Reader) (*Object, error) {
+	var obj Object
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// WriteTo writes the object as JSON.
+func (obj *Object) WriteTo(w io.Writer) (int64, error) {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(buf, '\n'))
+	return int64(n), err
+}
+
+// Undefined returns the labels that the object references, but doesn't
+// define.
+func (obj *Object) Undefined() []string {
+	var undefined []string
+	noted := make(map[string]bool)
+	note := func(toks []ObjectToken) {
+		for _, tok := range toks {
+			if tok.Ref == nil {
+				continue
+			}
+			for _, label := range tok.Ref.Labels() {
+				if _, defined := obj.Symbols[label]; !defined && !noted[label] {
+					noted[label] = true
+					undefined = append(undefined, label)
+				}
+			}
+		}
+	}
+	note(obj.Options)
+	note(obj.Code)
+	sort.Strings(undefined)
+	return undefined
+}
+
+// Assemble encodes the object into a machine program; it fails if the object
+// has any unresolved references. Any source positions within the object are
+// embedded as debug info; within a Listing, each token originates from the
+// index of its code token.
+func (obj *Object) Assemble(opts ...Option) ([]byte, error) {
+	var asm assembler
+	for _, opt := range opts {
+		opt(&asm)
+	}
+	if err := asm.loadObject(obj); err != nil {
+		return nil, err
+	}
+	return asm.assemble()
+}
+
+func (asm *assembler) object() *Object {
+	obj := &Object{
+		Options: asm.opts.objectTokens(nil),
+		Code:    asm.prog.objectTokens(asm.locate),
+	}
+	for name, i := range asm.prog.labels {
+		if i < 0 {
+			continue
+		}
+		if obj.Symbols == nil {
+			obj.Symbols = make(map[string]Symbol)
+		}
+		obj.Symbols[name] = Symbol{At: i, Local: name[0] == '.' || asm.locals[name]}
+	}
+	for _, tok := range asm.adls.toks {
+		if tok.kind == addrLabelTK {
+			obj.AddrLabels = append(obj.AddrLabels, tok.str)
+		}
+	}
+	return obj
+}
+
+func (sec section) objectTokens(locate func(at []int) string) []ObjectToken {
+	otoks := make([]ObjectToken, len(sec.toks))
+	for i, tok := range sec.toks {
+		otok := ObjectToken{Kind: tok.kind.String()}
+		switch tok.kind {
+		case optTK, opTK:
+			otok.Name = tok.Name()
+			otok.Arg = tok.Arg
+			otok.Have = tok.Have
+		case dataTK, allocTK:
+			otok.Arg = tok.Arg
+		case stringTK:
+			otok.Str = tok.str
+		}
+		if locate != nil {
+			if at := sec.srcAt(i); at != nil {
+				otok.Src = locate(at)
+			}
+		}
+		otoks[i] = otok
+	}
+	for _, nrf := range sec.refs {
+		rf := &ObjectRef{Label: nrf.targName, Off: nrf.off}
+		for _, t := range nrf.terms {
+			rf.Terms = append(rf.Terms, ObjectTerm{t.label, t.scale})
+		}
+		otoks[nrf.site].Ref = rf
+	}
+	return otoks
+}
+
+func (asm *assembler) loadObject(obj *Object) error {
+	asm.opts = makeSection()
+	asm.prog = makeSection()
+
+	for i, otok := range obj.Options {
+		if err := asm.opts.addObjectToken(otok); err != nil {
+			return fmt.Errorf("invalid options[%d]: %v", i, err)
+		}
+	}
+	asm.optToks = nil
+	for i, tok := range asm.opts.toks {
+		if tok.kind == optTK && tok.Name() == "stackSize" {
+			asm.optToks = map[string]int{"stackSize": i}
+		}
+	}
+	if asm.optToks == nil {
+		asm.setOption("stackSize", defaultStackSize)
+	}
+
+	hasSrc := false
+	for i, otok := range obj.Code {
+		if otok.Src != "" {
+			hasSrc = true
+			asm.prog.src = []int{i}
+		} else {
+			asm.prog.src = nil
+		}
+		if err := asm.prog.addObjectToken(otok); err != nil {
+			return fmt.Errorf("invalid code[%d]: %v", i, err)
+		}
+	}
+	asm.prog.src = nil
+	if hasSrc {
+		asm.locate = func(at []int) string { return obj.Code[at[0]].Src }
+	}
+
+	for name, sym := range obj.Symbols {
+		if sym.At < 0 || sym.At > len(obj.Code) {
+			return fmt.Errorf("invalid symbol %q at %d", name, sym.At)
+		}
+		if asm.prog.labels == nil {
+			asm.prog.labels = make(map[string]int, len(obj.Symbols))
+		}
+		asm.prog.labels[name] = sym.At
+	}
+	for _, name := range obj.AddrLabels {
+		asm.addAddrLabel(name)
+	}
+	return nil
+}
+
+func (sec *section) addObjectToken(otok ObjectToken) error {
+	var tok token
+	switch otok.Kind {
+	case "opt":
+		tok = optToken(otok.Name, otok.Arg, otok.Have)
+		if stackvm.NameOption(tok.Code) != otok.Name {
+			return fmt.Errorf("invalid option %q", otok.Name)
+		}
+	case "op":
+		op, err := stackvm.ResolveOp(otok.Name, otok.Arg, otok.Have)
+		if err != nil {
+			return err
+		}
+		tok = opToken(op)
+	case "data":
+		tok = dataToken(otok.Arg)
+	case "alloc":
+		tok = allocToken(otok.Arg)
+	case "string":
+		tok = stringToken(otok.Str)
+	default:
+		return fmt.Errorf("invalid token kind %q", otok.Kind)
+	}
+
+	if otok.Ref == nil {
+		sec.add(tok)
+		return nil
+	}
+	if tok.kind != optTK && tok.kind != opTK {
+		return fmt.Errorf("%v token does not accept ref args", tok.kind)
+	}
+	tok.str = otok.Ref.Label
+	nrf := namedRef{targName: otok.Ref.Label, ref: ref{site: len(sec.toks), off: otok.Ref.Off}}
+	for _, t := range otok.Ref.Terms {
+		nrf.terms = append(nrf.terms, exprTerm{t.Label, t.Scale})
+	}
+	sec.refs = append(sec.refs, nrf)
+	sec.noteSrc()
+	sec.toks = append(sec.toks, tok)
+	return nil
+}
@@ -0,0 +1,273 @@
+package xstackvm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/cfg"
+)
+
+// Optimize sets an Assembler to rewrite each program with peephole
+// optimizations before encoding it:
+//   - "push pop", "dup pop", and "swap swap" pairs are removed
+//   - "dup swap" becomes "dup"
+//   - a push followed by an op that may take its value as an immediate is
+//     folded into that op, e.g. "3 push add" becomes "3 add"
+//   - jumps to unconditional jumps are retargeted to their final target
+//   - jumps to the next op are removed
+//
+// Sequences that have a label within them are left as they are, so that
+// labels, and any spans or debug info referencing them, stay consistent. If
+// the report function isn't nil, it receives a report of each program (or
+// object) optimized.
+func Optimize(report func(OptimizeReport)) Option {
+	return func(asm *assembler) {
+		asm.optimize = true
+		asm.optReport = report
+	}
+}
+
+// OptimizeReport counts the operations of a program, before and after it has
+// been optimized, and how many times each rewrite was applied.
+type OptimizeReport struct {
+	Before, After map[string]int
+	Rewrites      map[string]int
+}
+
+func (rep OptimizeReport) String() string {
+	names := make([]string, 0, len(rep.Before))
+	for name := range rep.Before {
+		names = append(names, name)
+	}
+	for name := range rep.After {
+		if _, counted := rep.Before[name]; !counted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	before, after := 0, 0
+	fmt.Fprintf(&buf, "%-10s %7s %7s\n", "op", "before", "after")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%-10s %7d %7d\n", name, rep.Before[name], rep.After[name])
+		before += rep.Before[name]
+		after += rep.After[name]
+	}
+	fmt.Fprintf(&buf, "%-10s %7d %7d\n", "total", before, after)
+
+	rules := make([]string, 0, len(rep.Rewrites))
+	for rule := range rep.Rewrites {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		fmt.Fprintf(&buf, "rewrote %q %d times\n", rule, rep.Rewrites[rule])
+	}
+	return buf.String()
+}
+
+// foldOps are those ops whose immediate form is the same as pushing their
+// immediate first.
+var foldOps = map[string]bool{
+	"fetch": true, "store": true, "storeTo": true,
+	"add": true, "sub": true, "mul": true, "div": true, "mod": true,
+	"lt": true, "lte": true, "gt": true, "eq": true, "neq": true,
+	"bitand": true, "bitor": true, "bitxor": true,
+	"shiftl": true, "shiftr": true,
+}
+
+func (asm *assembler) optimizeProg() error {
+	rep := OptimizeReport{
+		Before:   asm.prog.opCounts(),
+		Rewrites: make(map[string]int),
+	}
+	for pass := 1; pass <= len(asm.prog.toks); pass++ {
+		done, err := asm.prog.peephole(rep.Rewrites)
+		if err != nil {
+			return err
+		}
+		if done == 0 {
+			asm.logf("optimized in %d passes", pass)
+			break
+		}
+	}
+	rep.After = asm.prog.opCounts()
+	if asm.optReport != nil {
+		asm.optReport(rep)
+	}
+	return nil
+}
+
+func (sec section) opCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, tok := range sec.toks {
+		if tok.kind == opTK {
+			counts[tok.Name()]++
+		}
+	}
+	return counts
+}
+
+// plainOp returns true if the token is the named op, without any immediate,
+// or with an immediate of 1 if one is allowed.
+func plainOp(tok token, name string, one bool) bool {
+	if tok.kind != opTK || tok.Name() != name {
+		return false
+	}
+	return !tok.Have || (one && tok.Arg == 1)
+}
+
+// peephole does one pass of rewrites over the section, counting each one
+// done; it returns how many were done, or any error re-encoding a rewritten
+// op.
+func (sec *section) peephole(rewrites map[string]int) (int, error) {
+	n := len(sec.toks)
+	labeled := make([]bool, n+1)
+	for _, i := range sec.labels {
+		if i >= 0 {
+			labeled[i] = true
+		}
+	}
+	refAt := make(map[int]int, len(sec.refs))
+	for j, nrf := range sec.refs {
+		refAt[nrf.site] = j
+	}
+	simpleRef := func(i int) (int, bool) {
+		j, has := refAt[i]
+		return j, has && sec.refs[j].off == 0 && len(sec.refs[j].terms) == 0
+	}
+
+	var (
+		drop = make([]bool, n)
+		done = 0
+	)
+	note := func(rule string) {
+		rewrites[rule]++
+		done++
+	}
+
+	for i := 0; i < n; i++ {
+		tok := sec.toks[i]
+		if tok.kind != opTK {
+			continue
+		}
+
+		switch tok.Name() {
+		case "jump", "jnz", "jz":
+			j, ok := simpleRef(i)
+			if !ok {
+				break
+			}
+			targ, defined := sec.labels[sec.refs[j].targName]
+			if !defined {
+				break
+			}
+			if tok.Name() == "jump" && targ == i+1 {
+				drop[i] = true
+				note("jump next")
+				continue
+			}
+			if targ < 0 || targ >= n || !plainJump(sec.toks[targ]) {
+				break
+			}
+			if k, ok := simpleRef(targ); ok && sec.refs[k].targName != sec.refs[j].targName {
+				sec.refs[j].targName = sec.refs[k].targName
+				sec.toks[i].str = sec.refs[k].targName
+				note("jump jump")
+			}
+			continue
+		}
+
+		if i+1 >= n || labeled[i+1] {
+			continue
+		}
+		next := sec.toks[i+1]
+		if _, has := refAt[i+1]; has || next.kind != opTK {
+			continue
+		}
+		switch {
+		case tok.Name() == "push" && plainOp(next, "pop", true):
+			note("push pop")
+		case plainOp(tok, "dup", true) && plainOp(next, "pop", true):
+			note("dup pop")
+		case plainOp(tok, "swap", false) && plainOp(next, "swap", false):
+			note("swap swap")
+		case plainOp(tok, "dup", true) && plainOp(next, "swap", false):
+			drop[i+1] = true
+			note("dup swap")
+			i++
+			continue
+		case tok.Name() == "push" && !next.Have && foldOps[next.Name()]:
+			op, err := stackvm.ResolveOp(next.Name(), tok.Arg, true)
+			if err != nil {
+				return 0, fmt.Errorf("can't fold push into %s: %v", next.Name(), err)
+			}
+			sec.toks[i].Op = op
+			drop[i+1] = true
+			note("push " + next.Name())
+			i++
+			continue
+		default:
+			continue
+		}
+		drop[i], drop[i+1] = true, true
+		i++
+	}
+
+	if done > 0 {
+		sec.compact(drop)
+	}
+	return done, nil
+}
+
+func plainJump(tok token) bool {
+	return tok.kind == opTK && tok.Name() == "jump"
+}
+
+// compact removes any dropped tokens from the section, moving any labels at
+// them on to the next token kept.
+func (sec *section) compact(drop []bool) {
+	n := len(sec.toks)
+	idx := make([]int, n+1)
+	k := 0
+	for i := 0; i < n; i++ {
+		idx[i] = k
+		if drop[i] {
+			continue
+		}
+		sec.toks[k] = sec.toks[i]
+		if i < len(sec.srcs) {
+			sec.srcs[k] = sec.srcs[i]
+		}
+		k++
+	}
+	idx[n] = k
+	sec.toks = sec.toks[:k]
+	if len(sec.srcs) > k {
+		sec.srcs = sec.srcs[:k]
+	}
+
+	for name, i := range sec.labels {
+		if i >= 0 {
+			sec.labels[name] = idx[i]
+		}
+	}
+	if len(sec.effects) > 0 {
+		effects := make(map[int]cfg.Effect, len(sec.effects))
+		for i, eff := range sec.effects {
+			effects[idx[i]] = eff
+		}
+		sec.effects = effects
+	}
+	refs := sec.refs[:0]
+	for _, nrf := range sec.refs {
+		if !drop[nrf.site] {
+			nrf.site = idx[nrf.site]
+			refs = append(refs, nrf)
+		}
+	}
+	sec.refs = refs
+}
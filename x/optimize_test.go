@@ -0,0 +1,86 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_optimize(t *testing.T) {
+	in := []interface{}{
+		".data",
+		".out", "r:", 0, 0, 0,
+
+		".entry", "main:",
+		5, "push", 1, "push", "pop", // 5 :
+		"dup", "swap", 2, "push", "add", // 5 7 :
+		"add", ":r", "push", "storeTo", // :   -- r[0] = 12
+		3, "push", // i :
+		"loop:", 1, "sub", "dup", ":cont", "jnz", ":done", "jump", // threaded on to next
+		"cont:", ":loop", "jump", // jnz threaded on to loop
+		"done:", ":next", "jump", // removed
+		"next:", "pop", // :
+		7, "push", "mid:", "pop", // not removed, since mid is a label
+		":.f", "call", ":r+4", "storeTo",
+		0, "halt",
+
+		".spanOpen", ".f:", 9, "push", "dup", "pop", "ret", ".spanClose",
+	}
+
+	plain, err := Assemble(in...)
+	require.NoError(t, err, "unexpected plain assemble error")
+
+	var (
+		rep OptimizeReport
+		lst Listing
+	)
+	prog, err := NewAssembler(
+		Optimize(func(r OptimizeReport) { rep = r }),
+		WithListing(func(l Listing) { lst = l }),
+	).Assemble(in...)
+	require.NoError(t, err, "unexpected optimized assemble error")
+	assert.True(t, len(prog) < len(plain), "expected a smaller program")
+
+	assert.Equal(t, map[string]int{
+		"push pop":     1,
+		"dup swap":     1,
+		"dup pop":      1,
+		"push add":     1,
+		"push storeTo": 1,
+		"jump jump":    2,
+		"jump next":    1,
+	}, rep.Rewrites, "expected rewrites")
+	assert.Equal(t, 3, rep.Before["jump"], "expected jumps before")
+	assert.Equal(t, 2, rep.After["jump"], "expected jumps after")
+	assert.Equal(t, 2, rep.After["pop"], "expected the labeled pop to be kept")
+
+	found := false
+	for _, ent := range lst {
+		if strings.HasSuffix(ent.Token, " jnz (:loop)") {
+			found = true
+		}
+	}
+	assert.True(t, found, "expected a threaded jnz")
+
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+	assert.Equal(t, map[string][]uint32{"r": {12, 9, 0}}, vals)
+
+	spans := 0
+	for _, addr := range dbg.SpanAddrs() {
+		if open, _ := dbg.Span(addr); open {
+			spans++
+			assert.Equal(t, []string{".f"}, dbg.Labels(addr), "expected the span to open at .f")
+		}
+	}
+	assert.Equal(t, 1, spans, "expected one span")
+}
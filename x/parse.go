@@ -0,0 +1,432 @@
+package xstackvm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	errUnterminatedString = errors.New("unterminated string")
+	errUnexpectedString   = errors.New(`unexpected string; only .include takes a "string"`)
+	errIncludeWant        = errors.New(`expected .include "file"`)
+	errUnterminatedEffect = errors.New("unterminated stack effect")
+)
+
+// Pos is a position within assembly source text; lines and columns count
+// from 1, columns in runes.
+type Pos struct {
+	File      string
+	Line, Col int
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Col)
+}
+
+// SourceError is an error located within assembly source text.
+type SourceError struct {
+	Pos
+	Err error
+}
+
+// Cause returns the located error.
+func (se SourceError) Cause() error { return se.Err }
+
+func (se SourceError) Error() string { return fmt.Sprintf("%v: %v", se.Pos, se.Err) }
+
+// Source is parsed assembly source text: the token stream taken by
+// Assemble, and the position of each token. The text syntax is line oriented
+// and whitespace separated, with the same tokens as Go programs use:
+//
+//	; comments start with ";" or "#", and run to the end of the line
+//	.data
+//	.out v: 0           ; directives, labels, and data words
+//	.text
+//	main:
+//	    5 push :v storeTo ; immediates, and ":ref"s, precede their op
+//	    'a' push          ; character literals are ints
+//	    0 halt
+//	.include "lib.svm"  ; included relative to the including file
+//	.export double      ; ...which may export only some of its labels
+//	.loop:              ; labels like .loop are local to the file
+//	double: ( n -- 2n ) ; a routine's stack effect follows its label
+//	.macro twice $op    ; macros take "$param"s, and expand by ".name arg..."
+//	    $op $op
+//	.endm
+//
+// Integers may be given in any form understood by strconv.ParseInt with base
+// 0, e.g. 42, -1, 0x2a, or 0b101010. Words like ":values+4*3" or "SIZE*2" are
+// immediate expressions; they must not contain spaces.
+type Source struct {
+	Toks []interface{}
+	Pos  []Pos
+	incs map[int]*Source // included sources, by the index of their token
+}
+
+// ParseFile reads and parses an assembly source file.
+func ParseFile(name string) (*Source, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSource(name, bytes.NewReader(buf))
+}
+
+// ParseSource parses assembly source text read from r; name is used in
+// error positions, and to resolve any relative .include paths.
+func ParseSource(name string, r io.Reader) (*Source, error) {
+	p := parser{including: make(map[string]bool)}
+	if key, err := filepath.Abs(name); err == nil {
+		p.including[key] = true
+	}
+	return p.parse(name, r)
+}
+
+// AssembleFile parses and assembles an assembly source file.
+func AssembleFile(name string, opts ...Option) ([]byte, error) {
+	src, err := ParseFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return src.Assemble(opts...)
+}
+
+// ParseJSON parses a JSON array of assembler tokens, like those taken by
+// Assemble: numbers become ints, strings are passed through, and nested arrays
+// (i.e. the argument to .include) are parsed recursively.
+func ParseJSON(r io.Reader) ([]interface{}, error) {
+	var toks []interface{}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&toks); err != nil {
+		return nil, err
+	}
+	return toks, jsonTokens(toks)
+}
+
+func jsonTokens(toks []interface{}) error {
+	for i, tok := range toks {
+		switch v := tok.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return fmt.Errorf("invalid token[%d]: %v", i, err)
+			}
+			toks[i] = int(n)
+		case []interface{}:
+			if err := jsonTokens(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadProgram loads a program from a file, which may contain assembly source
+// text (if named "*.svm"), a JSON array of assembler tokens, a fully linked
+// Object, or an already assembled program. Programs assembled from source
+// text embed a source map.
+func LoadProgram(name string) ([]byte, error) {
+	if strings.HasSuffix(name, ".svm") {
+		src, err := ParseFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return src.Assemble(src.SourceMap())
+	}
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(buf)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		obj, err := ReadObject(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		return obj.Assemble()
+	}
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return buf, nil
+	}
+	toks, err := ParseJSON(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(toks...)
+}
+
+// Assemble assembles the parsed source, returning any error that can be
+// attributed to a token as a SourceError.
+func (src *Source) Assemble(opts ...Option) ([]byte, error) {
+	prog, err := NewAssembler(opts...).Assemble(src.Toks...)
+	if err != nil {
+		return nil, src.Locate(err)
+	}
+	return prog, nil
+}
+
+// AssembleObject assembles the parsed source into a relocatable Object,
+// returning any error that can be attributed to a token as a SourceError.
+func (src *Source) AssembleObject(opts ...Option) (*Object, error) {
+	obj, err := NewAssembler(opts...).AssembleObject(src.Toks...)
+	if err != nil {
+		return nil, src.Locate(err)
+	}
+	return obj, nil
+}
+
+// Locate returns a SourceError for an error returned by assembling the
+// source's tokens, if it can be attributed to a token; otherwise the error is
+// returned as-is.
+func (src *Source) Locate(err error) error {
+	te, ok := err.(tokenError)
+	if !ok {
+		return err
+	}
+	if pos, ok := src.Position(te.at); ok {
+		return SourceError{pos, te.err}
+	}
+	return te.err
+}
+
+// Position returns the position of the token at the given origin, as passed
+// to a SourceMap function; an origin within an .include is located within
+// the included source.
+func (src *Source) Position(at []int) (Pos, bool) {
+	for i, j := range at {
+		if j < 0 {
+			j = 0
+		}
+		if j >= len(src.Pos) {
+			j = len(src.Pos) - 1
+		}
+		if j < 0 {
+			break
+		}
+		if i < len(at)-1 {
+			if inc := src.incs[j]; inc != nil {
+				src = inc
+				continue
+			}
+		}
+		return src.Pos[j], true
+	}
+	return Pos{}, false
+}
+
+// SourceMap returns an assembler Option that embeds the source position of
+// each program token as debug info.
+func (src *Source) SourceMap() Option {
+	return SourceMap(func(at []int) string {
+		if pos, ok := src.Position(at); ok {
+			return pos.String()
+		}
+		return ""
+	})
+}
+
+type parser struct {
+	including map[string]bool
+}
+
+func (p *parser) parse(name string, r io.Reader) (*Source, error) {
+	src := &Source{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if err := p.parseLine(src, name, line, sc.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if n := len(src.Toks); n > 0 {
+		if s, ok := src.Toks[n-1].(string); ok && s == ".include" {
+			return nil, SourceError{src.Pos[n-1], errIncludeWant}
+		}
+	}
+	return src, nil
+}
+
+func (p *parser) parseLine(src *Source, name string, line int, text string) error {
+	col := 1
+	for len(text) > 0 {
+		r, n := utf8.DecodeRuneInString(text)
+		if unicode.IsSpace(r) {
+			text = text[n:]
+			col++
+			continue
+		}
+		if r == ';' || r == '#' {
+			return nil
+		}
+
+		pos := Pos{name, line, col}
+		var word string
+		if r == '"' || r == '\'' {
+			end := quoted(text)
+			if end < 0 {
+				return SourceError{pos, errUnterminatedString}
+			}
+			word = text[:end]
+		} else if isEffect(text) {
+			end := strings.IndexByte(text, ')')
+			if end < 0 {
+				return SourceError{pos, errUnterminatedEffect}
+			}
+			word = text[:end+1]
+		} else {
+			end := len(text)
+			for i, r := range text {
+				if unicode.IsSpace(r) || r == ';' {
+					end = i
+					break
+				}
+			}
+			word = text[:end]
+		}
+		text = text[len(word):]
+		col += utf8.RuneCountInString(word)
+
+		if err := p.addWord(src, pos, word); err != nil {
+			if _, ok := err.(SourceError); ok {
+				return err
+			}
+			return SourceError{pos, err}
+		}
+	}
+	return nil
+}
+
+// isEffect returns true if s starts with a stack effect, like "( a -- b )";
+// unlike an expression, its "(" is followed by a space.
+func isEffect(s string) bool {
+	return len(s) > 1 && s[0] == '(' && unicode.IsSpace(rune(s[1]))
+}
+
+// quoted returns the length of the quoted string or character literal at the
+// start of s, or -1 if it's unterminated.
+func quoted(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func (p *parser) addWord(src *Source, pos Pos, word string) error {
+	includeArg := false
+	if n := len(src.Toks); n > 0 {
+		if s, ok := src.Toks[n-1].(string); ok && s == ".include" {
+			includeArg = true
+		}
+	}
+
+	switch {
+	case word[0] == '"':
+		if !includeArg {
+			return errUnexpectedString
+		}
+		file, err := strconv.Unquote(word)
+		if err != nil {
+			return err
+		}
+		return p.include(src, pos, file)
+
+	case includeArg:
+		return errIncludeWant
+
+	case word[0] == '\'':
+		val, _, tail, err := strconv.UnquoteChar(word[1:len(word)-1], '\'')
+		if err != nil || tail != "" {
+			return fmt.Errorf("invalid character literal %s", word)
+		}
+		src.add(pos, int(val))
+
+	default:
+		if val, ok, err := parseInt(word); err != nil {
+			return err
+		} else if ok {
+			src.add(pos, val)
+		} else {
+			src.add(pos, word)
+		}
+	}
+	return nil
+}
+
+// parseInt parses a word as an int if it looks like one (starts with a digit,
+// or a minus sign followed by one), and isn't an expression.
+func parseInt(word string) (int, bool, error) {
+	digits := word
+	if digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || digits[0] < '0' || digits[0] > '9' {
+		return 0, false, nil
+	}
+	if strings.ContainsAny(word[1:], exprOps) {
+		return 0, false, nil // an expression, like 4*3
+	}
+	if word[0] != '-' {
+		// allow the full range of unsigned words, e.g. 0xffffffff
+		if n, err := strconv.ParseUint(word, 0, 32); err == nil {
+			return int(n), true, nil
+		}
+	}
+	n, err := strconv.ParseInt(word, 0, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid integer %q", word)
+	}
+	return int(n), true, nil
+}
+
+func (p *parser) include(src *Source, pos Pos, file string) error {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(pos.File), file)
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = file
+	}
+	if p.including[key] {
+		return fmt.Errorf("include cycle through %q", file)
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	p.including[key] = true
+	inc, err := p.parse(file, bytes.NewReader(buf))
+	delete(p.including, key)
+	if err != nil {
+		return err
+	}
+	if src.incs == nil {
+		src.incs = make(map[int]*Source)
+	}
+	src.incs[len(src.Toks)] = inc
+	src.add(pos, inc.Toks)
+	return nil
+}
+
+func (src *Source) add(pos Pos, tok interface{}) {
+	src.Toks = append(src.Toks, tok)
+	src.Pos = append(src.Pos, pos)
+}
@@ -0,0 +1,113 @@
+package xstackvm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "stackvm-parse")
+	require.NoError(t, err)
+	for name, text := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644))
+	}
+	return dir
+}
+
+func TestParseSource(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.svm": `
+; a program that stores to its output
+.data
+.out v: 0 ; the result
+.alloc 2
+
+.text
+.entry main:
+	'A' push :v storeTo   # immediates precede their op
+	0x10 :double call
+	-1 pop
+	0 halt
+.include "lib.svm"
+`,
+		"lib.svm": `
+double: 2 mul ret
+`,
+		"bad.svm": `
+.entry main:
+	1 push
+	5 frob
+`,
+		"badlib.svm": `
+.entry main: 0 halt
+.include "bad_inc.svm"
+`,
+		"bad_inc.svm": `
+	nop
+	:nope jmp
+`,
+		"cycle.svm": `.include "cycle.svm"`,
+	})
+	defer os.RemoveAll(dir)
+
+	src, err := ParseFile(filepath.Join(dir, "main.svm"))
+	require.NoError(t, err, "unexpected parse error")
+	assert.Equal(t, []interface{}{
+		".data",
+		".out", "v:", 0,
+		".alloc", 2,
+		".text",
+		".entry", "main:",
+		65, "push", ":v", "storeTo",
+		16, ":double", "call",
+		-1, "pop",
+		0, "halt",
+		".include", []interface{}{
+			"double:", 2, "mul", "ret",
+		},
+	}, src.Toks)
+	require.Len(t, src.Pos, len(src.Toks))
+	assert.Equal(t, "main.svm:9:2", filepath.Base(src.Pos[9].String()), "expected 'A' position")
+	assert.Equal(t, "main.svm:9:11", filepath.Base(src.Pos[11].String()), "expected :v position")
+
+	prog, err := src.Assemble()
+	require.NoError(t, err, "unexpected assemble error")
+	assert.Equal(t, MustAssemble(src.Toks...), prog)
+
+	for _, c := range []struct {
+		name, file, err string
+	}{
+		{"unknown op", "bad.svm", "bad.svm:4:4: "},
+		{"error in include", "badlib.svm", `bad_inc.svm:3:8: no such operation "jmp"`},
+		{"include cycle", "cycle.svm", `cycle.svm:1:10: include cycle`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := AssembleFile(filepath.Join(dir, c.file))
+			require.Error(t, err, "expected error")
+			msg := strings.TrimPrefix(err.Error(), dir+string(filepath.Separator))
+			assert.True(t, strings.HasPrefix(msg, c.err), "expected %q to start with %q", msg, c.err)
+		})
+	}
+
+	for _, c := range []struct{ name, text, err string }{
+		{"unterminated", `.include "foo`, "x.svm:1:10: unterminated string"},
+		{"stray string", `"foo" push`, `x.svm:1:1: unexpected string; only .include takes a "string"`},
+		{"include without file", `.include main:`, `x.svm:1:10: expected .include "file"`},
+		{"bad int", "  0xfffffffff push", `x.svm:1:3: invalid integer "0xfffffffff"`},
+		{"bad char", "'ab' push", "x.svm:1:1: invalid character literal 'ab'"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseSource("x.svm", strings.NewReader(c.text))
+			require.Error(t, err, "expected error")
+			assert.Equal(t, c.err, err.Error())
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_relax(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		nops  int
+		jsize int // expected encoded size of the forward jump
+	}{
+		{"short", 4, 2},
+		{"long", 200, 3},
+		{"longer", 20000, 4},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			in := []interface{}{
+				".data", ".out", "r:", 0,
+				".entry", "main:",
+				":skip", "jump",
+			}
+			for i := 0; i < c.nops; i++ {
+				in = append(in, "nop")
+			}
+			in = append(in,
+				"skip:", 3, "push",
+				"loop:", 1, "sub", "dup", ":loop", "jnz",
+				7, "push", ":r", "storeTo",
+				0, "halt",
+			)
+
+			var lst Listing
+			prog, err := NewAssembler(WithListing(func(l Listing) { lst = l })).Assemble(in...)
+			require.NoError(t, err, "unexpected assemble error")
+			found := false
+			for _, ent := range lst {
+				if strings.HasSuffix(ent.Token, " jump (:skip)") {
+					found = true
+					assert.Len(t, ent.Bytes, c.jsize, "expected a minimal jump encoding")
+				}
+			}
+			assert.True(t, found, "expected a listed jump")
+
+			m, err := stackvm.New(prog)
+			require.NoError(t, err, "unexpected build error")
+			require.NoError(t, m.Run(), "unexpected run error")
+			vals, err := m.NamedValues()
+			require.NoError(t, err, "unexpected values error")
+			assert.Equal(t, map[string][]uint32{"r": {7}}, vals)
+		})
+	}
+}
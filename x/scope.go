@@ -0,0 +1,166 @@
+package xstackvm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// labelScope holds the labels defined by an included sub-program. Labels
+// named like ".loop" are always local to their scope. Other labels are public
+// unless the scope exports any, in which case only those exported are.
+//
+// While scanning, labels keep their given names, shadowing any prior outer
+// definition; when the scope closes, its local labels (and any references to
+// them from within the scope) are renamed to be qualified by the scope's name,
+// e.g. "lib.loop".
+type labelScope struct {
+	name     string
+	exports  map[string]bool
+	defined  map[string]bool
+	labels   []string
+	shadowed map[string]string // prior outer definitions, moved aside
+	mark     scopeMark
+}
+
+// scopeMark notes where a scope started within the assembler's sections.
+type scopeMark struct{ adlToks, adlRefs, optRefs, progRefs int }
+
+const defaultScopeName = "include"
+
+func (asm *assembler) newScope() *labelScope {
+	return &labelScope{
+		name: defaultScopeName,
+		mark: scopeMark{
+			adlToks:  len(asm.adls.toks),
+			adlRefs:  len(asm.adls.refs),
+			optRefs:  len(asm.opts.refs),
+			progRefs: len(asm.prog.refs),
+		},
+	}
+}
+
+func (scope *labelScope) public(label string) bool {
+	if label[0] == '.' {
+		return false
+	}
+	return scope.exports == nil || scope.exports[label]
+}
+
+func (scope *labelScope) qualify(label string) string {
+	return scope.name + "." + strings.TrimPrefix(label, ".")
+}
+
+// defineLabel notes a label defined within the scope, moving aside any
+// outer definition that it shadows.
+func (sc *scanner) defineLabel(scope *labelScope, name string) error {
+	if scope.defined[name] {
+		return fmt.Errorf("label %q already defined", name)
+	}
+	if i, defined := sc.prog.labels[name]; defined && i >= 0 {
+		tmp := sc.prog.genLabel(".shadowed." + name)
+		delete(sc.prog.labels, name)
+		sc.prog.labels[tmp] = i
+		if scope.shadowed == nil {
+			scope.shadowed = make(map[string]string, 1)
+		}
+		scope.shadowed[name] = tmp
+	}
+	if scope.defined == nil {
+		scope.defined = make(map[string]bool)
+	}
+	scope.defined[name] = true
+	scope.labels = append(scope.labels, name)
+	return nil
+}
+
+func (sc *scanner) closeScope(scope *labelScope) error {
+	var undefined []string
+	for name := range scope.exports {
+		if !scope.defined[name] {
+			undefined = append(undefined, name)
+		}
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return fmt.Errorf("undefined exported labels: %q", undefined)
+	}
+
+	for _, name := range scope.labels {
+		if !scope.public(name) {
+			new := sc.prog.genLabel(scope.qualify(name))
+			sc.renameScoped(name, new, scope.mark)
+			delete(sc.unkRets, name)
+			if sc.locals == nil {
+				sc.locals = make(map[string]bool)
+			}
+			sc.locals[new] = true
+		}
+	}
+
+	names := make([]string, 0, len(scope.shadowed))
+	for name := range scope.shadowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if i, defined := sc.prog.labels[name]; defined && i >= 0 {
+			return fmt.Errorf("label %q already defined", name)
+		}
+		tmp := scope.shadowed[name]
+		sc.prog.labels[name] = sc.prog.labels[tmp]
+		delete(sc.prog.labels, tmp)
+	}
+	return nil
+}
+
+// renameScoped renames a label definition, and any references to it made
+// since the given mark.
+func (asm *assembler) renameScoped(old, new string, mark scopeMark) {
+	if n, defined := asm.prog.labels[old]; defined {
+		delete(asm.prog.labels, old)
+		asm.prog.labels[new] = n
+	}
+	asm.adls.renameRefs(old, new, mark.adlRefs)
+	asm.opts.renameRefs(old, new, mark.optRefs)
+	asm.prog.renameRefs(old, new, mark.progRefs)
+	for i := mark.adlToks; i < len(asm.adls.toks); i++ {
+		if tok := asm.adls.toks[i]; tok.kind == addrLabelTK && tok.str == old {
+			tok.str = new
+			asm.adls.toks[i] = tok
+		}
+	}
+}
+
+func (sc *scanner) handleExport() error {
+	name, err := sc.expectString(".export label")
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSuffix(name, ":")
+	if name == "" || name[0] == '.' || name[0] == ':' {
+		return fmt.Errorf("invalid .export %q", name)
+	}
+	if sc.scope == nil {
+		return nil // top-level labels are all public
+	}
+	if sc.scope.exports == nil {
+		sc.scope.exports = make(map[string]bool, 1)
+	}
+	sc.scope.exports[name] = true
+	return nil
+}
+
+func (sc *scanner) handleScope() error {
+	name, err := sc.expectString(".scope name")
+	if err != nil {
+		return err
+	}
+	if !isIdent(name) {
+		return fmt.Errorf("invalid .scope name %q", name)
+	}
+	if sc.scope != nil {
+		sc.scope.name = name
+	}
+	return nil
+}
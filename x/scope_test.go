@@ -0,0 +1,92 @@
+package xstackvm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+)
+
+func TestAssemble_scopes(t *testing.T) {
+	libA := []interface{}{
+		".scope", "a",
+		".export", "quad",
+		"quad:", ":double", "call", ":double", "call", "ret",
+		"double:", 2, "mul", "ret",
+	}
+	libB := []interface{}{
+		".scope", "b",
+		"sextuple:", ":.double", "call", 3, "mul", "ret",
+		".double:", 2, "mul", "ret",
+	}
+
+	prog, err := Assemble(
+		".data",
+		".out", "v:", 0,
+		".entry", "main:",
+		1, "push",
+		":quad", "call",
+		":sextuple", "call",
+		":double", "call",
+		":v", "storeTo",
+		0, "halt",
+		"double:", 5, "mul", "ret",
+		".include", libA,
+		".include", libB,
+	)
+	require.NoError(t, err, "unexpected assemble error")
+
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(prog, stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }))
+	require.NoError(t, err, "unexpected build error")
+	require.NoError(t, m.Run(), "unexpected run error")
+	vals, err := m.NamedValues()
+	require.NoError(t, err, "unexpected values error")
+	assert.Equal(t, map[string][]uint32{"v": {1 * 4 * 6 * 5}}, vals)
+
+	labels := make(map[string]bool)
+	for _, addr := range dbg.LabeledAddrs() {
+		for _, label := range dbg.Labels(addr) {
+			labels[label] = true
+		}
+	}
+	for _, label := range []string{"main", "double", "quad", "a.double", "sextuple", "b.double"} {
+		assert.True(t, labels[label], "expected label %q", label)
+	}
+
+	for _, c := range []struct {
+		name string
+		in   []interface{}
+		err  string
+	}{
+		{"private ref", []interface{}{
+			".entry", "main:", ":double", "call", 0, "halt",
+			".include", libA,
+		}, `undefined labels: ["double"]`},
+		{"local ref", []interface{}{
+			".entry", "main:", ":.double", "call", 0, "halt",
+			".include", libB,
+		}, `undefined labels: [".double"]`},
+		{"undefined export", []interface{}{
+			".include", []interface{}{".export", "nope", "f:", "ret"},
+		}, `undefined exported labels: ["nope"]`},
+		{"public conflict", []interface{}{
+			"f:", "ret",
+			".include", []interface{}{"f:", "ret"},
+		}, `label "f" already defined`},
+		{"duplicate in scope", []interface{}{
+			".include", []interface{}{"f:", "ret", "f:", "ret"},
+		}, `label "f" already defined`},
+		{"invalid export", []interface{}{
+			".include", []interface{}{".export", ".f", ".f:", "ret"},
+		}, `invalid .export ".f"`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Assemble(c.in...)
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package xstackvm
+
+import (
+	"fmt"
+
+	"github.com/jcorbin/stackvm/x/cfg"
+)
+
+// CheckStack sets an Assembler to analyze the stack use of each program it
+// assembles (see cfg.Graph.Stack), checking any declared stack effects, and
+// passing the analysis to the report function, if it isn't nil. If the
+// program's .stackSize is less than it needs, that's logged.
+//
+// A stack effect is declared by a token like "( a b -- c )" following a label.
+func CheckStack(report func(*cfg.StackAnalysis)) Option {
+	return func(asm *assembler) {
+		asm.stackReport = report
+		if report == nil {
+			asm.stackReport = func(*cfg.StackAnalysis) {}
+		}
+	}
+}
+
+func (sc *scanner) handleEffect(s string) error {
+	eff, err := cfg.ParseEffect(s)
+	if err != nil {
+		return err
+	}
+	at := len(sc.prog.toks)
+	labeled := false
+	for _, i := range sc.prog.labels {
+		if i == at {
+			labeled = true
+			break
+		}
+	}
+	if !labeled {
+		return fmt.Errorf("stack effect %v must follow a label", eff)
+	}
+	if sc.prog.effects == nil {
+		sc.prog.effects = make(map[int]cfg.Effect)
+	}
+	sc.prog.effects[at] = eff
+	return nil
+}
+
+// checkStack analyzes the stack use of an encoded program, first re-encoding
+// it with the .stackSize that it needs, if that's "auto".
+func (asm *assembler) checkStack(enc encoder, prog []byte) (encoder, []byte, error) {
+	sa, err := asm.analyzeStack(prog)
+	if err != nil {
+		return enc, prog, err
+	}
+
+	if need := sa.StackSize(); asm.autoStack && need != asm.stackSize() {
+		if len(sa.Problems) > 0 {
+			return enc, prog, fmt.Errorf("can't size the stack automatically: %v", sa.Problems[0])
+		}
+		asm.setOption("stackSize", need)
+		if enc, err = asm.finish(); err == nil {
+			prog, err = enc.encode()
+		}
+		if err == nil {
+			sa, err = asm.analyzeStack(prog)
+		}
+		if err != nil {
+			return enc, prog, err
+		}
+		asm.logf("stackSize set to %d", need)
+	} else if have := asm.stackSize(); need > have {
+		asm.logf("stackSize %d is less than the %d needed", have, need)
+	}
+
+	if asm.stackReport != nil {
+		asm.stackReport(sa)
+	}
+	return enc, prog, nil
+}
+
+func (asm *assembler) analyzeStack(prog []byte) (*cfg.StackAnalysis, error) {
+	g, err := cfg.Build(prog)
+	if err != nil {
+		return nil, err
+	}
+	var effects map[string]cfg.Effect
+	if len(asm.prog.effects) > 0 {
+		effects = make(map[string]cfg.Effect, len(asm.prog.effects))
+		for name, i := range asm.prog.labels {
+			if eff, declared := asm.prog.effects[i]; declared {
+				effects[name] = eff
+			}
+		}
+	}
+	return g.Stack(effects), nil
+}
@@ -0,0 +1,109 @@
+package xstackvm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	. "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/cfg"
+)
+
+const stackSrc = `
+.data
+.out r: 0
+
+.text
+.entry main:
+	1 push 2 push 3 push 4 push 5 push ; 1 2 3 4 5 :
+	:sum call :sum call                ; 1 2 12 :
+	:sum call :sum call                ; 15 :
+	:r storeTo
+	0 halt
+
+sum: ( a b -- c )
+	add ret
+`
+
+func TestCheckStack(t *testing.T) {
+	src, err := ParseSource("stack.svm", strings.NewReader(stackSrc))
+	require.NoError(t, err, "unexpected parse error")
+
+	var sa *cfg.StackAnalysis
+	_, err = src.Assemble(CheckStack(func(a *cfg.StackAnalysis) { sa = a }))
+	require.NoError(t, err, "unexpected assemble error")
+	require.NotNil(t, sa, "expected a stack analysis")
+	assert.Empty(t, sa.Problems, "expected no problems")
+	assert.Equal(t, cfg.Depth{Param: 5, Control: 1}, sa.Max, "expected max depth")
+	assert.Equal(t, 6, sa.MaxWords, "expected max words")
+
+	run := func(t *testing.T, prog []byte) (uint32, error) {
+		m, err := stackvm.New(prog)
+		require.NoError(t, err, "unexpected build error")
+		if err := m.Run(); err != nil {
+			return 0, err
+		}
+		vals, err := m.NamedValues()
+		require.NoError(t, err, "unexpected values error")
+		return vals["r"][0], nil
+	}
+
+	// the needed size suffices, within a word, since the top of the parameter
+	// stack is kept in a register
+	for _, tc := range []struct {
+		size int
+		err  string
+	}{
+		{int(sa.StackSize()), ""},
+		{int(sa.StackSize()) - 8, "param stack"},
+	} {
+		toks := append([]interface{}{".stackSize", tc.size}, src.Toks...)
+		r, err := run(t, MustAssemble(toks...))
+		if tc.err == "" {
+			assert.NoError(t, err, "expected no error with .stackSize %d", tc.size)
+			assert.Equal(t, uint32(15), r, "expected result")
+		} else if assert.Error(t, err, "expected an error with .stackSize %d", tc.size) {
+			assert.Contains(t, err.Error(), tc.err)
+		}
+	}
+
+	// which auto sets, by encoding the program again
+	prog, err := Assemble(append([]interface{}{".stackSize", "auto"}, src.Toks...)...)
+	require.NoError(t, err, "unexpected auto assemble error")
+	m, err := stackvm.New(prog)
+	require.NoError(t, err, "unexpected build error")
+	assert.Equal(t, sa.StackSize(), m.CBP()+4, "expected the needed stack size")
+	r, err := run(t, prog)
+	assert.NoError(t, err, "unexpected run error")
+	assert.Equal(t, uint32(15), r, "expected result")
+}
+
+func TestCheckStack_effects(t *testing.T) {
+	var sa *cfg.StackAnalysis
+	_, err := NewAssembler(CheckStack(func(a *cfg.StackAnalysis) { sa = a })).Assemble(
+		".entry", "main:",
+		1, "push", 2, "push", ":f", "call", 0, "halt",
+		"f:", "( a b -- c )", "pop", "pop", "ret",
+	)
+	require.NoError(t, err, "unexpected assemble error")
+	if assert.Len(t, sa.Problems, 1, "expected a problem") {
+		assert.Equal(t, "effect takes 2 leaves 0, but declared ( a b -- c )", sa.Problems[0].Msg)
+	}
+
+	_, err = Assemble(
+		".stackSize", "auto",
+		".entry", "main:", 1, "push", "add", 0, "halt",
+	)
+	if assert.Error(t, err, "expected an auto size error") {
+		assert.Contains(t, err.Error(), "can't size the stack automatically")
+		assert.Contains(t, err.Error(), "parameter stack underflow")
+	}
+
+	_, err = Assemble(".entry", "main:", 1, "push", "( a -- b )", 0, "halt")
+	if assert.Error(t, err, "expected an unlabeled effect error") {
+		assert.Contains(t, err.Error(), "must follow a label")
+	}
+}
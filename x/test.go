@@ -40,7 +40,7 @@ func init() {
 	flag.BoolVar(&dumper.DumpPointers, "stackvm.test.dumptrs", false,
 		"annotate memory dumps with pointer addresses")
 	flag.Var(&dumpMemFlag, "stackvm.test.dumpmem",
-		"dump memory when the given predicates are true (FIXME predicates?!?)")
+		"dump memory when the given predicates are true; e.g. \"watch\" dumps whenever an output changes")
 }
 
 // TestCases is list of test cases for stackvm.
@@ -80,7 +80,8 @@ type testCaseRun struct {
 	testing.TB
 	Logf func(string, ...interface{})
 	TestCase
-	dbg stackvm.DebugInfo
+	dbg      stackvm.DebugInfo
+	optimize bool
 }
 
 // Run runs each test case in a sub-test.
@@ -127,7 +128,8 @@ func (tc TestCase) LogTo(w io.Writer) TestCase {
 }
 
 // Run runs the test case; it either succeeds quietly, or fails with a trace
-// log.
+// log. Test cases given an assembly program are run again with it optimized
+// (see Optimize).
 func (tc TestCase) Run(t *testing.T) {
 	run := testCaseRun{
 		TB:       t,
@@ -137,6 +139,13 @@ func (tc TestCase) Run(t *testing.T) {
 	if watching || run.canaryFailed() {
 		run.trace()
 	}
+	if _, isAsm := tc.Prog.([]interface{}); isAsm && !t.Failed() {
+		run.optimize = true
+		if run.canaryFailed() {
+			t.Logf("failed when optimized")
+			run.trace()
+		}
+	}
 }
 
 // Bench benchmarks the test case.
@@ -222,10 +231,16 @@ func (t testCaseRun) trace() {
 		tracer.NewLogTracer(t.Logf, t.dbg),
 		tracer.Filtered(
 			tracer.FuncTracer(func(m *stackvm.Mach) {
-				_ = dumper.Dump(m, t.contextLog(m))
+				_ = dumper.DumpDebug(m, t.dbg, t.contextLog(m))
 			}),
 			dumpMemFlag.Build(),
 		),
+		tracer.WatchOutputs(tracer.WatchFiltered(
+			func(m *stackvm.Mach, ev tracer.WatchEvent) {
+				_ = dumper.DumpDebug(m, t.dbg, t.contextLog(m))
+			},
+			dumpMemFlag.Build(),
+		)),
 	)
 	require.NoError(t, err, "unexpected build error")
 	t.checkError(m.Trace(trc))
@@ -243,12 +258,19 @@ func (t *testCaseRun) setDebugInfo(dbg stackvm.DebugInfo) {
 }
 
 func (t *testCaseRun) build() (m *stackvm.Mach, fin finisher, err error) {
-	var prog []byte
+	var (
+		prog []byte
+		rep  *OptimizeReport
+	)
 	switch v := t.Prog.(type) {
 	case []byte:
 		prog = v
 	case []interface{}:
-		prog, err = Assemble(v...)
+		var opts []Option
+		if t.optimize {
+			opts = append(opts, Optimize(func(r OptimizeReport) { rep = &r }))
+		}
+		prog, err = NewAssembler(opts...).Assemble(v...)
 		if err != nil {
 			return
 		}
@@ -258,6 +280,10 @@ func (t *testCaseRun) build() (m *stackvm.Mach, fin finisher, err error) {
 	}
 
 	if dumpProgFlag {
+		if rep != nil {
+			t.Logf("Optimized Program:")
+			t.logLines(strings.TrimSuffix(rep.String(), "\n"))
+		}
 		// TODO: reconcile with stackvm/x/dumper
 		t.Logf("Program to Load:")
 		t.logLines(hex.Dump(prog))
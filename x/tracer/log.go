@@ -17,17 +17,27 @@ func NewLogTracer(
 	f func(string, ...interface{}),
 	dbg stackvm.DebugInfo,
 ) stackvm.Tracer {
-	return &logfTracer{
-		f:   f,
-		dbg: dbg,
+	lf := &logfTracer{
+		f:       f,
+		dbg:     dbg,
+		regions: make(map[*stackvm.Mach][]stackvm.Region),
 	}
+	lf.outs.regions = func(m *stackvm.Mach) []stackvm.Region { return lf.regions[m] }
+	return lf
 }
 
 type logfTracer struct {
-	f          func(string, ...interface{})
-	dbg        stackvm.DebugInfo
-	afterName  string
-	afterFetch uint32
+	f       func(string, ...interface{})
+	dbg     stackvm.DebugInfo
+	outs    watcher                            // notes output values changed by each store op
+	regions map[*stackvm.Mach][]stackvm.Region // output regions of each running machine
+}
+
+// storeOps may change an output value; the stack may only spill into
+// memory below the outputs, so no other op needs watching.
+var storeOps = map[string]bool{
+	"store": true, "storeTo": true,
+	"bitset": true, "bitost": true, "bitseta": true, "bitosta": true,
 }
 
 func (lf logfTracer) Context(m *stackvm.Mach, key string) (interface{}, bool) {
@@ -42,6 +52,7 @@ func (lf logfTracer) Context(m *stackvm.Mach, key string) (interface{}, bool) {
 }
 
 func (lf logfTracer) Begin(m *stackvm.Mach) {
+	lf.regions[m] = outputRegions(m)
 	if nvs, err := m.NamedValues(); err != nil {
 		lf.note(m, "===", "Begin", "values_err=%q pbp=0x%04x cbp=0x%04x", err, m.PBP(), m.CBP())
 	} else {
@@ -50,6 +61,7 @@ func (lf logfTracer) Begin(m *stackvm.Mach) {
 }
 
 func (lf logfTracer) End(m *stackvm.Mach) {
+	defer delete(lf.regions, m)
 	if err := m.Err(); err != nil {
 		lf.note(m, "===", "End", "err=%q", errors.Cause(err))
 	} else if nvs, err := m.NamedValues(); err != nil {
@@ -85,6 +97,9 @@ func (lf *logfTracer) opExtra(ip uint32, op stackvm.Op) string {
 	if spanClose {
 		extra += "spanClose=true "
 	}
+	if src := lf.dbg.Source(ip); src != "" {
+		extra += fmt.Sprintf("src=%q ", src)
+	}
 	return extra
 }
 
@@ -102,38 +117,19 @@ func (lf *logfTracer) Before(m *stackvm.Mach, ip uint32, op stackvm.Op) {
 			extra, ps, cs, m.PSP(), m.CSP())
 	}
 
-	switch op.Name() {
-	case "storeTo":
-		if op.Have {
-			lf.afterFetch = op.Arg
-		} else if len(ps) > 0 {
-			lf.afterFetch = ps[len(ps)-1]
-		}
-
-	case "store":
-		if op.Have && len(ps) > 0 {
-			lf.afterFetch = ps[len(ps)-1]
-		} else if !op.Have && len(ps) > 1 {
-			lf.afterFetch = ps[len(ps)-2]
-		}
-	}
-
-	if lf.afterFetch != 0 {
-		lf.afterName = lf.nameAddr(m, lf.afterFetch)
-		if lf.afterName == "" {
-			lf.afterFetch = 0
-		}
+	if storeOps[op.Name()] {
+		lf.outs.before(m)
 	}
 }
 
 func (lf *logfTracer) After(m *stackvm.Mach, ip uint32, op stackvm.Op) {
 	extra := lf.opExtra(ip, op)
-	if lf.afterName != "" {
-		if val, err := m.Fetch(lf.afterFetch); err == nil {
-			extra += fmt.Sprintf("%s=%v ", lf.afterName, val)
-		}
-		lf.afterName = ""
-		lf.afterFetch = 0
+	if storeOps[op.Name()] {
+		lf.outs.after(m, ip, op, func(m *stackvm.Mach, ev WatchEvent) {
+			if name := lf.nameAddr(m, ev.Addr); name != "" {
+				extra += fmt.Sprintf("%s=%v ", name, ev.New)
+			}
+		})
 	}
 
 	ps, cs, err := m.Stacks()
@@ -149,11 +145,7 @@ func (lf *logfTracer) After(m *stackvm.Mach, ip uint32, op stackvm.Op) {
 }
 
 func (lf logfTracer) nameAddr(m *stackvm.Mach, addr uint32) string {
-	outputs, err := m.Outputs()
-	if err != nil {
-		return ""
-	}
-	for _, rg := range outputs {
+	for _, rg := range lf.regions[m] {
 		if addr >= rg.From && addr < rg.To {
 			if n := (rg.To - rg.From) / 4; n > 1 {
 				return fmt.Sprintf("out_%s[%d]", rg.Name, (addr-rg.From)/4)
@@ -0,0 +1,53 @@
+package tracer_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/tracer"
+)
+
+func TestLogTracer(t *testing.T) {
+	var dbg stackvm.DebugInfo
+	m, err := stackvm.New(xstackvm.MustAssemble(
+		".data",
+		".out", "bits:", 0,
+		".out", "vs:", 0, 0,
+		".entry", "main:",
+		3, "push", ":bits", "bitset",
+		9, "push", ":vs+4", "storeTo",
+		1, "push", 2, "push",
+		":fin", "fork", // the copy stores again
+		0, "halt",
+		"fin:", 7, "push", ":vs", "storeTo",
+		1, "halt",
+	),
+		stackvm.WithDebugInfo(func(di stackvm.DebugInfo) { dbg = di }),
+		stackvm.Handler(stackvm.MachHandlerFunc(func(*stackvm.Mach) error { return nil })),
+	)
+	require.NoError(t, err, "unexpected build error")
+
+	var lines []string
+	require.NoError(t, m.Trace(tracer.Multi(
+		tracer.NewIDTracer(),
+		tracer.NewLogTracer(func(format string, args ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		}, dbg),
+	)), "unexpected run error")
+
+	var changes []string
+	for _, line := range lines {
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "bits=") || strings.HasPrefix(field, "out_vs[") {
+				changes = append(changes, field)
+			}
+		}
+	}
+	assert.Equal(t, []string{"bits=8", "out_vs[1]=9", "out_vs[0]=7"}, changes, "expected each stored output value")
+}
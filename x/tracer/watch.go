@@ -0,0 +1,117 @@
+package tracer
+
+import (
+	"github.com/jcorbin/stackvm"
+	"github.com/jcorbin/stackvm/x/action"
+)
+
+// WatchEvent describes a change to a watched memory word, made by the
+// operation op at ip.
+type WatchEvent struct {
+	Addr     uint32
+	Old, New uint32
+	IP       uint32
+	Op       stackvm.Op
+}
+
+// WatchFunc is called by a Watch tracer for each changed word.
+type WatchFunc func(m *stackvm.Mach, ev WatchEvent)
+
+// Watch creates a tracer that calls the given function whenever an operation
+// changes a word within any of the given [From, To) regions. Words are
+// compared before and after every operation, so any change is caught, be it
+// from a store, a bitset operation, or a stack spill by push.
+func Watch(f WatchFunc, rs ...stackvm.Region) stackvm.Tracer {
+	if f == nil || len(rs) == 0 {
+		return nil
+	}
+	return &watcher{
+		f:       f,
+		regions: func(*stackvm.Mach) []stackvm.Region { return rs },
+	}
+}
+
+// WatchAddrs creates a Watch tracer for individual words.
+func WatchAddrs(f WatchFunc, addrs ...uint32) stackvm.Tracer {
+	rs := make([]stackvm.Region, len(addrs))
+	for i, addr := range addrs {
+		rs[i] = stackvm.Region{From: addr, To: addr + 4}
+	}
+	return Watch(f, rs...)
+}
+
+// WatchOutputs creates a tracer like Watch, that watches each machine's
+// currently defined output regions.
+func WatchOutputs(f WatchFunc) stackvm.Tracer {
+	if f == nil {
+		return nil
+	}
+	return &watcher{f: f, regions: outputRegions}
+}
+
+// WatchFiltered returns a WatchFunc that calls the given one only for
+// changes matching the given predicate; it is tested with the
+// action.TraceWatch action, and the changing operation. This allows a
+// predicate like "watch:bitset" to select changes, e.g. to dump memory.
+func WatchFiltered(f WatchFunc, p action.Predicate) WatchFunc {
+	switch p {
+	case nil, action.Never:
+		return nil
+	case action.Always:
+		return f
+	}
+	return func(m *stackvm.Mach, ev WatchEvent) {
+		if p.Test(action.TraceWatch, ev.IP, ev.Op) {
+			f(m, ev)
+		}
+	}
+}
+
+func outputRegions(m *stackvm.Mach) []stackvm.Region {
+	rs, _ := m.Outputs()
+	return rs
+}
+
+type watcher struct {
+	f       WatchFunc
+	regions func(m *stackvm.Mach) []stackvm.Region
+	rs      []stackvm.Region
+	snap    []uint32
+}
+
+// before snapshots all watched words.
+func (w *watcher) before(m *stackvm.Mach) {
+	w.rs = w.regions(m)
+	w.snap = w.snap[:0]
+	for _, rg := range w.rs {
+		for addr := rg.From; addr < rg.To; addr += 4 {
+			val, _ := m.Fetch(addr)
+			w.snap = append(w.snap, val)
+		}
+	}
+}
+
+// after calls f for every watched word that differs from its snapshot.
+func (w *watcher) after(m *stackvm.Mach, ip uint32, op stackvm.Op, f WatchFunc) {
+	i := 0
+	for _, rg := range w.rs {
+		for addr := rg.From; addr < rg.To; addr += 4 {
+			if i >= len(w.snap) {
+				return
+			}
+			old := w.snap[i]
+			i++
+			if val, _ := m.Fetch(addr); val != old {
+				f(m, WatchEvent{Addr: addr, Old: old, New: val, IP: ip, Op: op})
+			}
+		}
+	}
+}
+
+func (w *watcher) Context(m *stackvm.Mach, key string) (interface{}, bool) { return nil, false }
+func (w *watcher) Begin(m *stackvm.Mach)                                   {}
+func (w *watcher) End(m *stackvm.Mach)                                     {}
+func (w *watcher) Queue(m, n *stackvm.Mach)                                {}
+func (w *watcher) Handle(m *stackvm.Mach, err error)                       {}
+func (w *watcher) Before(m *stackvm.Mach, ip uint32, op stackvm.Op)        { w.before(m) }
+func (w *watcher) After(m *stackvm.Mach, ip uint32, op stackvm.Op)         { w.after(m, ip, op, w.f) }
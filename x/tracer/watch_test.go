@@ -0,0 +1,61 @@
+package tracer_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/stackvm"
+	xstackvm "github.com/jcorbin/stackvm/x"
+	"github.com/jcorbin/stackvm/x/action"
+	"github.com/jcorbin/stackvm/x/tracer"
+)
+
+type watched struct {
+	op       string
+	old, new uint32
+}
+
+func TestWatch(t *testing.T) {
+	m, err := stackvm.New(xstackvm.MustAssemble(
+		".data",
+		".out", "bits:", 0,
+		".out", "v:", 0,
+		".entry", "main:",
+		3, "push", ":bits", "bitset",
+		9, "push", ":v", "storeTo",
+		1, "push", 2, "push",
+		"halt",
+	))
+	require.NoError(t, err, "unexpected build error")
+	outputs, err := m.Outputs()
+	require.NoError(t, err, "unexpected outputs error")
+	require.Len(t, outputs, 2)
+
+	var outs, stack, filtered []watched
+	record := func(ws *[]watched) tracer.WatchFunc {
+		return func(m *stackvm.Mach, ev tracer.WatchEvent) {
+			*ws = append(*ws, watched{ev.Op.Name(), ev.Old, ev.New})
+		}
+	}
+	pred, err := action.ParsePredicate("watch:storeTo")
+	require.NoError(t, err, "unexpected predicate error")
+
+	require.NoError(t, m.Trace(tracer.Multi(
+		tracer.Watch(record(&outs), outputs...),
+		tracer.Watch(record(&stack), stackvm.Region{From: m.PBP(), To: m.CBP()}),
+		tracer.WatchOutputs(tracer.WatchFiltered(record(&filtered), pred)),
+	)), "unexpected run error")
+
+	assert.Equal(t, []watched{
+		{"bitset", 0, 8},
+		{"storeTo", 0, 9},
+	}, outs, "expected bitset and store changes")
+	assert.Equal(t, []watched{
+		{"push", 0, 1},
+	}, stack, "expected push to spill into the stack")
+	assert.Equal(t, []watched{
+		{"storeTo", 0, 9},
+	}, filtered, "expected only predicated changes")
+}